@@ -0,0 +1,170 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/endophage/gotuf"
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/store"
+)
+
+// ErrOffline is returned when an operation needs the notary-server while
+// using only the cached metadata
+var ErrOffline = errors.New("the notary-server can't be reached")
+
+// recordingStore wraps a remote store, recording all the metadata fetched
+// from it so the metadata can be cached once it has been verified.
+type recordingStore struct {
+	store.RemoteStore
+	fetched map[string]json.RawMessage
+}
+
+func newRecordingStore(remote store.RemoteStore) *recordingStore {
+	return &recordingStore{
+		RemoteStore: remote,
+		fetched:     make(map[string]json.RawMessage),
+	}
+}
+
+// GetMeta fetches the named metadata from the remote store
+func (s *recordingStore) GetMeta(name string, size int64) (json.RawMessage, error) {
+	meta, err := s.RemoteStore.GetMeta(name, size)
+	if err != nil {
+		return nil, err
+	}
+	s.fetched[name] = meta
+	return meta, nil
+}
+
+// saveTo writes all the fetched metadata to dir, replacing the metadata
+// saved there. Delegated roles are saved in directories under their parents.
+func (s *recordingStore) saveTo(dir string) error {
+	for name, meta := range s.fetched {
+		path := filepath.Join(dir, filepath.FromSlash(name)+".json")
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, so a failed write
+// doesn't leave truncated metadata behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// checkRollback returns an ErrRollback if the version of any of the
+// metadata in repo is lower than the version saved in dir by the last
+// successful update.
+func checkRollback(dir string, repo *tuf.TufRepo) error {
+	if err := checkDownloaded(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	versions := map[string]int{
+		"root":      repo.Root.Signed.Version,
+		"snapshot":  repo.Snapshot.Signed.Version,
+		"timestamp": repo.Timestamp.Signed.Version,
+	}
+	for role, t := range repo.Targets {
+		versions[role] = t.Signed.Version
+	}
+	for role, version := range versions {
+		seen, err := cachedVersion(dir, role)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if version < seen {
+			return &ErrRollback{Role: role, SeenVersion: seen, Version: version}
+		}
+	}
+	return nil
+}
+
+// checkDownloaded returns an error satisfying os.IsNotExist unless dir holds
+// metadata saved by a recordingStore. The repository's metadata directory
+// also holds the metadata signed when the repository was initialized, which
+// the notary-server may never have seen, but only updates from the
+// notary-server save a timestamp.
+func checkDownloaded(dir string) error {
+	_, err := os.Stat(filepath.Join(dir, data.ValidRoles["timestamp"]+".json"))
+	return err
+}
+
+// cachedVersion returns the version of the metadata for role saved in dir
+func cachedVersion(dir, role string) (int, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(role)+".json"))
+	if err != nil {
+		return 0, err
+	}
+	s := &data.Signed{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return 0, err
+	}
+	common := struct {
+		Version int `json:"version"`
+	}{}
+	if err := json.Unmarshal(s.Signed, &common); err != nil {
+		return 0, err
+	}
+	return common.Version, nil
+}
+
+// offlineStore serves the metadata saved by a recordingStore in place of
+// the remote store. Keys and targets can't be fetched while offline.
+type offlineStore struct {
+	store.MetadataStore
+}
+
+func newOfflineStore(dir string) (*offlineStore, error) {
+	if err := checkDownloaded(dir); err != nil {
+		return nil, fmt.Errorf("no metadata has been cached for offline use: %s", err.Error())
+	}
+	cache, err := store.NewFilesystemStore(dir, "", "json", "")
+	if err != nil {
+		return nil, err
+	}
+	return &offlineStore{MetadataStore: cache}, nil
+}
+
+// GetKey is not supported offline
+func (s *offlineStore) GetKey(role string) ([]byte, error) {
+	return nil, ErrOffline
+}
+
+// GetTarget is not supported offline
+func (s *offlineStore) GetTarget(path string) (io.ReadCloser, error) {
+	return nil, ErrOffline
+}
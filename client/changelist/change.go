@@ -1,5 +1,26 @@
 package changelist
 
+import (
+	"github.com/endophage/gotuf/data"
+)
+
+// Types for TufChanges are namespaced by the Role they
+// are relevant for. The Targets role and its delegations
+// support target and delegation changes, the Root role
+// supports changes to the keys and thresholds of the base
+// roles it defines.
+const (
+	// TypeTargetsTarget is the type of a change adding or removing
+	// a target in a targets role
+	TypeTargetsTarget = "target"
+	// TypeTargetsDelegation is the type of a change creating, updating
+	// or removing a delegated targets role
+	TypeTargetsDelegation = "delegation"
+	// TypeBaseRole is the type of a change to the keys or threshold of
+	// a base role, recorded in the Root role
+	TypeBaseRole = "role"
+)
+
 // TufChange represents a change to a TUF repo
 type TufChange struct {
 	// Abbreviated because Go doesn't permit a field and method of the same name
@@ -8,6 +29,9 @@ type TufChange struct {
 	ChangeType string `json:"type"`
 	ChangePath string `json:"path"`
 	Data       []byte `json:"data"`
+
+	// id identifies the change in the changelist it was listed from
+	id string
 }
 
 // NewTufChange initializes a tufChange object
@@ -21,6 +45,15 @@ func NewTufChange(action int, role, changeType, changePath string, content []byt
 	}
 }
 
+// changeID returns the identity of a change in the changelist it was listed
+// from, or "" if it wasn't listed from a changelist
+func changeID(c Change) string {
+	if tc, ok := c.(*TufChange); ok {
+		return tc.id
+	}
+	return ""
+}
+
 // Action return c.Actn
 func (c TufChange) Action() int {
 	return c.Actn
@@ -45,3 +78,31 @@ func (c TufChange) Path() string {
 func (c TufChange) Content() []byte {
 	return c.Data
 }
+
+// TufDelegation represents the content of a delegation change: the
+// threshold, keys and paths the delegated role should be created or
+// updated with.
+type TufDelegation struct {
+	Threshold        int               `json:"threshold"`
+	Keys             []*data.PublicKey `json:"keys"`
+	Paths            []string          `json:"paths,omitempty"`
+	PathHashPrefixes []string          `json:"path_hash_prefixes,omitempty"`
+}
+
+// ToNewRole creates a fresh role object from the TufDelegation data
+func (td TufDelegation) ToNewRole(name string) (*data.Role, error) {
+	keyIDs := make([]string, 0, len(td.Keys))
+	for _, k := range td.Keys {
+		keyIDs = append(keyIDs, k.ID())
+	}
+	return data.NewRole(name, td.Threshold, keyIDs, td.Paths, td.PathHashPrefixes)
+}
+
+// TufBaseRole represents the content of a base role change: the keys to
+// trust or stop trusting for the role, and its new threshold. A zero
+// threshold leaves the threshold unchanged.
+type TufBaseRole struct {
+	Threshold  int               `json:"threshold,omitempty"`
+	AddKeys    []*data.PublicKey `json:"add_keys,omitempty"`
+	RemoveKeys []string          `json:"remove_keys,omitempty"`
+}
@@ -3,7 +3,9 @@ package changelist
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -33,9 +35,9 @@ func (cl *AppendChangelist) List() []Change {
 	cl.file.Seek(0, 0) // seek to start of file
 	var changes []Change
 	scnr := bufio.NewScanner(cl.file)
-	for scnr.Scan() {
+	for i := 0; scnr.Scan(); i++ {
 		line := scnr.Bytes()
-		c := &TufChange{}
+		c := &TufChange{id: strconv.Itoa(i)}
 		err := json.Unmarshal(line, c)
 		if err != nil {
 			// TODO(david): How should we handle this?
@@ -68,6 +70,32 @@ func (cl *AppendChangelist) Add(c Change) error {
 	return nil
 }
 
+// Remove rewrites the changelist file without the changes at the given
+// indexes in List
+func (cl *AppendChangelist) Remove(idxs []int) error {
+	changes := cl.List()
+	keep, err := removeIndexes(changes, idxs)
+	if err != nil {
+		return err
+	}
+	var entries []byte
+	for _, c := range keep {
+		entry, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		entries = append(append(entries, entry...), '\n')
+	}
+	cl.file.Seek(0, 0)
+	if err := cl.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := cl.file.Write(entries); err != nil {
+		return err
+	}
+	return cl.file.Sync()
+}
+
 // Clear empties the changelist file. It does not currently
 // support archiving
 func (cl *AppendChangelist) Clear(archive string) error {
@@ -77,6 +105,22 @@ func (cl *AppendChangelist) Clear(archive string) error {
 	return nil
 }
 
+// Archive removes the given changes, identified by their line in the file.
+// Like Clear, it does not currently support archiving.
+func (cl *AppendChangelist) Archive(changes []Change, archive string) error {
+	lines := make(map[string]bool)
+	for _, c := range changes {
+		lines[changeID(c)] = true
+	}
+	var idxs []int
+	for i := range cl.List() {
+		if lines[strconv.Itoa(i)] {
+			idxs = append(idxs, i)
+		}
+	}
+	return cl.Remove(idxs)
+}
+
 // Close marks the change list as closed
 func (cl *AppendChangelist) Close() error {
 	cl.file.Sync()
@@ -87,6 +131,12 @@ func (cl *AppendChangelist) Close() error {
 // memChangeList implements a simple in memory change list.
 type memChangelist struct {
 	changes []Change
+	added   int
+}
+
+// NewMemChangelist instantiates a new in-memory changelist
+func NewMemChangelist() Changelist {
+	return &memChangelist{}
 }
 
 // List returns a list of Changes
@@ -94,9 +144,23 @@ func (cl memChangelist) List() []Change {
 	return cl.changes
 }
 
-// Add adds a change to the in-memory change list
+// Add adds a copy of a change to the in-memory change list, numbered so
+// it can be identified by Archive
 func (cl *memChangelist) Add(c Change) error {
-	cl.changes = append(cl.changes, c)
+	cl.added++
+	tc := NewTufChange(c.Action(), c.Scope(), c.Type(), c.Path(), c.Content())
+	tc.id = strconv.Itoa(cl.added)
+	cl.changes = append(cl.changes, tc)
+	return nil
+}
+
+// Remove deletes the changes at the given indexes in List
+func (cl *memChangelist) Remove(idxs []int) error {
+	keep, err := removeIndexes(cl.changes, idxs)
+	if err != nil {
+		return err
+	}
+	cl.changes = keep
 	return nil
 }
 
@@ -107,7 +171,43 @@ func (cl *memChangelist) Clear(archive string) error {
 	return nil
 }
 
+// Archive removes the given changes. Like Clear, it doesn't save them, as
+// there's nowhere to save them to.
+func (cl *memChangelist) Archive(changes []Change, archive string) error {
+	ids := make(map[string]bool)
+	for _, c := range changes {
+		ids[changeID(c)] = true
+	}
+	var keep []Change
+	for _, c := range cl.changes {
+		if !ids[changeID(c)] {
+			keep = append(keep, c)
+		}
+	}
+	cl.changes = keep
+	return nil
+}
+
 // Close is a no-op in this in-memory change-list
 func (cl *memChangelist) Close() error {
 	return nil
 }
+
+// removeIndexes returns the changes that aren't at the given indexes,
+// failing if any of the indexes is out of range
+func removeIndexes(changes []Change, idxs []int) ([]Change, error) {
+	remove := make(map[int]bool)
+	for _, i := range idxs {
+		if i < 0 || i >= len(changes) {
+			return nil, fmt.Errorf("no change at index %d", i)
+		}
+		remove[i] = true
+	}
+	var keep []Change
+	for i, c := range changes {
+		if !remove[i] {
+			keep = append(keep, c)
+		}
+	}
+	return keep, nil
+}
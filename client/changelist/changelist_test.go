@@ -39,3 +39,88 @@ func TestFileChangelist(t *testing.T) {
 	cs = cl.List()
 	assert.Equal(t, 0, len(cs), "List should be empty")
 }
+
+// TestRemove removes changes from each of the changelist implementations,
+// checking the remaining changes keep their order.
+func TestRemove(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	appendCl, err := NewAppendChangelist(path.Join(tmpDir, "list"))
+	assert.Nil(t, err, "Error initializing appendChangelist")
+	defer appendCl.Close()
+	fileCl, err := NewFileChangelist(path.Join(tmpDir, "files"))
+	assert.Nil(t, err, "Error initializing fileChangelist")
+	dbCl, err := NewDBChangelist(path.Join(tmpDir, "changelist.db"))
+	assert.Nil(t, err, "Error initializing DBChangelist")
+
+	for _, cl := range []Changelist{appendCl, fileCl, dbCl, NewMemChangelist()} {
+		for _, p := range []string{"test/targ1", "test/targ2", "test/targ3", "test/targ4"} {
+			err := cl.Add(NewTufChange(ActionCreate, "targets", "target", p, []byte{1}))
+			assert.Nil(t, err, "Non-nil error while adding change")
+		}
+
+		assert.Error(t, cl.Remove([]int{4}), "Removed a change out of range")
+		assert.Len(t, cl.List(), 4, "Changes removed after an error")
+
+		err = cl.Remove([]int{2, 0})
+		assert.Nil(t, err, "Non-nil error while removing changes")
+		cs := cl.List()
+		assert.Len(t, cs, 2, "List should have returned exactly two items")
+		assert.Equal(t, "test/targ2", cs[0].Path(), "Path mismatch")
+		assert.Equal(t, "test/targ4", cs[1].Path(), "Path mismatch")
+	}
+}
+
+// TestArchive archives listed changes from each of the changelist
+// implementations, checking changes added or removed since they were listed
+// are left alone.
+func TestArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	appendCl, err := NewAppendChangelist(path.Join(tmpDir, "list"))
+	assert.Nil(t, err, "Error initializing appendChangelist")
+	defer appendCl.Close()
+	fileCl, err := NewFileChangelist(path.Join(tmpDir, "files"))
+	assert.Nil(t, err, "Error initializing fileChangelist")
+	dbCl, err := NewDBChangelist(path.Join(tmpDir, "changelist.db"))
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	archivedFileCl, err := NewFileChangelist(path.Join(tmpDir, "archive", "files"))
+	assert.Nil(t, err, "Error initializing fileChangelist")
+	archivedDBCl, err := NewDBChangelist(path.Join(tmpDir, "archive", "changelist.db"))
+	assert.Nil(t, err, "Error initializing DBChangelist")
+
+	for _, cl := range []Changelist{appendCl, fileCl, dbCl, NewMemChangelist()} {
+		for _, p := range []string{"test/targ1", "test/targ2", "test/targ3"} {
+			err := cl.Add(NewTufChange(ActionCreate, "targets", "target", p, []byte{1}))
+			assert.Nil(t, err, "Non-nil error while adding change")
+		}
+		listed := cl.List()
+		assert.Nil(t, cl.Add(NewTufChange(ActionCreate, "targets", "target", "test/targ4", []byte{1})))
+		// the appended changelist identifies changes by position, so it
+		// isn't safe against concurrent removals
+		if cl != appendCl {
+			assert.Nil(t, cl.Remove([]int{0}))
+		}
+
+		err = cl.Archive(listed, path.Join(tmpDir, "archive"))
+		assert.Nil(t, err, "Non-nil error while archiving changes")
+		cs := cl.List()
+		assert.Len(t, cs, 1, "List should have returned exactly one item")
+		assert.Equal(t, "test/targ4", cs[0].Path(), "Path mismatch")
+	}
+
+	for _, archived := range []Changelist{archivedFileCl, archivedDBCl} {
+		cs := archived.List()
+		assert.Len(t, cs, 2, "Archive should hold the changes still pending")
+		assert.Equal(t, "test/targ2", cs[0].Path(), "Path mismatch")
+		assert.Equal(t, "test/targ3", cs[1].Path(), "Path mismatch")
+	}
+}
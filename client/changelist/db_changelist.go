@@ -0,0 +1,313 @@
+package changelist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ErrCorruptChangelist is returned when a changelist has been damaged and
+// its changes can't be trusted.
+type ErrCorruptChangelist struct {
+	Path string
+	Msg  string
+}
+
+func (err *ErrCorruptChangelist) Error() string {
+	return fmt.Sprintf("changelist %s is corrupt: %s", err.Path, err.Msg)
+}
+
+// DBChangelist stores all the changes in a single file, like an embedded
+// database. Every operation is a transaction: the file is locked against
+// other processes, and it's rewritten atomically by renaming a new copy over
+// it, so concurrent writers don't lose changes and a crash never leaves a
+// partially written changelist behind. Changes are ordered by sequence
+// number, and each is stored with a checksum to detect corruption. Sequence
+// numbers are never reused, even once the changes are removed, so a change
+// added concurrently with a publish can't be mistaken for a published one.
+type DBChangelist struct {
+	path string
+}
+
+// dbRecord is a change stored in a DBChangelist
+type dbRecord struct {
+	Seq      int64           `json:"seq"`
+	Checksum string          `json:"checksum"`
+	Change   json.RawMessage `json:"change"`
+}
+
+// dbFile is the content of a DBChangelist file. NextSeq is the sequence
+// number of the next change added.
+type dbFile struct {
+	NextSeq int64      `json:"next_seq"`
+	Changes []dbRecord `json:"changes"`
+}
+
+// NewDBChangelist opens the changelist stored in the file at path, which is
+// created by the first change added. An error is returned if the changelist
+// is corrupt.
+func NewDBChangelist(path string) (*DBChangelist, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	cl := &DBChangelist{path: path}
+	err = cl.withLock(syscall.LOCK_SH, func() error {
+		_, err := cl.read()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// List returns the changes ordered by sequence number. Nothing is returned
+// if the changelist is corrupt.
+func (cl *DBChangelist) List() []Change {
+	var f *dbFile
+	err := cl.withLock(syscall.LOCK_SH, func() error {
+		var err error
+		f, err = cl.read()
+		return err
+	})
+	if err != nil {
+		logrus.Error(err.Error())
+		return nil
+	}
+	changes := make([]Change, 0, len(f.Changes))
+	for _, r := range f.Changes {
+		c := &TufChange{id: strconv.FormatInt(r.Seq, 10)}
+		if err := json.Unmarshal(r.Change, c); err != nil {
+			logrus.Error(err.Error())
+			return nil
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// Add appends a change to the changelist, with the next sequence number.
+func (cl *DBChangelist) Add(c Change) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return cl.withLock(syscall.LOCK_EX, func() error {
+		f, err := cl.read()
+		if err != nil {
+			return err
+		}
+		f.Changes = append(f.Changes, dbRecord{Seq: f.NextSeq, Checksum: checksum(cJSON), Change: cJSON})
+		f.NextSeq++
+		return cl.write(f)
+	})
+}
+
+// Remove deletes the changes at the given indexes in List, in a single
+// transaction. The sequence numbers of the remaining changes are kept.
+func (cl *DBChangelist) Remove(idxs []int) error {
+	return cl.withLock(syscall.LOCK_EX, func() error {
+		f, err := cl.read()
+		if err != nil {
+			return err
+		}
+		remove := make(map[int]bool)
+		for _, i := range idxs {
+			if i < 0 || i >= len(f.Changes) {
+				return fmt.Errorf("no change at index %d", i)
+			}
+			remove[i] = true
+		}
+		var keep []dbRecord
+		for i, r := range f.Changes {
+			if !remove[i] {
+				keep = append(keep, r)
+			}
+		}
+		f.Changes = keep
+		return cl.write(f)
+	})
+}
+
+// Clear removes all the changes at once. If archive is provided, a copy of
+// the changelist is saved in that directory first. The sequence numbers of
+// the removed changes aren't reused.
+func (cl *DBChangelist) Clear(archive string) error {
+	return cl.withLock(syscall.LOCK_EX, func() error {
+		f, err := cl.read()
+		if err != nil {
+			return err
+		}
+		if len(f.Changes) == 0 {
+			return nil
+		}
+		if archive != "" {
+			if err := os.MkdirAll(archive, 0700); err != nil {
+				return err
+			}
+			dest := filepath.Join(archive, filepath.Base(cl.path))
+			tmp, err := writeTemp(dest, f)
+			if err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, dest); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+		}
+		f.Changes = nil
+		return cl.write(f)
+	})
+}
+
+// Archive moves the given changes, identified by sequence number, to a
+// changelist of the same name saved in the archive directory, in a single
+// transaction. The archived copy is written before the changes are removed,
+// but only put in place afterwards, so a crash never leaves a change both
+// pending and archived.
+func (cl *DBChangelist) Archive(changes []Change, archive string) error {
+	seqs := make(map[string]bool)
+	for _, c := range changes {
+		seqs[changeID(c)] = true
+	}
+	return cl.withLock(syscall.LOCK_EX, func() error {
+		f, err := cl.read()
+		if err != nil {
+			return err
+		}
+		var archived, keep []dbRecord
+		for _, r := range f.Changes {
+			if seqs[strconv.FormatInt(r.Seq, 10)] {
+				archived = append(archived, r)
+			} else {
+				keep = append(keep, r)
+			}
+		}
+		if len(archived) == 0 {
+			return nil
+		}
+		if err := os.MkdirAll(archive, 0700); err != nil {
+			return err
+		}
+		dest := filepath.Join(archive, filepath.Base(cl.path))
+		tmp, err := writeTemp(dest, &dbFile{NextSeq: f.NextSeq, Changes: archived})
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		f.Changes = keep
+		if err := cl.write(f); err != nil {
+			return err
+		}
+		return os.Rename(tmp, dest)
+	})
+}
+
+// Close is a no-op, as every operation is committed before it returns
+func (cl *DBChangelist) Close() error {
+	return nil
+}
+
+// withLock runs fn holding a lock of the given type on the changelist,
+// which is shared with other processes. The lock is taken on a separate
+// file, as the changelist file itself is replaced by every write.
+func (cl *DBChangelist) withLock(how int, fn func() error) error {
+	lock, err := os.OpenFile(cl.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), how); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// read loads and verifies the changes, it must be called holding a lock
+func (cl *DBChangelist) read() (*dbFile, error) {
+	f := &dbFile{NextSeq: 1}
+	raw, err := ioutil.ReadFile(cl.path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.NextSeq = 0
+	if err := json.Unmarshal(raw, f); err != nil {
+		return nil, &ErrCorruptChangelist{Path: cl.path, Msg: err.Error()}
+	}
+	var last int64
+	for _, r := range f.Changes {
+		if r.Seq <= last {
+			return nil, &ErrCorruptChangelist{Path: cl.path, Msg: fmt.Sprintf("change %d is out of sequence", r.Seq)}
+		}
+		if checksum(r.Change) != r.Checksum {
+			return nil, &ErrCorruptChangelist{Path: cl.path, Msg: fmt.Sprintf("checksum mismatch for change %d", r.Seq)}
+		}
+		last = r.Seq
+	}
+	if f.NextSeq == 0 {
+		// changelists written before the counter was stored continue after
+		// their last change
+		f.NextSeq = last + 1
+	}
+	if f.NextSeq <= last {
+		return nil, &ErrCorruptChangelist{Path: cl.path, Msg: fmt.Sprintf("change %d is after the next sequence number %d", last, f.NextSeq)}
+	}
+	return f, nil
+}
+
+// write atomically replaces the changes, it must be called holding an
+// exclusive lock
+func (cl *DBChangelist) write(f *dbFile) error {
+	tmp, err := writeTemp(cl.path, f)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, cl.path)
+}
+
+// writeTemp writes the changelist to a new temporary file next to path, to
+// be renamed over it, and returns the name of the temporary file
+func writeTemp(path string, f *dbFile) (string, error) {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
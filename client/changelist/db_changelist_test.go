@@ -0,0 +1,140 @@
+package changelist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBChangelist(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	clPath := filepath.Join(tmpDir, "changelist.db")
+	cl, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	assert.Len(t, cl.List(), 0, "List should be empty")
+
+	c1 := NewTufChange(ActionCreate, "targets", "target", "test/targ1", []byte{1})
+	assert.Nil(t, cl.Add(c1), "Non-nil error while adding change")
+	c2 := NewTufChange(ActionDelete, "targets", "target", "test/targ2", nil)
+	assert.Nil(t, cl.Add(c2), "Non-nil error while adding change")
+
+	// changes are listed in the order they were added, by any instance
+	other, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	cs := other.List()
+	assert.Equal(t, 2, len(cs), "List should have returned exactly two items")
+	assert.Equal(t, c1.Action(), cs[0].Action(), "Action mismatch")
+	assert.Equal(t, c1.Path(), cs[0].Path(), "Path mismatch")
+	assert.Equal(t, c1.Content(), cs[0].Content(), "Content mismatch")
+	assert.Equal(t, c2.Action(), cs[1].Action(), "Action 2 mismatch")
+	assert.Equal(t, c2.Path(), cs[1].Path(), "Path 2 mismatch")
+
+	archive := filepath.Join(tmpDir, "archive")
+	assert.Nil(t, cl.Clear(archive), "Non-nil error while clearing")
+	assert.Len(t, cl.List(), 0, "List should be empty")
+	archived, err := NewDBChangelist(filepath.Join(archive, "changelist.db"))
+	assert.Nil(t, err, "Error opening archived changelist")
+	assert.Len(t, archived.List(), 2, "Archive should hold the cleared changes")
+}
+
+func TestDBChangelistConcurrentAdd(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	clPath := filepath.Join(tmpDir, "changelist.db")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cl, err := NewDBChangelist(clPath)
+			assert.Nil(t, err, "Error initializing DBChangelist")
+			c := NewTufChange(ActionCreate, "targets", "target", fmt.Sprintf("test/targ%d", i), nil)
+			assert.Nil(t, cl.Add(c), "Non-nil error while adding change")
+		}(i)
+	}
+	wg.Wait()
+
+	cl, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	assert.Len(t, cl.List(), 20, "Changes were lost")
+}
+
+func TestDBChangelistCorrupt(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	clPath := filepath.Join(tmpDir, "changelist.db")
+	cl, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	c := NewTufChange(ActionCreate, "targets", "target", "test/targ", []byte{1})
+	assert.Nil(t, cl.Add(c), "Non-nil error while adding change")
+
+	raw, err := ioutil.ReadFile(clPath)
+	assert.Nil(t, err)
+	raw = []byte(strings.Replace(string(raw), "test/targ", "test/evil", 1))
+	assert.Nil(t, ioutil.WriteFile(clPath, raw, 0600))
+
+	_, err = NewDBChangelist(clPath)
+	assert.IsType(t, &ErrCorruptChangelist{}, err, "Corruption wasn't detected")
+	assert.Len(t, cl.List(), 0, "List should not return corrupt changes")
+	assert.IsType(t, &ErrCorruptChangelist{}, cl.Add(c), "Added to a corrupt changelist")
+}
+
+// TestDBChangelistSeqNotReused checks changes added after the changelist
+// was cleared, or its last change removed, can't be archived with the
+// changes that were listed before
+func TestDBChangelistSeqNotReused(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	clPath := filepath.Join(tmpDir, "changelist.db")
+	cl, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	archive := filepath.Join(tmpDir, "archive")
+
+	assert.Nil(t, cl.Add(NewTufChange(ActionCreate, "targets", "target", "test/targ1", []byte{1})))
+	listed := cl.List()
+	assert.Nil(t, cl.Clear(""), "Non-nil error while clearing")
+	assert.Nil(t, cl.Add(NewTufChange(ActionCreate, "targets", "target", "test/targ2", []byte{1})))
+	assert.Nil(t, cl.Archive(listed, archive), "Non-nil error while archiving changes")
+	cs := cl.List()
+	assert.Len(t, cs, 1, "A new change was archived")
+
+	listed = cl.List()
+	assert.Nil(t, cl.Remove([]int{0}))
+	assert.Nil(t, cl.Add(NewTufChange(ActionCreate, "targets", "target", "test/targ3", []byte{1})))
+	assert.Nil(t, cl.Archive(listed, archive), "Non-nil error while archiving changes")
+	cs = cl.List()
+	if assert.Len(t, cs, 1, "A new change was archived") {
+		assert.Equal(t, "test/targ3", cs[0].Path(), "Path mismatch")
+	}
+
+	// the counter is kept by other instances
+	other, err := NewDBChangelist(clPath)
+	assert.Nil(t, err, "Error initializing DBChangelist")
+	assert.Nil(t, other.Archive(cs, archive), "Non-nil error while archiving changes")
+	assert.Nil(t, other.Add(NewTufChange(ActionCreate, "targets", "target", "test/targ4", []byte{1})))
+	assert.Nil(t, cl.Archive(cs, archive), "Non-nil error while archiving changes")
+	assert.Len(t, cl.List(), 1, "A new change was archived")
+}
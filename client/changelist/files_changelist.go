@@ -30,15 +30,23 @@ func NewFileChangelist(dir string) (*FileChangelist, error) {
 
 // List returns a list of sorted changes
 func (cl FileChangelist) List() []Change {
+	changes, _ := cl.list()
+	return changes
+}
+
+// list returns the sorted changes along with the names of the files
+// they're stored in
+func (cl FileChangelist) list() ([]Change, []string) {
 	var changes []Change
+	var names []string
 	dir, err := os.Open(cl.dir)
 	if err != nil {
-		return changes
+		return changes, names
 	}
 	defer dir.Close()
 	fileInfos, err := dir.Readdir(0)
 	if err != nil {
-		return changes
+		return changes, names
 	}
 	sort.Sort(fileChanges(fileInfos))
 	for _, f := range fileInfos {
@@ -51,7 +59,7 @@ func (cl FileChangelist) List() []Change {
 			logrus.Warn(err.Error())
 			continue
 		}
-		c := &TufChange{}
+		c := &TufChange{id: f.Name()}
 		err = json.Unmarshal(raw, c)
 		if err != nil {
 			// TODO(david): How should we handle this?
@@ -59,8 +67,26 @@ func (cl FileChangelist) List() []Change {
 			continue
 		}
 		changes = append(changes, c)
+		names = append(names, f.Name())
 	}
-	return changes
+	return changes, names
+}
+
+// Remove deletes the files of the changes at the given indexes in List
+func (cl FileChangelist) Remove(idxs []int) error {
+	_, names := cl.list()
+	for _, i := range idxs {
+		if i < 0 || i >= len(names) {
+			return fmt.Errorf("no change at index %d", i)
+		}
+	}
+	for _, i := range idxs {
+		err := os.Remove(path.Join(cl.dir, names[i]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 // Add adds a change to the file change list
@@ -73,8 +99,19 @@ func (cl FileChangelist) Add(c Change) error {
 	return ioutil.WriteFile(path.Join(cl.dir, filename), cJSON, 0644)
 }
 
-// Clear clears the change list
+// Clear clears the change list. If archive is provided, the change files
+// are moved into a directory of the same name as the changelist's within
+// archive, by renaming the changelist directory at once.
 func (cl FileChangelist) Clear(archive string) error {
+	if archive != "" {
+		if err := os.MkdirAll(archive, 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(cl.dir, path.Join(archive, path.Base(cl.dir))); err != nil {
+			return err
+		}
+		return os.MkdirAll(cl.dir, 0700)
+	}
 	dir, err := os.Open(cl.dir)
 	if err != nil {
 		return err
@@ -90,6 +127,28 @@ func (cl FileChangelist) Clear(archive string) error {
 	return nil
 }
 
+// Archive moves the files of the given changes into a directory of the same
+// name as the changelist's within archive. Each change is moved by renaming
+// its file, so a change is never both pending and archived, and changes
+// whose files have been removed since List are skipped.
+func (cl FileChangelist) Archive(changes []Change, archive string) error {
+	dest := path.Join(archive, path.Base(cl.dir))
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		name := changeID(c)
+		if name == "" {
+			return fmt.Errorf("change to %s was not listed from a changelist", c.Path())
+		}
+		err := os.Rename(path.Join(cl.dir, name), path.Join(dest, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close is a no-op
 func (cl FileChangelist) Close() error {
 	// Nothing to do here
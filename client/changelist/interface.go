@@ -10,11 +10,22 @@ type Changelist interface {
 	// the list of changes
 	Add(Change) error
 
+	// Remove deletes the changes at the given indexes
+	// in the ordered list returned by List
+	Remove(idxs []int) error
+
 	// Clear empties the current change list.
 	// Archive may be provided as a directory path
 	// to save a copy of the changelist in that location
 	Clear(archive string) error
 
+	// Archive removes the given changes, as returned by List,
+	// and saves them in the archive directory in a single
+	// operation. Changes are matched by identity rather than
+	// position, so changes added or removed since List are
+	// left alone.
+	Archive(changes []Change, archive string) error
+
 	// Close syncronizes any pending writes to the underlying
 	// storage and closes the file/connection
 	Close() error
@@ -2,22 +2,27 @@ package client
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary/client/changelist"
 	"github.com/docker/notary/cryptoservice"
+	notaryerrors "github.com/docker/notary/errors"
 	"github.com/docker/notary/keystoremanager"
 	"github.com/docker/notary/trustmanager"
 	"github.com/endophage/gotuf"
 	tufclient "github.com/endophage/gotuf/client"
 	"github.com/endophage/gotuf/data"
+	tuferrors "github.com/endophage/gotuf/errors"
 	"github.com/endophage/gotuf/keys"
 	"github.com/endophage/gotuf/signed"
 	"github.com/endophage/gotuf/store"
@@ -35,8 +40,120 @@ func (err *ErrRepoNotInitialized) Error() string {
 	return "Repository has not been initialized"
 }
 
+// ErrPublishRejected is returned by Publish when the notary server refuses
+// to store the metadata being published, i.e. because it's invalid. Code
+// tells why the server rejected it.
+type ErrPublishRejected struct {
+	StatusCode int
+	Code       notaryerrors.ErrorCode
+	Msg        string
+}
+
+func (err *ErrPublishRejected) Error() string {
+	return fmt.Sprintf("server rejected the update (%d): %s", err.StatusCode, err.Msg)
+}
+
+// ErrUnauthorized is returned when the notary server requires the client to
+// authenticate, or refuses the credentials it was given.
+type ErrUnauthorized struct {
+	Msg string
+}
+
+func (err *ErrUnauthorized) Error() string {
+	return fmt.Sprintf("unauthorized: %s", err.Msg)
+}
+
+// ErrNoServerKey is returned when the notary server doesn't hold the key of
+// a role it's asked for, such as the snapshot key of a repository whose
+// snapshot is signed by the publisher.
+type ErrNoServerKey struct {
+	Code notaryerrors.ErrorCode
+	Msg  string
+}
+
+func (err *ErrNoServerKey) Error() string {
+	return fmt.Sprintf("server has no key: %s", err.Msg)
+}
+
+// ErrInvalidRequest is returned when the notary server refuses a request
+// other than a publish, i.e. because of an invalid parameter. Code tells
+// why the server refused it.
+type ErrInvalidRequest struct {
+	StatusCode int
+	Code       notaryerrors.ErrorCode
+	Msg        string
+}
+
+func (err *ErrInvalidRequest) Error() string {
+	return fmt.Sprintf("server refused the request (%d): %s", err.StatusCode, err.Msg)
+}
+
+// ErrServerUnavailable is returned when the notary server fails to process a
+// request because of a problem on its side, such as an unreachable metadata
+// store or signing service. The request may succeed when retried later.
+type ErrServerUnavailable struct {
+	StatusCode int
+	Code       notaryerrors.ErrorCode
+	Msg        string
+}
+
+func (err *ErrServerUnavailable) Error() string {
+	return fmt.Sprintf("server failed to process the request (%d): %s", err.StatusCode, err.Msg)
+}
+
+// ErrRollback is returned when the notary-server provides metadata for a
+// role with a lower version than the one previously seen, which indicates
+// the server is replaying old metadata.
+type ErrRollback struct {
+	Role        string
+	SeenVersion int
+	Version     int
+}
+
+func (err *ErrRollback) Error() string {
+	return fmt.Sprintf("rollback detected: received version %d of %s after seeing version %d", err.Version, err.Role, err.SeenVersion)
+}
+
+// ErrConflict is returned by Publish when a target modified by the local
+// changes was also modified by an update published since the changes were
+// made, so they can't be applied on top of it.
+type ErrConflict struct {
+	Role string
+	Path string
+}
+
+func (err *ErrConflict) Error() string {
+	return fmt.Sprintf("conflict: %s in %s was modified both locally and on the server", err.Path, err.Role)
+}
+
+// ErrInsufficientSignatures is returned by Publish when the metadata for a
+// role can't be signed by enough of the role's keys to meet its threshold
+// with the keys available locally.
+type ErrInsufficientSignatures struct {
+	Role       string
+	Signatures int
+	Threshold  int
+}
+
+func (err *ErrInsufficientSignatures) Error() string {
+	return fmt.Sprintf("%s has %d valid signatures but requires %d", err.Role, err.Signatures, err.Threshold)
+}
+
 const (
 	tufDir = "tuf"
+	// metadataDir holds the repository's metadata, relative to its
+	// tufRepoPath: the metadata signed when the repository was initialized,
+	// then the last known-good metadata downloaded from the notary-server
+	metadataDir = "metadata"
+	// historyDir holds the changelists archived after they were published,
+	// relative to the repository's tufRepoPath
+	historyDir = "changelist_history"
+	// historyTimeFormat names the changelists in historyDir after the time
+	// they were published, so they sort chronologically
+	historyTimeFormat = "20060102T150405.000000000Z"
+	// publishRetries is the number of times Publish applies the changes
+	// again after conflicting with a concurrent update
+	publishRetries = 3
 )
 
 // ErrRepositoryNotExist gets returned when trying to make an action over a repository
@@ -54,9 +171,55 @@ type NotaryRepository struct {
 	cryptoService   signed.CryptoService
 	tufRepo         *tuf.TufRepo
 	roundTrip       http.RoundTripper
+	offline         bool
+	openChangelist  ChangelistOpener
 	KeyStoreManager *keystoremanager.KeyStoreManager
 }
 
+// RepositoryOption configures optional behaviour of a NotaryRepository
+type RepositoryOption func(*NotaryRepository)
+
+// ChangelistOpener opens the changelist holding the pending changes of the
+// repository whose metadata is stored in tufRepoPath
+type ChangelistOpener func(tufRepoPath string) (changelist.Changelist, error)
+
+// FileChangelist stores each pending change of a repository in a file of its
+// own. It's used unless another changelist is selected with WithChangelist.
+func FileChangelist(tufRepoPath string) (changelist.Changelist, error) {
+	cl, err := changelist.NewFileChangelist(filepath.Join(tufRepoPath, "changelist"))
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// DBChangelist stores all the pending changes of a repository in a single
+// transactional file, so changes added by concurrent processes aren't lost.
+func DBChangelist(tufRepoPath string) (changelist.Changelist, error) {
+	cl, err := changelist.NewDBChangelist(filepath.Join(tufRepoPath, "changelist.db"))
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// WithChangelist selects how the repository stores its pending changes
+func WithChangelist(open ChangelistOpener) RepositoryOption {
+	return func(r *NotaryRepository) {
+		r.openChangelist = open
+	}
+}
+
+// WithOffline lets ListTargets and GetTargetByName fall back to verifying
+// targets against the metadata cached by the last successful update when
+// the notary-server can't be reached or fails to process the request. The
+// cached metadata must still be validly signed and unexpired.
+func WithOffline() RepositoryOption {
+	return func(r *NotaryRepository) {
+		r.offline = true
+	}
+}
+
 // Target represents a simplified version of the data TUF operates on, so external
 // applications don't have to depend on tuf data types.
 type Target struct {
@@ -83,7 +246,7 @@ func NewTarget(targetName string, targetPath string) (*Target, error) {
 // NewNotaryRepository is a helper method that returns a new notary repository.
 // It takes the base directory under where all the trust files will be stored
 // (usually ~/.docker/trust/).
-func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper) (*NotaryRepository, error) {
+func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper, opts ...RepositoryOption) (*NotaryRepository, error) {
 	keyStoreManager, err := keystoremanager.NewKeyStoreManager(baseDir)
 	if err != nil {
 		return nil, err
@@ -98,73 +261,63 @@ func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper) (*N
 		tufRepoPath:     filepath.Join(baseDir, tufDir, filepath.FromSlash(gun)),
 		cryptoService:   cryptoService,
 		roundTrip:       rt,
+		openChangelist:  FileChangelist,
 		KeyStoreManager: keyStoreManager,
 	}
+	for _, opt := range opts {
+		opt(nRepo)
+	}
 
 	return nRepo, nil
 }
 
 // Initialize creates a new repository by using rootKey as the root Key for the
-// TUF repository.
-func (r *NotaryRepository) Initialize(uCryptoService *cryptoservice.UnlockedCryptoService) error {
-	rootCert, err := uCryptoService.GenerateCertificate(r.gun)
-	if err != nil {
-		return err
-	}
-	r.KeyStoreManager.AddTrustedCert(rootCert)
-
-	// The root key gets stored in the TUF metadata X509 encoded, linking
-	// the tuf root.json to our X509 PKI.
-	// If the key is RSA, we store it as type RSAx509, if it is ECDSA we store it
-	// as ECDSAx509 to allow the gotuf verifiers to correctly decode the
-	// key on verification of signatures.
-	var algorithmType data.KeyAlgorithm
-	algorithm := uCryptoService.PrivKey.Algorithm()
-	switch algorithm {
-	case data.RSAKey:
-		algorithmType = data.RSAx509Key
-	case data.ECDSAKey:
-		algorithmType = data.ECDSAx509Key
-	default:
-		return fmt.Errorf("invalid format for root key: %s", algorithm)
+// TUF repository. The timestamp key is always managed by the notary-server,
+// the snapshot key may also be delegated to the server by listing "snapshot"
+// in serverManagedRoles, in which case the server signs a new snapshot for
+// every update that's published.
+func (r *NotaryRepository) Initialize(uCryptoService *cryptoservice.UnlockedCryptoService, serverManagedRoles ...string) error {
+	serverManagesSnapshot := false
+	for _, role := range serverManagedRoles {
+		switch role {
+		case "snapshot":
+			serverManagesSnapshot = true
+		case "timestamp":
+			// the timestamp key is always managed by the server
+		default:
+			return fmt.Errorf("notary-server can't manage the %s key", role)
+		}
 	}
 
-	// Generate a x509Key using the rootCert as the public key
-	rootKey := data.NewPublicKey(algorithmType, trustmanager.CertToPEM(rootCert))
-
-	// Creates a symlink between the certificate ID and the real public key it
-	// is associated with. This is used to be able to retrieve the root private key
-	// associated with a particular certificate
-	logrus.Debugf("Linking %s to %s.", rootKey.ID(), uCryptoService.ID())
-	err = r.KeyStoreManager.RootKeyStore().Link(uCryptoService.ID(), rootKey.ID())
+	rootKey, rootCert, err := r.rootCertKey(uCryptoService)
 	if err != nil {
 		return err
 	}
+	r.KeyStoreManager.AddTrustedCert(rootCert)
 
 	// All the timestamp keys are generated by the remote server.
 	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
-	rawTSKey, err := remote.GetKey("timestamp")
 	if err != nil {
 		return err
 	}
-
-	parsedKey := &data.TUFKey{}
-	err = json.Unmarshal(rawTSKey, parsedKey)
+	timestampKey, err := getRemoteKey(remote, "timestamp")
 	if err != nil {
 		return err
 	}
 
-	// Turn the JSON timestamp key from the remote server into a TUFKey
-	timestampKey := data.NewPublicKey(parsedKey.Algorithm(), parsedKey.Public())
-	logrus.Debugf("got remote %s timestamp key with keyID: %s", parsedKey.Algorithm(), timestampKey.ID())
-
 	// This is currently hardcoding the targets and snapshots keys to ECDSA
-	// Targets and snapshot keys are always generated locally.
+	// Targets keys are always generated locally, snapshot keys are unless
+	// the server has been asked to manage them.
 	targetsKey, err := r.cryptoService.Create("targets", data.ECDSAKey)
 	if err != nil {
 		return err
 	}
-	snapshotKey, err := r.cryptoService.Create("snapshot", data.ECDSAKey)
+	var snapshotKey *data.PublicKey
+	if serverManagesSnapshot {
+		snapshotKey, err = createRemoteKey(r.baseURL, r.gun, r.roundTrip, "snapshot")
+	} else {
+		snapshotKey, err = r.cryptoService.Create("snapshot", data.ECDSAKey)
+	}
 	if err != nil {
 		return err
 	}
@@ -210,7 +363,7 @@ func (r *NotaryRepository) Initialize(uCryptoService *cryptoservice.UnlockedCryp
 
 	r.fileStore, err = store.NewFilesystemStore(
 		r.tufRepoPath,
-		"metadata",
+		metadataDir,
 		"json",
 		"targets",
 	)
@@ -230,11 +383,56 @@ func (r *NotaryRepository) Initialize(uCryptoService *cryptoservice.UnlockedCryp
 	return r.snapshot()
 }
 
+// rootCertKey generates a certificate for the root key held by
+// uCryptoService and returns it, along with the TUF key for the root role
+// that contains it.
+func (r *NotaryRepository) rootCertKey(uCryptoService *cryptoservice.UnlockedCryptoService) (*data.PublicKey, *x509.Certificate, error) {
+	rootCert, err := uCryptoService.GenerateCertificate(r.gun)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The root key gets stored in the TUF metadata X509 encoded, linking
+	// the tuf root.json to our X509 PKI.
+	// If the key is RSA, we store it as type RSAx509, if it is ECDSA we store it
+	// as ECDSAx509 to allow the gotuf verifiers to correctly decode the
+	// key on verification of signatures.
+	var algorithmType data.KeyAlgorithm
+	algorithm := uCryptoService.PrivKey.Algorithm()
+	switch algorithm {
+	case data.RSAKey:
+		algorithmType = data.RSAx509Key
+	case data.ECDSAKey:
+		algorithmType = data.ECDSAx509Key
+	default:
+		return nil, nil, fmt.Errorf("invalid format for root key: %s", algorithm)
+	}
+
+	// Generate a x509Key using the rootCert as the public key
+	rootKey := data.NewPublicKey(algorithmType, trustmanager.CertToPEM(rootCert))
+
+	// Creates a symlink between the certificate ID and the real public key it
+	// is associated with. This is used to be able to retrieve the root private key
+	// associated with a particular certificate
+	logrus.Debugf("Linking %s to %s.", rootKey.ID(), uCryptoService.ID())
+	err = r.KeyStoreManager.RootKeyStore().Link(uCryptoService.ID(), rootKey.ID())
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootKey, rootCert, nil
+}
+
 // AddTarget adds a new target to the repository, forcing a timestamps check from TUF
 func (r *NotaryRepository) AddTarget(target *Target) error {
-	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
-	if err != nil {
-		return err
+	return r.AddTargetToRole(target, data.ValidRoles["targets"])
+}
+
+// AddTargetToRole adds a new target to the given targets role, which may be
+// the base "targets" role or one of its delegations (i.e. "targets/releases").
+// The target's name must fall within the paths delegated to the role.
+func (r *NotaryRepository) AddTargetToRole(target *Target, role string) error {
+	if !isTargetsRole(role) {
+		return tuferrors.ErrInvalidRole{Role: role}
 	}
 	fmt.Printf("Adding target \"%s\" with sha256 \"%s\" and size %d bytes.\n", target.Name, target.Hashes["sha256"], target.Length)
 
@@ -244,31 +442,147 @@ func (r *NotaryRepository) AddTarget(target *Target) error {
 		return err
 	}
 
-	c := changelist.NewTufChange(changelist.ActionCreate, "targets", "target", target.Name, metaJSON)
-	err = cl.Add(c)
+	c := changelist.NewTufChange(changelist.ActionCreate, role, changelist.TypeTargetsTarget, target.Name, metaJSON)
+	return r.addChange(c)
+}
+
+// AddDelegation creates or updates the delegated targets role with the given
+// name (i.e. "targets/releases"), trusting the provided keys to sign it.
+// Targets may only be added to the delegated role if their name starts with
+// one of the paths. The role is created on the next Publish, at which point
+// its parent role must already exist.
+func (r *NotaryRepository) AddDelegation(name string, threshold int, delegationKeys []*data.PublicKey, paths []string) error {
+	td := changelist.TufDelegation{
+		Threshold: threshold,
+		Keys:      delegationKeys,
+		Paths:     paths,
+	}
+	role, err := td.ToNewRole(name)
 	if err != nil {
 		return err
 	}
-	return cl.Close()
+	if !role.IsDelegation() {
+		return tuferrors.ErrInvalidRole{Role: name}
+	}
+	if len(delegationKeys) < threshold {
+		return fmt.Errorf("delegation %s requires a threshold of %d but only %d keys were provided", name, threshold, len(delegationKeys))
+	}
+
+	tdJSON, err := json.Marshal(td)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("Adding delegation \"%s\" with threshold %d and %d keys.", name, threshold, len(delegationKeys))
+	c := changelist.NewTufChange(changelist.ActionCreate, name, changelist.TypeTargetsDelegation, name, tdJSON)
+	return r.addChange(c)
 }
 
-// ListTargets lists all targets for the current repository
-func (r *NotaryRepository) ListTargets() ([]*Target, error) {
+// RemoveDelegation removes the delegated targets role with the given name,
+// along with any roles it delegates to, on the next Publish.
+func (r *NotaryRepository) RemoveDelegation(name string) error {
+	if !data.ValidRole(name) || !isTargetsRole(name) || name == data.ValidRoles["targets"] {
+		return tuferrors.ErrInvalidRole{Role: name}
+	}
+
+	logrus.Debugf("Removing delegation \"%s\".", name)
+	c := changelist.NewTufChange(changelist.ActionDelete, name, changelist.TypeTargetsDelegation, name, nil)
+	return r.addChange(c)
+}
+
+// AddRoleKeys trusts additional keys to sign the root or targets role, on
+// the next Publish. Root keys must be x509 certificates for the GUN, such as
+// the ones generated by Initialize.
+func (r *NotaryRepository) AddRoleKeys(role string, roleKeys ...*data.PublicKey) error {
+	if role == data.ValidRoles["root"] {
+		for _, k := range roleKeys {
+			if k.Algorithm() != data.RSAx509Key && k.Algorithm() != data.ECDSAx509Key {
+				return fmt.Errorf("invalid format for root key: %s", k.Algorithm())
+			}
+		}
+	}
+	logrus.Debugf("Adding %d keys to %s.", len(roleKeys), role)
+	return r.changeBaseRole(role, changelist.TufBaseRole{AddKeys: roleKeys})
+}
+
+// RemoveRoleKeys stops trusting the keys with the given IDs to sign the
+// root or targets role, on the next Publish.
+func (r *NotaryRepository) RemoveRoleKeys(role string, keyIDs ...string) error {
+	logrus.Debugf("Removing %d keys from %s.", len(keyIDs), role)
+	return r.changeBaseRole(role, changelist.TufBaseRole{RemoveKeys: keyIDs})
+}
+
+// SetThreshold sets the number of keys that must sign the root or targets
+// role, on the next Publish. Publish refuses to push a role that isn't
+// signed by enough of its keys, so every key holder has to make their keys
+// available to the publisher.
+func (r *NotaryRepository) SetThreshold(role string, threshold int) error {
+	if threshold < 1 {
+		return fmt.Errorf("invalid threshold for %s: %d", role, threshold)
+	}
+	logrus.Debugf("Setting the threshold of %s to %d.", role, threshold)
+	return r.changeBaseRole(role, changelist.TufBaseRole{Threshold: threshold})
+}
 
-	c, err := r.bootstrapClient()
+// changeBaseRole records a change to the root or targets role, which is
+// applied to root on the next Publish
+func (r *NotaryRepository) changeBaseRole(role string, br changelist.TufBaseRole) error {
+	if role != data.ValidRoles["root"] && role != data.ValidRoles["targets"] {
+		return tuferrors.ErrInvalidRole{Role: role}
+	}
+	brJSON, err := json.Marshal(br)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	c := changelist.NewTufChange(changelist.ActionUpdate, data.ValidRoles["root"], changelist.TypeBaseRole, role, brJSON)
+	return r.addChange(c)
+}
 
-	err = c.Update()
+// GetChangelist returns the changelist holding the changes to the repository
+// that haven't been published yet
+func (r *NotaryRepository) GetChangelist() (changelist.Changelist, error) {
+	return r.openChangelist(r.tufRepoPath)
+}
+
+// addChange records c in the repository's changelist, to be applied on the
+// next Publish
+func (r *NotaryRepository) addChange(c changelist.Change) error {
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	err = cl.Add(c)
+	if err != nil {
+		return err
+	}
+	return cl.Close()
+}
+
+// ListTargets lists all targets for the current repository
+func (r *NotaryRepository) ListTargets() ([]*Target, error) {
+	err := r.update()
 	if err != nil {
 		return nil, err
 	}
 
+	// Targets may be listed by the base targets role or any of its
+	// delegations. Resolve each name the same way GetTargetByName does so
+	// that only targets within the paths delegated to a role are listed.
 	var targetList []*Target
-	for name, meta := range r.tufRepo.Targets["targets"].Signed.Targets {
-		target := &Target{Name: name, Hashes: meta.Hashes, Length: meta.Length}
-		targetList = append(targetList, target)
+	seen := make(map[string]bool)
+	for _, t := range r.tufRepo.Targets {
+		for name := range t.Signed.Targets {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			meta := r.tufRepo.FindTarget(name)
+			if meta == nil {
+				continue
+			}
+			target := &Target{Name: name, Hashes: meta.Hashes, Length: meta.Length}
+			targetList = append(targetList, target)
+		}
 	}
 
 	return targetList, nil
@@ -276,17 +590,12 @@ func (r *NotaryRepository) ListTargets() ([]*Target, error) {
 
 // GetTargetByName returns a target given a name
 func (r *NotaryRepository) GetTargetByName(name string) (*Target, error) {
-	c, err := r.bootstrapClient()
-	if err != nil {
-		return nil, err
-	}
-
-	err = c.Update()
+	err := r.update()
 	if err != nil {
 		return nil, err
 	}
 
-	meta := c.TargetMeta(name)
+	meta := r.tufRepo.FindTarget(name)
 	if meta == nil {
 		return nil, errors.New("Meta is nil for target")
 	}
@@ -295,12 +604,187 @@ func (r *NotaryRepository) GetTargetByName(name string) (*Target, error) {
 }
 
 // Publish pushes the local changes in signed material to the remote notary-server
-// Conceptually it performs an operation similar to a `git rebase`
+// Conceptually it performs an operation similar to a `git rebase`: if someone
+// else publishes first, the server rejects the update as a conflict, and the
+// changes are applied and signed again on top of the latest metadata, up to
+// publishRetries times. An ErrConflict is returned if the changes modify
+// targets that the other update modified too.
 func (r *NotaryRepository) Publish(getPass passwordRetriever) error {
-	var updateRoot bool
-	var root *data.Signed
+	var base map[string]data.Files
+	for attempt := 0; ; attempt++ {
+		loaded, err := r.publishChanges(getPass, base)
+		rejected, ok := err.(*ErrPublishRejected)
+		if !ok || rejected.Code != notaryerrors.ErrorCodeOldVersion || attempt == publishRetries {
+			return err
+		}
+		logrus.Warnf("%s was updated while publishing, rebasing the changes: %s", r.gun, rejected.Msg)
+		if base == nil {
+			base = loaded
+		}
+	}
+}
+
+// publishChanges applies the changelist to the latest metadata and publishes
+// it, returning the targets of each targets role as loaded before applying
+// the changes. When retrying after a conflict, base holds the targets loaded
+// by the first attempt, which the changes were made against.
+func (r *NotaryRepository) publishChanges(getPass passwordRetriever, base map[string]data.Files) (map[string]data.Files, error) {
+	root, err := r.loadForUpdate()
+	if err != nil {
+		return nil, err
+	}
+	// the root role trusted before any changes are applied, which has to
+	// sign a modified root along with the root keys it lists
+	trustedRoot := rootRole(r.tufRepo.Root)
+	trustedKeys := rootKeys(r.tufRepo.Root)
+	loaded := targetsFiles(r.tufRepo)
+
+	applied, err := r.applyPendingChanges()
+	if err != nil {
+		return loaded, err
+	}
+	if base != nil {
+		if err := checkRebase(base, loaded, targetsFiles(r.tufRepo)); err != nil {
+			return loaded, err
+		}
+	}
+
+	// check if our root file is nearing expiry. Resign if it is.
+	if r.rootDirty() {
+		newRoot := rootRole(r.tufRepo.Root)
+		signers, err := r.rootSigners(append(trustedKeys, rootKeys(r.tufRepo.Root)...), getPass)
+		if err != nil {
+			return loaded, err
+		}
+		root, err = r.signRoot(signers)
+		if err != nil {
+			return loaded, err
+		}
+		if err := checkThreshold(root, trustedRoot, newRoot); err != nil {
+			return loaded, err
+		}
+	}
+	if err := r.publish(root); err != nil {
+		return loaded, err
+	}
+	return loaded, r.archiveChanges(applied)
+}
+
+// applyPendingChanges applies the pending changes in the repository's
+// changelist to the loaded metadata, and returns the changes applied.
+func (r *NotaryRepository) applyPendingChanges() ([]changelist.Change, error) {
+	// load the changelist for this repo
+	cl, err := r.GetChangelist()
+	if err != nil {
+		logrus.Debug("Error initializing changelist")
+		return nil, err
+	}
+	defer cl.Close()
+	// apply the changelist to the repo
+	changes := cl.List()
+	err = applyChanges(r.tufRepo, changes)
+	if err != nil {
+		logrus.Debug("Error applying changelist")
+		return nil, err
+	}
+	return changes, nil
+}
+
+// archiveChanges moves the changes applied by a successful Publish from the
+// changelist to a new entry of the changelist history, so they aren't
+// applied again. Changes added while publishing are kept for the next
+// Publish.
+func (r *NotaryRepository) archiveChanges(applied []changelist.Change) error {
+	if len(applied) == 0 {
+		return nil
+	}
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	archive := filepath.Join(r.tufRepoPath, historyDir, time.Now().UTC().Format(historyTimeFormat))
+	return cl.Archive(applied, archive)
+}
+
+// PublishedChangelist is a changelist archived by Publish once its changes
+// were accepted by the notary-server
+type PublishedChangelist struct {
+	// Name identifies the changelist in the repository's history
+	Name       string
+	Published  time.Time
+	Changelist changelist.Changelist
+}
+
+// ChangelistHistory returns the changelists archived by Publish, from the
+// oldest to the latest
+func (r *NotaryRepository) ChangelistHistory() ([]*PublishedChangelist, error) {
+	dir := filepath.Join(r.tufRepoPath, historyDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []*PublishedChangelist
+	for _, e := range entries {
+		published, err := time.Parse(historyTimeFormat, e.Name())
+		if !e.IsDir() || err != nil {
+			logrus.Debugf("skipping unexpected entry in changelist history: %s", e.Name())
+			continue
+		}
+		cl, err := r.openChangelist(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &PublishedChangelist{Name: e.Name(), Published: published, Changelist: cl})
+	}
+	return history, nil
+}
+
+// Replay adds the target and delegation changes in cl, such as a changelist
+// from the history of another repository, to the repository's changelist to
+// be applied on the next Publish. Changes to the base roles are skipped, as
+// their keys are specific to the repository they were made for. It returns
+// the number of changes added.
+func (r *NotaryRepository) Replay(cl changelist.Changelist) (int, error) {
+	replayed := 0
+	for _, c := range cl.List() {
+		if !isTargetsRole(c.Scope()) {
+			logrus.Debugf("not replaying %s change to %s", c.Scope(), c.Path())
+			continue
+		}
+		if err := r.addChange(c); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// rootDirty returns true if root has to be signed again, either because it
+// has been modified or because it's nearing expiry, in which case its expiry
+// is extended.
+func (r *NotaryRepository) rootDirty() bool {
+	if nearExpiry(r.tufRepo.Root) {
+		r.tufRepo.Root.Signed.Expires = data.DefaultExpires("root")
+		r.tufRepo.Root.Dirty = true
+	}
+	return r.tufRepo.Root.Dirty
+}
+
+// loadForUpdate loads the latest metadata from the notary-server, or from
+// disk if the repository hasn't been published yet. In the latter case the
+// initial root is returned, as it must be pushed along with the first
+// update, otherwise the returned root is nil.
+func (r *NotaryRepository) loadForUpdate() (*data.Signed, error) {
 	// attempt to initialize the repo from the remote store
-	c, err := r.bootstrapClient()
+	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.bootstrapClient(remote)
 	if err != nil {
 		if _, ok := err.(*store.ErrMetaNotFound); ok {
 			// if the remote store return a 404 (translated into ErrMetaNotFound),
@@ -311,113 +795,497 @@ func (r *NotaryRepository) Publish(getPass passwordRetriever) error {
 				// it can be published. Return an error and let caller determine
 				// what it wants to do.
 				logrus.Debug("Repository not initialized during Publish")
-				return &ErrRepoNotInitialized{}
+				return nil, &ErrRepoNotInitialized{}
 			}
 			// We had local data but the server doesn't know about the repo yet,
 			// ensure we will push the initial root file
-			root, err = r.tufRepo.Root.ToSigned()
+			root, err := r.tufRepo.Root.ToSigned()
 			if err != nil {
-				return err
+				return nil, err
 			}
-			updateRoot = true
-		} else {
-			// The remote store returned an error other than 404. We're
-			// unable to determine if the repo has been initialized or not.
-			logrus.Error("Could not publish Repository: ", err.Error())
-			return err
+			// likewise, all the targets roles have to be pushed
+			for _, t := range r.tufRepo.Targets {
+				t.Dirty = true
+			}
+			return root, nil
 		}
-	} else {
-		// If we were successfully able to bootstrap the client (which only pulls
-		// root.json), update it the rest of the tuf metadata in preparation for
-		// applying the changelist.
-		err = c.Update()
+		// The remote store returned an error other than 404. We're
+		// unable to determine if the repo has been initialized or not.
+		logrus.Error("Could not publish Repository: ", err.Error())
+		return nil, err
+	}
+
+	// If we were successfully able to bootstrap the client (which only pulls
+	// root.json), update it the rest of the tuf metadata in preparation for
+	// applying the changelist.
+	err = c.Update()
+	if err != nil {
+		return nil, err
+	}
+	err = checkRollback(filepath.Join(r.tufRepoPath, metadataDir), r.tufRepo)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// rootSigner is a root key that has been unlocked to sign root
+type rootSigner struct {
+	key           *data.PublicKey
+	cryptoService *cryptoservice.UnlockedCryptoService
+}
+
+// rootSigners unlocks each of the root keys that is held locally, using the
+// passphrases returned by getPass. Root keys held by others are skipped.
+func (r *NotaryRepository) rootSigners(keys []*data.PublicKey, getPass passwordRetriever) ([]rootSigner, error) {
+	var signers []rootSigner
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k.ID()] {
+			continue
+		}
+		seen[k.ID()] = true
+		if _, err := r.KeyStoreManager.RootKeyStore().Get(k.ID()); err != nil {
+			logrus.Debugf("root key %s is not available locally", k.ID())
+			continue
+		}
+		passphrase, err := getPass()
 		if err != nil {
-			return err
+			return nil, err
 		}
+		rootCryptoService, err := r.KeyStoreManager.GetRootCryptoService(k.ID(), passphrase)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, rootSigner{key: k, cryptoService: rootCryptoService})
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("none of the root keys of %s are available", r.gun)
 	}
+	return signers, nil
+}
 
-	// load the changelist for this repo
-	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+// signRoot signs the root with each of the signers, records it in the
+// snapshot and reloads it, so the keys it lists are used to sign the other
+// roles from now on.
+func (r *NotaryRepository) signRoot(signers []rootSigner) (*data.Signed, error) {
+	if r.tufRepo.Root.Dirty {
+		r.tufRepo.Root.Signed.Version++
+	}
+	root, err := r.tufRepo.Root.ToSigned()
+	if err != nil {
+		return nil, err
+	}
+	// signatures over the previous version no longer apply
+	root.Signatures = nil
+	for _, s := range signers {
+		err = signed.Sign(s.cryptoService.CryptoService, root, s.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.tufRepo.Root.Signatures = root.Signatures
+	err = r.tufRepo.UpdateSnapshot("root", root)
+	if err != nil {
+		return nil, err
+	}
+	// the new root is captured in the snapshot, reloading it also clears
+	// Dirty to prevent SignSnapshot from signing it again without the root
+	// keys.
+	if err := r.tufRepo.SetRoot(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// publish signs the modified targets roles and the snapshot, then pushes
+// them to the notary-server along with root, unless root is nil.
+func (r *NotaryRepository) publish(root *data.Signed) error {
+	// resign the modified targets roles and the snapshot. Targets roles that
+	// haven't been modified don't need to be signed, so publishing to a
+	// delegated role doesn't require the keys for any other role.
+	targets, err := r.signTargets()
 	if err != nil {
-		logrus.Debug("Error initializing changelist")
 		return err
 	}
-	// apply the changelist to the repo
-	err = applyChangelist(r.tufRepo, cl)
+	updateSnapshot := r.tufRepo.Snapshot.Dirty
+	snapshot, err := r.tufRepo.SignSnapshot(data.DefaultExpires("snapshot"), nil)
 	if err != nil {
-		logrus.Debug("Error applying changelist")
 		return err
 	}
+	if updateSnapshot {
+		snapshotRole := &data.Role{RootRole: *r.tufRepo.Root.Signed.Roles["snapshot"], Name: "snapshot"}
+		if len(snapshot.Signatures) == 0 {
+			// none of the snapshot keys are held locally, the server
+			// generates and signs the snapshot for this update
+			updateSnapshot = false
+		} else if err := checkThreshold(snapshot, snapshotRole); err != nil {
+			return err
+		}
+	}
 
-	// check if our root file is nearing expiry. Resign if it is.
-	if nearExpiry(r.tufRepo.Root) || r.tufRepo.Root.Dirty {
-		passphrase, err := getPass()
+	// ensure we can marshal all the json before sending anything to remote
+	updates := make(map[string][]byte)
+	if root != nil {
+		updates["root"], err = json.Marshal(root)
 		if err != nil {
 			return err
 		}
-		rootKeyID := r.tufRepo.Root.Signed.Roles["root"].KeyIDs[0]
-		rootCryptoService, err := r.KeyStoreManager.GetRootCryptoService(rootKeyID, passphrase)
+	}
+	for role, signedTargets := range targets {
+		updates[role], err = json.Marshal(signedTargets)
 		if err != nil {
 			return err
 		}
-		root, err = r.tufRepo.SignRoot(data.DefaultExpires("root"), rootCryptoService.CryptoService)
+	}
+	if updateSnapshot {
+		updates["snapshot"], err = json.Marshal(snapshot)
 		if err != nil {
 			return err
 		}
-		updateRoot = true
 	}
-	// we will always resign targets and snapshots
-	targets, err := r.tufRepo.SignTargets("targets", data.DefaultExpires("targets"), nil)
+	if len(updates) == 0 {
+		logrus.Debug("No changes to publish")
+		return nil
+	}
+
+	// all the metadata is pushed in a single update so the server can
+	// validate it as a whole, rejecting it if any of it is invalid.
+	if err := setMultiMeta(r.baseURL, r.gun, r.roundTrip, updates); err != nil {
+		return err
+	}
+	if root == nil {
+		return nil
+	}
+	// only trust the root certificates and threshold just published from
+	// now on, as the root is validated against them when it's next loaded
+	return r.KeyStoreManager.PinRoot(root, r.gun)
+}
+
+// ExportUnsignedRoot returns the root that has to be signed to publish the
+// pending changes to the root role, or to renew a root nearing expiry, along
+// with the roles whose thresholds its signatures must meet. The root carries
+// no signatures: it's signed with SignRoot wherever the root keys are held,
+// and published with ImportRootSignatures, so the root keys never have to be
+// present on the machine publishing the repository.
+func (r *NotaryRepository) ExportUnsignedRoot() (*data.Signed, []*data.Role, error) {
+	if _, err := r.loadForUpdate(); err != nil {
+		return nil, nil, err
+	}
+	trustedRoot := rootRole(r.tufRepo.Root)
+	if _, err := r.applyPendingChanges(); err != nil {
+		return nil, nil, err
+	}
+	if !r.rootDirty() {
+		return nil, nil, fmt.Errorf("the root of %s has no changes to sign", r.gun)
+	}
+	r.tufRepo.Root.Signed.Version++
+	root, err := r.tufRepo.Root.ToSigned()
+	if err != nil {
+		return nil, nil, err
+	}
+	root.Signatures = nil
+	return root, []*data.Role{trustedRoot, rootRole(r.tufRepo.Root)}, nil
+}
+
+// SignRoot signs root, as returned by ExportUnsignedRoot, with each of the
+// root keys it lists that is held by km, along with the keys in keyIDs, such
+// as root keys the root no longer lists. Signatures from other keys are kept,
+// so the root can be passed between the holders of several root keys. It
+// doesn't require access to the notary-server and returns the IDs of the keys
+// that signed.
+func SignRoot(km *keystoremanager.KeyStoreManager, root *data.Signed, getPass passwordRetriever, keyIDs ...string) ([]string, error) {
+	decoded, err := data.RootFromSigned(root)
 	if err != nil {
+		return nil, err
+	}
+	base, ok := decoded.Signed.Roles[data.ValidRoles["root"]]
+	if !ok {
+		return nil, errors.New("not a valid root: it doesn't define the root role")
+	}
+
+	var signedBy []string
+	seen := make(map[string]bool)
+	for _, id := range append(append([]string{}, base.KeyIDs...), keyIDs...) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := km.RootKeyStore().Get(id); err != nil {
+			logrus.Debugf("root key %s is not available locally", id)
+			continue
+		}
+		passphrase, err := getPass()
+		if err != nil {
+			return nil, err
+		}
+		rootCryptoService, err := km.GetRootCryptoService(id, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		sigs, err := rootCryptoService.CryptoService.Sign([]string{id}, root.Signed)
+		if err != nil {
+			return nil, err
+		}
+		if len(sigs) == 0 {
+			return nil, fmt.Errorf("failed to sign with root key %s", id)
+		}
+		// replace any previous signature from the same key
+		others := make([]data.Signature, 0, len(root.Signatures))
+		for _, sig := range root.Signatures {
+			if sig.KeyID != id {
+				others = append(others, sig)
+			}
+		}
+		root.Signatures = append(others, sigs...)
+		signedBy = append(signedBy, id)
+	}
+	if len(signedBy) == 0 {
+		return nil, errors.New("none of the root keys are available")
+	}
+	return signedBy, nil
+}
+
+// ImportRootSignatures publishes root, as returned by ExportUnsignedRoot and
+// signed with SignRoot. Its signatures have to meet the thresholds of both
+// the trusted root role and the root role it defines, invalid signatures and
+// signatures from other keys are dropped.
+func (r *NotaryRepository) ImportRootSignatures(root *data.Signed) error {
+	if _, err := r.loadForUpdate(); err != nil {
 		return err
 	}
-	snapshot, err := r.tufRepo.SignSnapshot(data.DefaultExpires("snapshot"), nil)
+	decoded, err := data.RootFromSigned(root)
 	if err != nil {
 		return err
 	}
+	if _, ok := decoded.Signed.Roles[data.ValidRoles["root"]]; !ok {
+		return errors.New("not a valid root: it doesn't define the root role")
+	}
+	if decoded.Signed.Version <= r.tufRepo.Root.Signed.Version {
+		return fmt.Errorf("root version %d is not newer than version %d of %s, it has to be exported again", decoded.Signed.Version, r.tufRepo.Root.Signed.Version, r.gun)
+	}
 
-	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	trustedRoot := rootRole(r.tufRepo.Root)
+	trustedTargets := baseRole(r.tufRepo.Root, data.ValidRoles["targets"])
+	pubKeys := make(map[string]*data.PublicKey)
+	for id, k := range r.tufRepo.Root.Signed.Keys {
+		pubKeys[id] = k
+	}
+	for id, k := range decoded.Signed.Keys {
+		pubKeys[id] = k
+	}
+	verified := &data.Signed{Signed: root.Signed, Signatures: validSignatures(root, pubKeys)}
+	if err := checkThreshold(verified, trustedRoot, rootRole(decoded)); err != nil {
+		return err
+	}
+
+	err = r.tufRepo.UpdateSnapshot("root", verified)
 	if err != nil {
 		return err
 	}
+	if err := r.tufRepo.SetRoot(verified); err != nil {
+		return err
+	}
+	// the current targets have to be signed again to meet a new targets role
+	if !sameRole(trustedTargets, baseRole(r.tufRepo.Root, data.ValidRoles["targets"])) {
+		r.tufRepo.Targets[data.ValidRoles["targets"]].Dirty = true
+	}
+	if err := r.publish(verified); err != nil {
+		return err
+	}
+	return r.archiveRootChanges(verified)
+}
 
-	// ensure we can marshal all the json before sending anything to remote
-	targetsJSON, err := json.Marshal(targets)
+// archiveRootChanges archives the pending changes to the root role once
+// root, as published by ImportRootSignatures, includes all of them, so the
+// next Publish doesn't apply them again. Root changes made since the root
+// was exported are kept, along with the changes to the other roles.
+func (r *NotaryRepository) archiveRootChanges(root *data.Signed) error {
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	var changes []changelist.Change
+	for _, c := range cl.List() {
+		if c.Scope() == data.ValidRoles["root"] {
+			changes = append(changes, c)
+		}
+	}
+	cl.Close()
+
+	published := tuf.NewTufRepo(keys.NewDB(), nil)
+	if err := published.SetRoot(root); err != nil {
+		return err
+	}
+	if err := applyChanges(published, changes); err != nil {
+		return err
+	}
+	if published.Root.Dirty {
+		logrus.Infof("%s has root changes that weren't exported, they are kept for the next root signing", r.gun)
+		return nil
+	}
+	return r.archiveChanges(changes)
+}
+
+// holdsKey returns true if the private key of any of the given key IDs is
+// held in the repository's key store
+func (r *NotaryRepository) holdsKey(keyIDs []string) bool {
+	for _, id := range keyIDs {
+		if _, err := r.KeyStoreManager.NonRootKeyStore().GetKey(filepath.Join(r.gun, id)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RotateRootKey replaces the root key of the repository with the key held
+// by newRootCryptoService, and publishes a root signed by both the old and
+// new root keys so that existing consumers can follow the rotation. The old
+// root key is unlocked using the passphrase returned by getPass. The root
+// threshold is left unchanged, so roots requiring more than one key can't be
+// rotated this way.
+func (r *NotaryRepository) RotateRootKey(newRootCryptoService *cryptoservice.UnlockedCryptoService, getPass passwordRetriever) error {
+	if _, err := r.loadForUpdate(); err != nil {
+		return err
+	}
+	trustedRoot := rootRole(r.tufRepo.Root)
+	// all the root keys are replaced by the new key, and the threshold is
+	// kept, so a root requiring several keys can't be rotated to one
+	if trustedRoot.Threshold > 1 {
+		return fmt.Errorf("the root of %s requires %d keys, rotating to a single key would leave too few", r.gun, trustedRoot.Threshold)
+	}
+	oldKeys := rootKeys(r.tufRepo.Root)
+	signers, err := r.rootSigners(oldKeys, getPass)
 	if err != nil {
 		return err
 	}
-	snapshotJSON, err := json.Marshal(snapshot)
+
+	newRootKey, _, err := r.rootCertKey(newRootCryptoService)
 	if err != nil {
 		return err
 	}
 
-	// if we need to update the root, marshal it and push the update to remote
-	if updateRoot {
-		rootJSON, err := json.Marshal(root)
-		if err != nil {
+	for _, id := range trustedRoot.KeyIDs {
+		if err := r.tufRepo.RemoveBaseKeys("root", id); err != nil {
 			return err
 		}
-		err = remote.SetMeta("root", rootJSON)
-		if err != nil {
-			return err
+	}
+	if err := r.tufRepo.AddBaseKeys("root", newRootKey); err != nil {
+		return err
+	}
+
+	// the new root has to be signed by the old root keys as well as the new
+	// one, to prove the rotation was authorized by the old keys' owners.
+	signers = append(signers, rootSigner{key: newRootKey, cryptoService: newRootCryptoService})
+	root, err := r.signRoot(signers)
+	if err != nil {
+		return err
+	}
+	if err := checkThreshold(root, trustedRoot, rootRole(r.tufRepo.Root)); err != nil {
+		return err
+	}
+
+	// publishing pins the new root certificate in place of the old ones
+	return r.publish(root)
+}
+
+// RotateKey replaces the keys of the targets, snapshot or timestamp role with
+// a newly generated key, re-signing root with the root key unlocked using the
+// passphrase returned by getPass, and publishes the change. Metadata signed
+// by the old keys is no longer trusted once the new root is published. The
+// timestamp key is generated by the notary-server, which switches to it once
+// the new root has been published.
+func (r *NotaryRepository) RotateKey(role string, getPass passwordRetriever) error {
+	if role != "targets" && role != "snapshot" && role != "timestamp" {
+		return fmt.Errorf("notary does not support rotating the %s key", role)
+	}
+	if _, err := r.loadForUpdate(); err != nil {
+		return err
+	}
+	oldKeyIDs := r.tufRepo.Root.Signed.Roles[role].KeyIDs
+	if role == "snapshot" {
+		// if none of the snapshot keys are held locally, the snapshot is
+		// signed by the notary-server, which owns its key.
+		if !r.holdsKey(oldKeyIDs) {
+			return fmt.Errorf("the snapshot key of %s is managed by the notary-server", r.gun)
 		}
 	}
-	err = remote.SetMeta("targets", targetsJSON)
+	signers, err := r.rootSigners(rootKeys(r.tufRepo.Root), getPass)
+	if err != nil {
+		return err
+	}
+
+	var key *data.PublicKey
+	if role == "timestamp" {
+		key, err = createRemoteKey(r.baseURL, r.gun, r.roundTrip, role)
+	} else {
+		key, err = r.cryptoService.Create(role, data.ECDSAKey)
+	}
 	if err != nil {
 		return err
 	}
-	err = remote.SetMeta("snapshot", snapshotJSON)
+	for _, id := range oldKeyIDs {
+		if err := r.tufRepo.RemoveBaseKeys(role, id); err != nil {
+			return err
+		}
+	}
+	if err := r.tufRepo.AddBaseKeys(role, key); err != nil {
+		return err
+	}
+	root, err := r.signRoot(signers)
 	if err != nil {
 		return err
 	}
+	if err := checkThreshold(root, rootRole(r.tufRepo.Root)); err != nil {
+		return err
+	}
 
-	return nil
+	// the current metadata for the role has to be re-signed with the new key
+	switch role {
+	case "targets":
+		r.tufRepo.Targets[role].Dirty = true
+	case "snapshot":
+		r.tufRepo.Snapshot.Dirty = true
+	}
+	return r.publish(root)
+}
+
+// signTargets signs every modified targets role, including delegated roles,
+// and records the new versions in the snapshot. It returns the signed
+// metadata keyed by role name.
+func (r *NotaryRepository) signTargets() (map[string]*data.Signed, error) {
+	updated := make(map[string]*data.Signed)
+	for role, t := range r.tufRepo.Targets {
+		if !t.Dirty {
+			continue
+		}
+		signedTargets, err := r.tufRepo.SignTargets(role, data.DefaultExpires("targets"), nil)
+		if err != nil {
+			return nil, err
+		}
+		tr := targetsRole(r.tufRepo, role)
+		if tr == nil {
+			return nil, tuferrors.ErrInvalidRole{Role: role}
+		}
+		if err := checkThreshold(signedTargets, tr); err != nil {
+			return nil, err
+		}
+		err = r.tufRepo.UpdateSnapshot(role, signedTargets)
+		if err != nil {
+			return nil, err
+		}
+		// the new version is captured in the snapshot, prevent SignSnapshot
+		// from signing it again.
+		t.Dirty = false
+		updated[role] = signedTargets
+	}
+	return updated, nil
 }
 
 func (r *NotaryRepository) bootstrapRepo() error {
 	fileStore, err := store.NewFilesystemStore(
 		r.tufRepoPath,
-		"metadata",
+		metadataDir,
 		"json",
 		"targets",
 	)
@@ -471,6 +1339,13 @@ func (r *NotaryRepository) saveMetadata(rootCryptoService signed.CryptoService)
 	if err != nil {
 		return err
 	}
+	// record the signed root in the snapshot now, as it can't be signed
+	// again later without the root key
+	err = r.tufRepo.UpdateSnapshot("root", signedRoot)
+	if err != nil {
+		return err
+	}
+	r.tufRepo.Root.Dirty = false
 
 	rootJSON, _ := json.Marshal(signedRoot)
 	return r.fileStore.SetMeta("root", rootJSON)
@@ -499,11 +1374,62 @@ func (r *NotaryRepository) snapshot() error {
 	return r.fileStore.SetMeta("snapshot", snapshotJSON)
 }
 
-func (r *NotaryRepository) bootstrapClient() (*tufclient.Client, error) {
+// update downloads and verifies the latest metadata from the notary-server,
+// caching it once verified. An ErrRollback is returned if any of the
+// metadata is older than the cached metadata. In offline mode the cached
+// metadata is verified instead if the server can't be reached or fails with
+// an ErrServerUnavailable.
+func (r *NotaryRepository) update() error {
 	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	fetched := newRecordingStore(remote)
+	err = r.updateFrom(fetched)
+	if err == nil {
+		// only cache the metadata if it's at least as recent as the
+		// metadata previously seen
+		if err := checkRollback(filepath.Join(r.tufRepoPath, metadataDir), r.tufRepo); err != nil {
+			return err
+		}
+		if err := fetched.saveTo(filepath.Join(r.tufRepoPath, metadataDir)); err != nil {
+			logrus.Warnf("failed to cache metadata for %s: %s", r.gun, err.Error())
+		}
+		return nil
+	}
+	if !r.offline || !serverUnavailable(err) {
+		return err
+	}
+
+	logrus.Infof("notary-server unavailable, using cached metadata for %s: %s", r.gun, err.Error())
+	cache, err := newOfflineStore(filepath.Join(r.tufRepoPath, metadataDir))
+	if err != nil {
+		return err
 	}
+	return r.updateFrom(cache)
+}
+
+// serverUnavailable returns whether err means the notary-server couldn't be
+// reached or failed on its side, rather than refused the request
+func serverUnavailable(err error) bool {
+	switch err.(type) {
+	case net.Error, *ErrServerUnavailable:
+		return true
+	}
+	return false
+}
+
+// updateFrom bootstraps the trust from the root in remote and updates the
+// rest of the metadata from it
+func (r *NotaryRepository) updateFrom(remote store.RemoteStore) error {
+	c, err := r.bootstrapClient(remote)
+	if err != nil {
+		return err
+	}
+	return c.Update()
+}
+
+func (r *NotaryRepository) bootstrapClient(remote store.RemoteStore) (*tufclient.Client, error) {
 	rootJSON, err := remote.GetMeta("root", 5<<20)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -12,9 +13,16 @@ import (
 	"testing"
 
 	"github.com/docker/notary/client/changelist"
+	notaryerrors "github.com/docker/notary/errors"
+	"github.com/docker/notary/keystoremanager"
+	"github.com/docker/notary/server"
+	"github.com/docker/notary/server/storage"
 	"github.com/docker/notary/trustmanager"
 	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+	"github.com/endophage/gotuf/store"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 // TODO(diogo): timestamps have to be the same keytype as targets and snapshots.
@@ -39,6 +47,35 @@ func createTestServer(t *testing.T) (*httptest.Server, *http.ServeMux) {
 	return ts, mux
 }
 
+// fullTestServer returns a test server running the notary-server handlers
+// on top of an in-memory store
+func fullTestServer(t *testing.T) *httptest.Server {
+	ctx := context.WithValue(context.Background(), "metaStore", storage.NewMemStorage())
+	return httptest.NewServer(server.RootHandler(nil, ctx, signed.NewEd25519()))
+}
+
+func passphraseRetriever() (string, error) {
+	return "passphrase", nil
+}
+
+// initializedRepo creates and initializes a repository for gun, backed by
+// the server at url, using an ECDSA root key.
+func initializedRepo(t *testing.T, baseDir, gun, url string, serverManagedRoles ...string) *NotaryRepository {
+	repo, err := NewNotaryRepository(baseDir, gun, url, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+
+	rootKeyID, err := repo.KeyStoreManager.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+
+	rootCryptoService, err := repo.KeyStoreManager.GetRootCryptoService(rootKeyID, "passphrase")
+	assert.NoError(t, err, "error retreiving root key: %s", err)
+
+	err = repo.Initialize(rootCryptoService, serverManagedRoles...)
+	assert.NoError(t, err, "error creating repository: %s", err)
+
+	return repo
+}
+
 // TestInitRepo runs through the process of initializing a repository and makes
 // sure the repository looks correct on disk.
 // We test this with both an RSA and ECDSA root key
@@ -431,3 +468,908 @@ func testValidateRootKey(t *testing.T, rootType data.KeyAlgorithm) {
 		}
 	}
 }
+
+// TestPublishDelegation creates a delegated targets role, publishes a target
+// under it, and checks it can be listed until the delegation is removed.
+func TestPublishDelegation(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+
+	releasesKey, err := repo.cryptoService.Create("targets/releases", data.ECDSAKey)
+	assert.NoError(t, err, "error creating delegation key")
+
+	// Invalid delegations are rejected before reaching the changelist
+	err = repo.AddDelegation("releases", 1, []*data.PublicKey{releasesKey}, []string{"release/"})
+	assert.Error(t, err, "delegation outside of targets accepted")
+	err = repo.AddDelegation("targets/releases", 2, []*data.PublicKey{releasesKey}, []string{"release/"})
+	assert.Error(t, err, "delegation with unreachable threshold accepted")
+	err = repo.RemoveDelegation("targets")
+	assert.Error(t, err, "removal of the base targets role accepted")
+
+	err = repo.AddDelegation("targets/releases", 1, []*data.PublicKey{releasesKey}, []string{"release/"})
+	assert.NoError(t, err, "error adding delegation")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing delegation")
+
+	releaseTarget, err := NewTarget("release/v1", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTargetToRole(releaseTarget, "targets/releases")
+	assert.NoError(t, err, "error adding target to delegation")
+	err = repo.AddTargetToRole(releaseTarget, "snapshot")
+	assert.Error(t, err, "target added to a non-targets role")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing delegated target")
+
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	assert.Equal(t, releaseTarget, targets[0], "release target does not match")
+
+	_, ok := repo.tufRepo.Targets["targets/releases"]
+	assert.True(t, ok, "delegated role was not downloaded")
+
+	target, err := repo.GetTargetByName("release/v1")
+	assert.NoError(t, err)
+	assert.Equal(t, releaseTarget, target, "release target does not match")
+
+	err = repo.RemoveDelegation("targets/releases")
+	assert.NoError(t, err, "error removing delegation")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing delegation removal")
+
+	targets, err = repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 0, "delegated target still listed after removing delegation")
+}
+
+// TestApplyDelegationOutsidePaths ensures that a target can't be added to a
+// delegated role unless it falls within the role's paths.
+func TestApplyDelegationOutsidePaths(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	ts, _ := createTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, "docker.com/notary", ts.URL)
+
+	releasesKey, err := repo.cryptoService.Create("targets/releases", data.ECDSAKey)
+	assert.NoError(t, err, "error creating delegation key")
+	ciKey, err := repo.cryptoService.Create("targets/ci", data.ECDSAKey)
+	assert.NoError(t, err, "error creating delegation key")
+
+	cl := changelist.NewMemChangelist()
+	td, _ := json.Marshal(changelist.TufDelegation{Threshold: 1, Keys: []*data.PublicKey{releasesKey}, Paths: []string{"release/"}})
+	cl.Add(changelist.NewTufChange(changelist.ActionCreate, "targets/releases", changelist.TypeTargetsDelegation, "targets/releases", td))
+	td, _ = json.Marshal(changelist.TufDelegation{Threshold: 1, Keys: []*data.PublicKey{ciKey}, Paths: []string{"ci/"}})
+	cl.Add(changelist.NewTufChange(changelist.ActionCreate, "targets/ci", changelist.TypeTargetsDelegation, "targets/ci", td))
+
+	err = applyChangelist(repo.tufRepo, cl)
+	assert.NoError(t, err, "error applying delegations")
+
+	delegations := repo.tufRepo.Targets["targets"].Signed.Delegations
+	assert.Len(t, delegations.Roles, 2, "both delegations should be present")
+	assert.Len(t, delegations.Keys, 2, "both delegation keys should be present")
+
+	meta, _ := json.Marshal(data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": []byte{1}}})
+	cl = changelist.NewMemChangelist()
+	cl.Add(changelist.NewTufChange(changelist.ActionCreate, "targets/ci", changelist.TypeTargetsTarget, "release/v1", meta))
+	err = applyChangelist(repo.tufRepo, cl)
+	assert.Error(t, err, "target outside the delegated paths was accepted")
+
+	cl = changelist.NewMemChangelist()
+	cl.Add(changelist.NewTufChange(changelist.ActionDelete, "targets/releases", changelist.TypeTargetsDelegation, "targets/releases", nil))
+	err = applyChangelist(repo.tufRepo, cl)
+	assert.NoError(t, err, "error removing delegation")
+
+	delegations = repo.tufRepo.Targets["targets"].Signed.Delegations
+	assert.Len(t, delegations.Roles, 1, "only one delegation should remain")
+	assert.Equal(t, "targets/ci", delegations.Roles[0].Name)
+	_, ok := delegations.Keys[releasesKey.ID()]
+	assert.False(t, ok, "key of the removed delegation was not pruned")
+	_, ok = repo.tufRepo.Targets["targets/releases"]
+	assert.False(t, ok, "removed delegation still in the repo")
+
+	for _, name := range []string{"targets/releases", "targets/cl"} {
+		cl = changelist.NewMemChangelist()
+		cl.Add(changelist.NewTufChange(changelist.ActionDelete, name, changelist.TypeTargetsDelegation, name, nil))
+		err = applyChangelist(repo.tufRepo, cl)
+		assert.Error(t, err, "removal of the unknown delegation %s was accepted", name)
+	}
+	assert.Len(t, repo.tufRepo.Targets["targets"].Signed.Delegations.Roles, 1, "delegations changed by a failed removal")
+}
+
+// TestPublishServerManagedSnapshot initializes a repository whose snapshot
+// key is held by the server, and checks targets can be published without
+// a local snapshot key.
+func TestPublishServerManagedSnapshot(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, err := NewNotaryRepository(tempBaseDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	rootKeyID, err := repo.KeyStoreManager.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+	rootCryptoService, err := repo.KeyStoreManager.GetRootCryptoService(rootKeyID, "passphrase")
+	assert.NoError(t, err, "error retreiving root key: %s", err)
+	err = repo.Initialize(rootCryptoService, "targets")
+	assert.Error(t, err, "server accepted to manage the targets key")
+
+	repo = initializedRepo(t, tempBaseDir, gun, ts.URL, "snapshot")
+
+	// only the targets key is held locally
+	snapshotKeyIDs := repo.tufRepo.Root.Signed.Roles["snapshot"].KeyIDs
+	assert.Len(t, snapshotKeyIDs, 1)
+	_, err = repo.KeyStoreManager.NonRootKeyStore().GetKey(filepath.Join(gun, snapshotKeyIDs[0]))
+	assert.Error(t, err, "snapshot key was created locally")
+
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	assert.Equal(t, latestTarget, targets[0], "latest target does not match")
+
+	// a second publish only sends the targets
+	currentTarget, err := NewTarget("current", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(currentTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	targets, err = repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2, "unexpected number of targets returned by ListTargets")
+
+	// the snapshot key can't be rotated by the client
+	err = repo.RotateKey("snapshot", passphraseRetriever)
+	assert.Error(t, err, "rotated the snapshot key managed by the server")
+	assert.Equal(t, snapshotKeyIDs, repo.tufRepo.Root.Signed.Roles["snapshot"].KeyIDs)
+}
+
+// TestOfflineListTargets checks targets can be verified against the cached
+// metadata once the server goes away, but only in offline mode.
+func TestOfflineListTargets(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+
+	// the verified metadata replaces the repository's own copy
+	resp, err := http.Get(ts.URL + "/v2/" + gun + "/_trust/tuf/targets.json")
+	assert.NoError(t, err)
+	served, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	saved, err := ioutil.ReadFile(filepath.Join(tempBaseDir, tufDir, gun, metadataDir, "targets.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, served, saved, "saved targets differ from the served targets")
+	ts.Close()
+
+	_, err = repo.ListTargets()
+	assert.Error(t, err, "listed targets offline without offline mode")
+
+	repo, err = NewNotaryRepository(tempBaseDir, gun, ts.URL, http.DefaultTransport, WithOffline())
+	assert.NoError(t, err, "error opening the repository in offline mode")
+	targets, err = repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	assert.Equal(t, latestTarget, targets[0], "latest target does not match")
+	target, err := repo.GetTargetByName("latest")
+	assert.NoError(t, err)
+	assert.Equal(t, latestTarget, target, "latest target does not match")
+
+	// a server failing on its side is handled as an unreachable one
+	failing, mux := createTestServer(t)
+	defer failing.Close()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		notaryerrors.ServeJSON(w, &notaryerrors.HTTPError{HTTPStatus: http.StatusServiceUnavailable, Code: notaryerrors.ErrorCodeNoStorage})
+	})
+	online, err := NewNotaryRepository(tempBaseDir, gun, failing.URL, http.DefaultTransport)
+	assert.NoError(t, err)
+	_, err = online.ListTargets()
+	assert.IsType(t, &ErrServerUnavailable{}, err, "%v", err)
+	repo, err = NewNotaryRepository(tempBaseDir, gun, failing.URL, http.DefaultTransport, WithOffline())
+	assert.NoError(t, err)
+	targets, err = repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+
+	// the cached metadata is still verified
+	cachedTargets := filepath.Join(tempBaseDir, tufDir, gun, metadataDir, "targets.json")
+	tampered, err := ioutil.ReadFile(cachedTargets)
+	assert.NoError(t, err, "targets were not cached")
+	tampered = bytes.Replace(tampered, []byte(`"latest"`), []byte(`"oldest"`), 1)
+	assert.NoError(t, ioutil.WriteFile(cachedTargets, tampered, 0644))
+	_, err = repo.GetTargetByName("oldest")
+	assert.Error(t, err, "tampered cached metadata was accepted")
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(tempBaseDir, tufDir, gun, metadataDir)))
+	_, err = repo.ListTargets()
+	assert.Error(t, err, "listed targets without any cached metadata")
+}
+
+// TestRollback checks metadata older than the metadata previously seen is
+// refused, and isn't cached.
+func TestRollback(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+	_, err = repo.ListTargets()
+	assert.NoError(t, err)
+
+	// pretend a newer version of the targets was seen previously
+	cachedTargets := filepath.Join(tempBaseDir, tufDir, gun, metadataDir, "targets.json")
+	seen, err := cachedVersion(filepath.Dir(cachedTargets), "targets")
+	assert.NoError(t, err, "targets were not cached")
+	cached, err := ioutil.ReadFile(cachedTargets)
+	assert.NoError(t, err)
+	cached = bytes.Replace(cached, []byte(fmt.Sprintf(`"version":%d`, seen)), []byte(`"version":100`), 1)
+	assert.NoError(t, ioutil.WriteFile(cachedTargets, cached, 0644))
+
+	_, err = repo.ListTargets()
+	assert.IsType(t, &ErrRollback{}, err)
+	assert.Equal(t, "targets", err.(*ErrRollback).Role)
+	_, err = repo.GetTargetByName("latest")
+	assert.IsType(t, &ErrRollback{}, err)
+	err = repo.Publish(passphraseRetriever)
+	assert.IsType(t, &ErrRollback{}, err)
+
+	seen, err = cachedVersion(filepath.Dir(cachedTargets), "targets")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, seen, "older metadata replaced the cache")
+}
+
+// TestTrustOnFirstUse checks a repository published by someone else is only
+// trusted in TOFU mode, after which its root certificate is pinned.
+func TestTrustOnFirstUse(t *testing.T) {
+	// Temporary directories where test files will be created
+	publisherDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(publisherDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	consumerDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(consumerDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	publisher := initializedRepo(t, publisherDir, gun, ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = publisher.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = publisher.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	consumer, err := NewNotaryRepository(consumerDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	_, err = consumer.ListTargets()
+	assert.Error(t, err, "untrusted root accepted without TOFU")
+
+	consumer.KeyStoreManager.SetTrustOnFirstUse(true)
+	targets, err := consumer.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+
+	pinned, err := ioutil.ReadDir(filepath.Join(consumerDir, "trusted_certificates", filepath.FromSlash(gun)))
+	assert.NoError(t, err, "root certificate was not pinned")
+	assert.Len(t, pinned, 1, "unexpected number of pinned certificates")
+
+	// a different root for the same GUN is refused
+	other := fullTestServer(t)
+	defer other.Close()
+	err = initializedRepo(t, publisherDir, gun, other.URL).Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	consumer, err = NewNotaryRepository(consumerDir, gun, other.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	consumer.KeyStoreManager.SetTrustOnFirstUse(true)
+	_, err = consumer.ListTargets()
+	assert.IsType(t, keystoremanager.ErrCertChanged{}, err)
+}
+
+// TestRotateRootKey rotates the root key of a published repository, and
+// checks both the publisher and a consumer that pinned the old root
+// certificate accept the new root.
+func TestRotateRootKey(t *testing.T) {
+	// Temporary directories where test files will be created
+	publisherDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(publisherDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	consumerDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(consumerDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, publisherDir, gun, ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	consumer, err := NewNotaryRepository(consumerDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	consumer.KeyStoreManager.SetTrustOnFirstUse(true)
+	_, err = consumer.ListTargets()
+	assert.NoError(t, err)
+
+	oldRootKeyID := repo.tufRepo.Root.Signed.Roles["root"].KeyIDs[0]
+	newRootKeyID, err := repo.KeyStoreManager.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+	newRootCryptoService, err := repo.KeyStoreManager.GetRootCryptoService(newRootKeyID, "passphrase")
+	assert.NoError(t, err, "error retreiving root key: %s", err)
+	err = repo.RotateRootKey(newRootCryptoService, passphraseRetriever)
+	assert.NoError(t, err, "error rotating root key")
+
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	rootKeyIDs := repo.tufRepo.Root.Signed.Roles["root"].KeyIDs
+	assert.Len(t, rootKeyIDs, 1)
+	assert.NotEqual(t, oldRootKeyID, rootKeyIDs[0], "root key was not replaced")
+
+	// the consumer follows the rotation, and now only trusts the new key
+	targets, err = consumer.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	pinned, err := ioutil.ReadDir(filepath.Join(consumerDir, "trusted_certificates", filepath.FromSlash(gun)))
+	assert.NoError(t, err)
+	assert.Len(t, pinned, 1, "unexpected number of pinned certificates")
+	_, err = consumer.KeyStoreManager.TrustedCertificateStore().GetCertificateByKeyID(oldRootKeyID)
+	assert.Error(t, err, "old root certificate is still trusted")
+
+	// changes are signed with the new root key from now on
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing after rotation")
+}
+
+// TestRotateKey rotates the targets, snapshot and timestamp keys of a
+// published repository, and checks the metadata signed with the new keys is
+// accepted.
+func TestRotateKey(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	for _, role := range []string{"targets", "snapshot", "timestamp"} {
+		oldKeyID := repo.tufRepo.Root.Signed.Roles[role].KeyIDs[0]
+		err = repo.RotateKey(role, passphraseRetriever)
+		assert.NoError(t, err, "error rotating %s key", role)
+
+		targets, err := repo.ListTargets()
+		assert.NoError(t, err)
+		assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+		keyIDs := repo.tufRepo.Root.Signed.Roles[role].KeyIDs
+		assert.Len(t, keyIDs, 1)
+		assert.NotEqual(t, oldKeyID, keyIDs[0], "%s key was not replaced", role)
+	}
+
+	err = repo.RotateKey("root", passphraseRetriever)
+	assert.Error(t, err, "the root key can't be rotated by RotateKey")
+
+	// changes are signed with the new keys from now on
+	err = repo.AddTarget(latestTarget)
+	assert.NoError(t, err, "error adding target")
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing after rotation")
+}
+
+// TestThreshold publishes root and targets roles requiring two signatures,
+// and checks Publish refuses to push root once one of the root keys is
+// no longer available.
+func TestThreshold(t *testing.T) {
+	// Temporary directories where test files will be created
+	publisherDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(publisherDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	consumerDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(consumerDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, publisherDir, gun, ts.URL)
+
+	rootKeyID, err := repo.KeyStoreManager.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+	rootCryptoService, err := repo.KeyStoreManager.GetRootCryptoService(rootKeyID, "passphrase")
+	assert.NoError(t, err, "error retreiving root key: %s", err)
+	rootKey, _, err := repo.rootCertKey(rootCryptoService)
+	assert.NoError(t, err, "error creating root certificate: %s", err)
+	targetsKey, err := repo.cryptoService.Create("targets", data.ECDSAKey)
+	assert.NoError(t, err, "error creating targets key: %s", err)
+
+	// the threshold can't be met without the extra keys
+	assert.NoError(t, repo.SetThreshold("root", 2))
+	err = repo.Publish(passphraseRetriever)
+	assert.Error(t, err, "published a root with fewer keys than its threshold")
+
+	assert.NoError(t, repo.AddRoleKeys("root", rootKey))
+	assert.NoError(t, repo.AddRoleKeys("targets", targetsKey))
+	assert.NoError(t, repo.SetThreshold("targets", 2))
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+
+	for _, role := range []string{"root", "targets"} {
+		baseRole := repo.tufRepo.Root.Signed.Roles[role]
+		assert.Equal(t, 2, baseRole.Threshold, "wrong threshold for %s", role)
+		assert.Len(t, baseRole.KeyIDs, 2, "wrong number of keys for %s", role)
+	}
+	assert.Len(t, repo.tufRepo.Root.Signatures, 2)
+	assert.Len(t, repo.tufRepo.Targets["targets"].Signatures, 2)
+
+	consumer, err := NewNotaryRepository(consumerDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	consumer.KeyStoreManager.SetTrustOnFirstUse(true)
+	targets, err := consumer.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+
+	// rotating would replace both root keys with a single one
+	err = repo.RotateRootKey(rootCryptoService, passphraseRetriever)
+	assert.Error(t, err, "rotated a root requiring two keys to a single key")
+	assert.Equal(t, 2, repo.tufRepo.Root.Signed.Roles["root"].Threshold)
+
+	// without the second root key, root changes can't be published
+	assert.NoError(t, repo.KeyStoreManager.RootKeyStore().Remove(rootKey.ID()))
+	assert.NoError(t, repo.SetThreshold("targets", 1))
+	err = repo.Publish(passphraseRetriever)
+	assert.IsType(t, &ErrInsufficientSignatures{}, err, "%v", err)
+	assert.Equal(t, "root", err.(*ErrInsufficientSignatures).Role)
+
+	assert.Error(t, repo.SetThreshold("snapshot", 2), "only root and targets thresholds can be set")
+	assert.Error(t, repo.AddRoleKeys("root", targetsKey), "root keys must be certificates")
+}
+
+// TestOfflineRootSigning adds a root key held on another machine and raises
+// the root threshold, exporting the root for each key holder to sign
+// before importing the signatures and publishing it.
+func TestOfflineRootSigning(t *testing.T) {
+	// Temporary directories where test files will be created
+	publisherDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(publisherDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	offlineDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(offlineDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	consumerDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(consumerDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, publisherDir, gun, ts.URL)
+	_, _, err = repo.ExportUnsignedRoot()
+	assert.Error(t, err, "exported a root without changes")
+
+	// the offline machine only holds its own root key
+	offline, err := NewNotaryRepository(offlineDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	rootKeyID, err := offline.KeyStoreManager.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+	rootCryptoService, err := offline.KeyStoreManager.GetRootCryptoService(rootKeyID, "passphrase")
+	assert.NoError(t, err, "error retreiving root key: %s", err)
+	offlineKey, _, err := offline.rootCertKey(rootCryptoService)
+	assert.NoError(t, err, "error creating root certificate: %s", err)
+
+	assert.NoError(t, repo.AddRoleKeys("root", offlineKey))
+	assert.NoError(t, repo.SetThreshold("root", 2))
+	root, roles, err := repo.ExportUnsignedRoot()
+	assert.NoError(t, err, "error exporting root")
+	assert.Len(t, root.Signatures, 0)
+	assert.Len(t, roles, 2)
+	assert.Equal(t, 1, roles[0].Threshold, "wrong threshold for the trusted root role")
+	assert.Equal(t, 2, roles[1].Threshold, "wrong threshold for the new root role")
+
+	err = repo.ImportRootSignatures(root)
+	assert.IsType(t, &ErrInsufficientSignatures{}, err, "%v", err)
+
+	signedBy, err := SignRoot(offline.KeyStoreManager, root, passphraseRetriever)
+	assert.NoError(t, err, "error signing root")
+	assert.Equal(t, []string{offlineKey.ID()}, signedBy)
+	err = repo.ImportRootSignatures(root)
+	assert.IsType(t, &ErrInsufficientSignatures{}, err, "%v", err)
+
+	_, err = SignRoot(repo.KeyStoreManager, root, passphraseRetriever)
+	assert.NoError(t, err, "error signing root")
+	assert.Len(t, root.Signatures, 2)
+	err = repo.ImportRootSignatures(root)
+	assert.NoError(t, err, "error importing root signatures")
+	cl, err := repo.GetChangelist()
+	assert.NoError(t, err)
+	assert.Len(t, cl.List(), 0, "published root changes are still pending")
+	cl.Close()
+
+	// a root can't be imported twice
+	assert.Error(t, repo.ImportRootSignatures(root))
+
+	consumer, err := NewNotaryRepository(consumerDir, gun, ts.URL, http.DefaultTransport)
+	assert.NoError(t, err, "error creating repository: %s", err)
+	consumer.KeyStoreManager.SetTrustOnFirstUse(true)
+	_, err = consumer.ListTargets()
+	assert.NoError(t, err)
+	rootRole := consumer.tufRepo.Root.Signed.Roles["root"]
+	assert.Equal(t, 2, rootRole.Threshold)
+	assert.Contains(t, rootRole.KeyIDs, offlineKey.ID())
+	assert.Len(t, consumer.tufRepo.Root.Signatures, 2)
+
+	// targets can still be published without the root keys
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	assert.NoError(t, repo.KeyStoreManager.RootKeyStore().Remove(roles[0].KeyIDs[0]))
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+}
+
+// TestDBChangelistPublish publishes changes recorded in a DBChangelist, and
+// checks they are stored in a single file.
+func TestDBChangelistPublish(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+	WithChangelist(DBChangelist)(repo)
+
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	currentTarget, err := NewTarget("current", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(currentTarget))
+
+	_, err = os.Stat(filepath.Join(tempBaseDir, "tuf", filepath.FromSlash(gun), "changelist.db"))
+	assert.NoError(t, err, "changelist file was not created")
+	_, err = os.Stat(filepath.Join(tempBaseDir, "tuf", filepath.FromSlash(gun), "changelist"))
+	assert.True(t, os.IsNotExist(err), "changes were stored in the default changelist")
+
+	err = repo.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error publishing")
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2, "unexpected number of targets returned by ListTargets")
+}
+
+// TestGetChangelist lists the unpublished changes of a repository, and
+// checks changes discarded from the changelist aren't published.
+func TestGetChangelist(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	currentTarget, err := NewTarget("current", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(currentTarget))
+
+	cl, err := repo.GetChangelist()
+	assert.NoError(t, err)
+	changes := cl.List()
+	assert.Len(t, changes, 2)
+	assert.Equal(t, "latest", changes[0].Path())
+	assert.Equal(t, "targets", changes[0].Scope())
+	assert.Equal(t, changelist.TypeTargetsTarget, changes[0].Type())
+
+	assert.NoError(t, cl.Remove([]int{0}))
+	assert.NoError(t, repo.Publish(passphraseRetriever))
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	assert.Equal(t, "current", targets[0].Name)
+}
+
+// TestChangelistHistory checks published changes are archived rather than
+// applied again, and replays them onto another repository.
+func TestChangelistHistory(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo := initializedRepo(t, tempBaseDir, "docker.com/notary", ts.URL)
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	assert.NoError(t, repo.Publish(passphraseRetriever))
+
+	cl, err := repo.GetChangelist()
+	assert.NoError(t, err)
+	assert.Len(t, cl.List(), 0, "published changes were not cleared")
+	history, err := repo.ChangelistHistory()
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Len(t, history[0].Changelist.List(), 1)
+
+	// publishing without changes doesn't add to the history
+	assert.NoError(t, repo.Publish(passphraseRetriever))
+	history, err = repo.ChangelistHistory()
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// changes added while publishing are kept for the next Publish
+	currentTarget, err := NewTarget("current", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, repo.AddTarget(currentTarget))
+	applied := cl.List()
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	assert.NoError(t, repo.archiveChanges(applied))
+	pending := cl.List()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "latest", pending[0].Path())
+	history, err = repo.ChangelistHistory()
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	archived := history[1].Changelist.List()
+	assert.Len(t, archived, 1)
+	assert.Equal(t, "current", archived[0].Path())
+
+	other := initializedRepo(t, tempBaseDir, "docker.com/other", ts.URL)
+	replayed, err := other.Replay(history[0].Changelist)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.NoError(t, other.Publish(passphraseRetriever))
+	targets, err := other.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1, "unexpected number of targets returned by ListTargets")
+	assert.Equal(t, "latest", targets[0].Name)
+}
+
+// interceptingTransport calls before ahead of the first request it sends
+// with the given method
+type interceptingTransport struct {
+	method string
+	before func()
+}
+
+func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.before != nil && req.Method == t.method {
+		before := t.before
+		t.before = nil
+		before()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestPublishConflict publishes changes while another publisher updates the
+// same repository, checking the changes are rebased on top of the other
+// update unless they modify the same targets.
+func TestPublishConflict(t *testing.T) {
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	defer os.RemoveAll(tempBaseDir)
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	latestTarget, err := NewTarget("latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	otherTarget, err := NewTarget("latest", "../fixtures/root-ca.crt")
+	assert.NoError(t, err, "error creating target")
+	currentTarget, err := NewTarget("current", "../fixtures/root-ca.crt")
+	assert.NoError(t, err, "error creating target")
+
+	repo := initializedRepo(t, tempBaseDir, gun, ts.URL)
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	assert.NoError(t, repo.Publish(passphraseRetriever))
+
+	// a second publisher holding the same keys, with its own changelist
+	transport := &interceptingTransport{method: "POST"}
+	concurrent, err := NewNotaryRepository(tempBaseDir, gun, ts.URL, transport, WithChangelist(DBChangelist))
+	assert.NoError(t, err, "error creating repository: %s", err)
+
+	// the first publisher's update is stored between the second publisher
+	// loading the metadata and pushing its own update
+	assert.NoError(t, repo.AddTarget(currentTarget))
+	transport.before = func() {
+		assert.NoError(t, repo.Publish(passphraseRetriever))
+	}
+	assert.NoError(t, concurrent.AddTarget(otherTarget))
+	err = concurrent.Publish(passphraseRetriever)
+	assert.NoError(t, err, "error rebasing changes")
+	targets, err := concurrent.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2, "unexpected number of targets returned by ListTargets")
+	for _, target := range targets {
+		assert.Equal(t, currentTarget.Hashes, target.Hashes, "wrong hashes for %s", target.Name)
+	}
+
+	// both publishers modify the same target differently
+	assert.NoError(t, repo.AddTarget(latestTarget))
+	transport.before = func() {
+		assert.NoError(t, repo.Publish(passphraseRetriever))
+	}
+	conflictingTarget, err := NewTarget("latest", "../fixtures/secure.example.com.crt")
+	assert.NoError(t, err, "error creating target")
+	assert.NoError(t, concurrent.AddTarget(conflictingTarget))
+	err = concurrent.Publish(passphraseRetriever)
+	assert.IsType(t, &ErrConflict{}, err, "%v", err)
+	assert.Equal(t, "targets", err.(*ErrConflict).Role)
+	assert.Equal(t, "latest", err.(*ErrConflict).Path)
+
+	// the conflicting change is kept, and the other update is untouched
+	cl, err := concurrent.GetChangelist()
+	assert.NoError(t, err)
+	assert.Len(t, cl.List(), 1)
+	target, err := concurrent.GetTargetByName("latest")
+	assert.NoError(t, err)
+	assert.Equal(t, latestTarget.Hashes, target.Hashes)
+}
+
+// TestServerErrors checks the JSON error envelopes returned by the notary
+// server are turned into typed errors.
+func TestServerErrors(t *testing.T) {
+	gun := "docker.com/notary"
+
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	err := setMultiMeta(ts.URL, gun, http.DefaultTransport, map[string][]byte{"invalid": []byte("{}")})
+	assert.IsType(t, &ErrPublishRejected{}, err, "%v", err)
+	assert.Equal(t, notaryerrors.ErrorCodeInvalidRole, err.(*ErrPublishRejected).Code)
+	assert.Equal(t, http.StatusBadRequest, err.(*ErrPublishRejected).StatusCode)
+
+	err = setMultiMeta(ts.URL, gun, http.DefaultTransport, map[string][]byte{"targets": []byte("not json")})
+	assert.IsType(t, &ErrPublishRejected{}, err, "%v", err)
+	assert.Equal(t, notaryerrors.ErrorCodeMalformedUpload, err.(*ErrPublishRejected).Code)
+
+	// missing metadata is still reported by the store
+	remote, err := getRemoteStore(ts.URL, gun, http.DefaultTransport)
+	assert.NoError(t, err)
+	_, err = remote.GetMeta("root", 5<<20)
+	assert.IsType(t, &store.ErrMetaNotFound{}, err, "%v", err)
+
+	// missing server keys are reported rather than parsed as keys
+	_, err = remote.GetKey("snapshot")
+	assert.IsType(t, &ErrNoServerKey{}, err, "%v", err)
+	assert.Equal(t, notaryerrors.ErrorCodeNoSnapshotKey, err.(*ErrNoServerKey).Code)
+
+	// other failures are returned as the error matching the envelope
+	failing, mux := createTestServer(t)
+	defer failing.Close()
+	serveError := func(path string, status int, code notaryerrors.ErrorCode) {
+		mux.HandleFunc("/v2/docker.com/notary/_trust/tuf/"+path, func(w http.ResponseWriter, r *http.Request) {
+			notaryerrors.ServeJSON(w, &notaryerrors.HTTPError{HTTPStatus: status, Code: code})
+		})
+	}
+	serveError("root.json", http.StatusUnauthorized, notaryerrors.ErrorCodeUnauthorized)
+	serveError("targets.json", http.StatusInternalServerError, notaryerrors.ErrorCodeNoStorage)
+	serveError("snapshot.json", http.StatusBadRequest, notaryerrors.ErrorCodeInvalidParameter)
+	remote, err = getRemoteStore(failing.URL, gun, http.DefaultTransport)
+	assert.NoError(t, err)
+
+	_, err = remote.GetMeta("root", 5<<20)
+	assert.IsType(t, &ErrUnauthorized{}, err, "%v", err)
+	assert.Equal(t, "unauthorized: authentication required", err.Error())
+
+	_, err = remote.GetMeta("targets", 5<<20)
+	assert.IsType(t, &ErrServerUnavailable{}, err, "%v", err)
+	assert.Equal(t, notaryerrors.ErrorCodeNoStorage, err.(*ErrServerUnavailable).Code)
+	assert.Equal(t, http.StatusInternalServerError, err.(*ErrServerUnavailable).StatusCode)
+
+	_, err = remote.GetMeta("snapshot", 5<<20)
+	assert.IsType(t, &ErrInvalidRequest{}, err, "%v", err)
+	assert.Equal(t, notaryerrors.ErrorCodeInvalidParameter, err.(*ErrInvalidRequest).Code)
+
+	// publishing reports the same errors when the server isn't at fault
+	mux.HandleFunc("/v2/docker.com/notary/_trust/tuf/", func(w http.ResponseWriter, r *http.Request) {
+		notaryerrors.ServeJSON(w, &notaryerrors.HTTPError{HTTPStatus: http.StatusUnauthorized, Code: notaryerrors.ErrorCodeUnauthorized})
+	})
+	err = setMultiMeta(failing.URL, gun, http.DefaultTransport, map[string][]byte{"targets": []byte("{}")})
+	assert.IsType(t, &ErrUnauthorized{}, err, "%v", err)
+}
@@ -1,13 +1,24 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary/client/changelist"
+	notaryerrors "github.com/docker/notary/errors"
 	"github.com/endophage/gotuf"
 	"github.com/endophage/gotuf/data"
+	tuferrors "github.com/endophage/gotuf/errors"
+	"github.com/endophage/gotuf/signed"
 	"github.com/endophage/gotuf/store"
 )
 
@@ -19,36 +30,221 @@ func getRemoteStore(baseURL, gun string, rt http.RoundTripper) (store.RemoteStor
 		"json",
 		"",
 		"key",
-		rt,
+		errorTransport{rt: rt},
 	)
 }
 
+// errorTransport turns failed notary-server responses into the typed errors
+// matching their JSON error envelope, as the HTTPStore doesn't check the
+// status of most responses. 404s for metadata are passed through for the
+// HTTPStore to return a store.ErrMetaNotFound.
+type errorTransport struct {
+	rt http.RoundTripper
+}
+
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = serverError(resp.StatusCode, body)
+	if _, ok := err.(*ErrNoServerKey); !ok && resp.StatusCode == http.StatusNotFound {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	return nil, err
+}
+
+// serverError returns the typed error for a failed notary-server response,
+// given its status and the JSON error envelope in its body
+func serverError(statusCode int, body []byte) error {
+	serverErr := notaryerrors.ParseError(body)
+	switch {
+	case statusCode == http.StatusUnauthorized || serverErr.Code == notaryerrors.ErrorCodeUnauthorized:
+		return &ErrUnauthorized{Msg: serverErr.Message}
+	case serverErr.Code == notaryerrors.ErrorCodeNoTimestampKey || serverErr.Code == notaryerrors.ErrorCodeNoSnapshotKey:
+		return &ErrNoServerKey{Code: serverErr.Code, Msg: serverErr.Message}
+	case statusCode >= http.StatusInternalServerError:
+		return &ErrServerUnavailable{StatusCode: statusCode, Code: serverErr.Code, Msg: serverErr.Message}
+	default:
+		return &ErrInvalidRequest{StatusCode: statusCode, Code: serverErr.Code, Msg: serverErr.Message}
+	}
+}
+
+// setMultiMeta pushes the metadata for all the roles in updates to the
+// notary server in a single request, so the server accepts or rejects the
+// update as a whole.
+func setMultiMeta(baseURL, gun string, rt http.RoundTripper, updates map[string][]byte) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for role, blob := range updates {
+		part, err := writer.CreateFormFile("files", role+".json")
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(blob); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", baseURL+"/v2/"+gun+"/_trust/tuf/", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode >= http.StatusInternalServerError {
+			return serverError(resp.StatusCode, body)
+		}
+		serverErr := notaryerrors.ParseError(body)
+		return &ErrPublishRejected{
+			StatusCode: resp.StatusCode,
+			Code:       serverErr.Code,
+			Msg:        serverErr.Message,
+		}
+	}
+	return nil
+}
+
 func applyChangelist(repo *tuf.TufRepo, cl changelist.Changelist) error {
-	changes := cl.List()
-	var err error
+	return applyChanges(repo, cl.List())
+}
+
+func applyChanges(repo *tuf.TufRepo, changes []changelist.Change) error {
 	for _, c := range changes {
-		if c.Scope() == "targets" {
-			applyTargetsChange(repo, c)
+		var err error
+		if isTargetsRole(c.Scope()) {
+			err = applyTargetsChange(repo, c)
+		} else if c.Scope() == data.ValidRoles["root"] {
+			err = applyRootChange(repo, c)
+		} else {
+			logrus.Debug("scope not supported: ", c.Scope())
 		}
 		if err != nil {
 			return err
 		}
 	}
+	// a threshold may be raised before the keys that meet it are added, so
+	// the base roles are only checked once all the changes are applied
+	for _, name := range []string{data.ValidRoles["root"], data.ValidRoles["targets"]} {
+		role := repo.Root.Signed.Roles[name]
+		if len(role.KeyIDs) < role.Threshold {
+			return fmt.Errorf("%s requires a threshold of %d but only has %d keys", name, role.Threshold, len(role.KeyIDs))
+		}
+	}
 	return nil
 }
 
-func applyTargetsChange(repo *tuf.TufRepo, c changelist.Change) error {
-	var err error
-	meta := &data.FileMeta{}
-	err = json.Unmarshal(c.Content(), meta)
+func applyRootChange(repo *tuf.TufRepo, c changelist.Change) error {
+	if c.Type() != changelist.TypeBaseRole {
+		return fmt.Errorf("only base role changes supported on root")
+	}
+	br := changelist.TufBaseRole{}
+	err := json.Unmarshal(c.Content(), &br)
 	if err != nil {
+		return err
+	}
+	name := c.Path()
+	role, ok := repo.Root.Signed.Roles[name]
+	if !ok {
+		return tuferrors.ErrInvalidRole{Role: name}
+	}
+
+	// only modify root if the change hasn't been applied yet, as it may
+	// already be part of a root published with ImportRootSignatures
+	trusted := make(map[string]bool)
+	for _, id := range role.KeyIDs {
+		trusted[id] = true
+	}
+	changed := false
+	for _, id := range br.RemoveKeys {
+		if !trusted[id] {
+			continue
+		}
+		if err := repo.RemoveBaseKeys(name, id); err != nil {
+			return err
+		}
+		delete(trusted, id)
+		changed = true
+	}
+	for _, k := range br.AddKeys {
+		if trusted[k.ID()] {
+			continue
+		}
+		if err := repo.AddBaseKeys(name, k); err != nil {
+			return err
+		}
+		trusted[k.ID()] = true
+		changed = true
+	}
+	if br.Threshold > 0 && br.Threshold != role.Threshold {
+		role.Threshold = br.Threshold
+		repo.Root.Dirty = true
+		changed = true
+	}
+	if !changed {
 		return nil
 	}
-	if c.Action() == changelist.ActionCreate {
+	logrus.Debugf("changelist updated %s role: %d keys, threshold %d", name, len(role.KeyIDs), role.Threshold)
+
+	// the current metadata has to be signed again to meet the new role
+	if t, ok := repo.Targets[name]; ok {
+		t.Dirty = true
+	}
+	return nil
+}
+
+// isTargetsRole returns true if role is the base targets role
+// or one of its delegations
+func isTargetsRole(role string) bool {
+	return role == data.ValidRoles["targets"] || strings.HasPrefix(role, data.ValidRoles["targets"]+"/")
+}
+
+func applyTargetsChange(repo *tuf.TufRepo, c changelist.Change) error {
+	switch c.Type() {
+	case changelist.TypeTargetsTarget:
+		return changeTargetMeta(repo, c)
+	case changelist.TypeTargetsDelegation:
+		return changeTargetsDelegation(repo, c)
+	default:
+		return fmt.Errorf("only target meta and delegations changes supported")
+	}
+}
+
+func changeTargetMeta(repo *tuf.TufRepo, c changelist.Change) error {
+	var err error
+	switch c.Action() {
+	case changelist.ActionCreate:
+		logrus.Debug("changelist add: ", c.Path())
+		meta := &data.FileMeta{}
+		err = json.Unmarshal(c.Content(), meta)
+		if err != nil {
+			return err
+		}
 		files := data.Files{c.Path(): *meta}
-		_, err = repo.AddTargets("targets", files)
-	} else if c.Action() == changelist.ActionDelete {
-		err = repo.RemoveTargets("targets", c.Path())
+		_, err = repo.AddTargets(c.Scope(), files)
+	case changelist.ActionDelete:
+		logrus.Debug("changelist remove: ", c.Path())
+		err = repo.RemoveTargets(c.Scope(), c.Path())
+	default:
+		logrus.Debug("action not yet supported: ", c.Action())
 	}
 	if err != nil {
 		// TODO(endophage): print out rem entries as files that couldn't
@@ -58,7 +254,326 @@ func applyTargetsChange(repo *tuf.TufRepo, c changelist.Change) error {
 	return nil
 }
 
+func changeTargetsDelegation(repo *tuf.TufRepo, c changelist.Change) error {
+	switch c.Action() {
+	case changelist.ActionCreate, changelist.ActionUpdate:
+		td := changelist.TufDelegation{}
+		err := json.Unmarshal(c.Content(), &td)
+		if err != nil {
+			return err
+		}
+		r, err := td.ToNewRole(c.Path())
+		if err != nil {
+			return err
+		}
+		return updateDelegation(repo, r, td.Keys)
+	case changelist.ActionDelete:
+		return removeDelegation(repo, c.Path())
+	default:
+		return fmt.Errorf("unsupported action against delegations: %d", c.Action())
+	}
+}
+
+// updateDelegation adds or replaces the delegated role in its parent's
+// delegations. TufRepo.UpdateDelegations isn't used as it discards any
+// existing targets of the role and can replace the wrong role when the
+// parent already has other delegations.
+func updateDelegation(repo *tuf.TufRepo, role *data.Role, keys []*data.PublicKey) error {
+	if !role.IsDelegation() || !role.IsValid() {
+		return tuferrors.ErrInvalidRole{Role: role.Name}
+	}
+	parent, ok := repo.Targets[path.Dir(role.Name)]
+	if !ok {
+		return tuferrors.ErrInvalidRole{Role: role.Name}
+	}
+	if parent.Signed.Delegations.Keys == nil {
+		parent.Signed.Delegations.Keys = make(map[string]*data.PublicKey)
+	}
+	for _, k := range keys {
+		parent.Signed.Delegations.Keys[k.ID()] = k
+	}
+
+	replaced := false
+	for i, r := range parent.Signed.Delegations.Roles {
+		if r.Name == role.Name {
+			parent.Signed.Delegations.Roles[i] = role
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		parent.Signed.Delegations.Roles = append(parent.Signed.Delegations.Roles, role)
+	}
+	pruneDelegationKeys(&parent.Signed.Delegations)
+
+	if err := reloadTargets(repo, path.Dir(role.Name)); err != nil {
+		return err
+	}
+	if _, ok := repo.Targets[role.Name]; !ok {
+		repo.Targets[role.Name] = data.NewTargets()
+	}
+	return nil
+}
+
+// removeDelegation removes the delegated role, and any roles delegated
+// from it, from the repo. Removing a role that isn't delegated is an error,
+// so a misspelt name isn't published as a no-op.
+func removeDelegation(repo *tuf.TufRepo, name string) error {
+	parentName := path.Dir(name)
+	parent, ok := repo.Targets[parentName]
+	if !ok {
+		return tuferrors.ErrInvalidRole{Role: name}
+	}
+	roles := parent.Signed.Delegations.Roles
+	found := false
+	for i, r := range roles {
+		if r.Name == name {
+			parent.Signed.Delegations.Roles = append(roles[:i], roles[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return tuferrors.ErrInvalidRole{Role: name}
+	}
+	pruneDelegationKeys(&parent.Signed.Delegations)
+
+	if err := reloadTargets(repo, parentName); err != nil {
+		return err
+	}
+	for role := range repo.Targets {
+		if role == name || strings.HasPrefix(role, name+"/") {
+			delete(repo.Targets, role)
+			delete(repo.Snapshot.Signed.Meta, role)
+			repo.Snapshot.Dirty = true
+		}
+	}
+	return nil
+}
+
+// pruneDelegationKeys removes keys that are no longer used by any of the
+// delegated roles
+func pruneDelegationKeys(d *data.Delegations) {
+	used := make(map[string]bool)
+	for _, r := range d.Roles {
+		for _, id := range r.KeyIDs {
+			used[id] = true
+		}
+	}
+	for id := range d.Keys {
+		if !used[id] {
+			delete(d.Keys, id)
+		}
+	}
+}
+
+// reloadTargets re-adds the targets role to the repo so that the keys and
+// roles it delegates to are registered for signing, and marks it as
+// modified.
+func reloadTargets(repo *tuf.TufRepo, role string) error {
+	s, err := repo.Targets[role].ToSigned()
+	if err != nil {
+		return err
+	}
+	if err := repo.SetTargets(role, s); err != nil {
+		return err
+	}
+	repo.Targets[role].Dirty = true
+	return nil
+}
+
+// targetsRole returns the definition of a targets role, looking it up in
+// the root for the base targets role and in the parent role for delegations.
+func targetsRole(repo *tuf.TufRepo, name string) *data.Role {
+	if name == data.ValidRoles["targets"] {
+		base, ok := repo.Root.Signed.Roles[name]
+		if !ok {
+			return nil
+		}
+		return &data.Role{RootRole: *base, Name: name}
+	}
+	parent, ok := repo.Targets[path.Dir(name)]
+	if !ok {
+		return nil
+	}
+	for _, r := range parent.Signed.Delegations.Roles {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// checkThreshold returns an ErrInsufficientSignatures unless s carries
+// signatures from at least threshold of the keys of each of the roles
+func checkThreshold(s *data.Signed, roles ...*data.Role) error {
+	for _, role := range roles {
+		count := 0
+		for _, sig := range s.Signatures {
+			if role.ValidKey(sig.KeyID) {
+				count++
+			}
+		}
+		if count < role.Threshold {
+			return &ErrInsufficientSignatures{Role: role.Name, Signatures: count, Threshold: role.Threshold}
+		}
+	}
+	return nil
+}
+
+// rootRole returns a copy of the root role defined by root, which isn't
+// affected by later changes to root
+func rootRole(root *data.SignedRoot) *data.Role {
+	return baseRole(root, data.ValidRoles["root"])
+}
+
+// baseRole returns a copy of the base role called name defined by root
+func baseRole(root *data.SignedRoot, name string) *data.Role {
+	base := root.Signed.Roles[name]
+	keyIDs := make([]string, len(base.KeyIDs))
+	copy(keyIDs, base.KeyIDs)
+	return &data.Role{
+		RootRole: data.RootRole{KeyIDs: keyIDs, Threshold: base.Threshold},
+		Name:     name,
+	}
+}
+
+// sameRole returns true if a and b have the same keys and threshold
+func sameRole(a, b *data.Role) bool {
+	if a.Threshold != b.Threshold || len(a.KeyIDs) != len(b.KeyIDs) {
+		return false
+	}
+	for _, id := range a.KeyIDs {
+		if !b.ValidKey(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// validSignatures returns the signatures on root that verify against the
+// keys, dropping invalid signatures and signatures from unknown keys
+func validSignatures(root *data.Signed, keys map[string]*data.PublicKey) []data.Signature {
+	var valid []data.Signature
+	for _, sig := range root.Signatures {
+		k, ok := keys[sig.KeyID]
+		if !ok {
+			continue
+		}
+		single := &data.Signed{Signed: root.Signed, Signatures: []data.Signature{sig}}
+		if _, err := signed.VerifyRoot(single, 0, map[string]*data.PublicKey{sig.KeyID: k}, 1); err != nil {
+			logrus.Debugf("dropping invalid signature from key %s: %v", sig.KeyID, err)
+			continue
+		}
+		valid = append(valid, sig)
+	}
+	return valid
+}
+
+// rootKeys returns the keys of the root role defined by root
+func rootKeys(root *data.SignedRoot) []*data.PublicKey {
+	var keys []*data.PublicKey
+	for _, id := range root.Signed.Roles[data.ValidRoles["root"]].KeyIDs {
+		if k, ok := root.Signed.Keys[id]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// targetsFiles returns a copy of the targets of each targets role in repo
+func targetsFiles(repo *tuf.TufRepo) map[string]data.Files {
+	files := make(map[string]data.Files)
+	for role, t := range repo.Targets {
+		files[role] = make(data.Files)
+		for path, meta := range t.Signed.Targets {
+			files[role][path] = meta
+		}
+	}
+	return files
+}
+
+// checkRebase returns an ErrConflict if any target modified remotely, from
+// base to latest, is modified differently by the local changes, from latest
+// to rebased.
+func checkRebase(base, latest, rebased map[string]data.Files) error {
+	roles := make(map[string]bool)
+	for _, files := range []map[string]data.Files{base, latest, rebased} {
+		for role := range files {
+			roles[role] = true
+		}
+	}
+	for role := range roles {
+		paths := make(map[string]bool)
+		for _, files := range []map[string]data.Files{base, latest, rebased} {
+			for path := range files[role] {
+				paths[path] = true
+			}
+		}
+		for path := range paths {
+			b, inBase := base[role][path]
+			l, inLatest := latest[role][path]
+			n, inRebased := rebased[role][path]
+			remote := inBase != inLatest || (inBase && !reflect.DeepEqual(b, l))
+			local := inLatest != inRebased || (inLatest && !reflect.DeepEqual(l, n))
+			if remote && local {
+				return &ErrConflict{Role: role, Path: path}
+			}
+		}
+	}
+	return nil
+}
+
 func nearExpiry(r *data.SignedRoot) bool {
 	plus6mo := time.Now().AddDate(0, 6, 0)
 	return r.Signed.Expires.Before(plus6mo)
 }
+
+// getRemoteKey fetches the public key the remote server holds for role
+func getRemoteKey(remote store.RemoteStore, role string) (*data.PublicKey, error) {
+	rawKey, err := remote.GetKey(role)
+	if err != nil {
+		return nil, err
+	}
+	parsedKey := &data.TUFKey{}
+	err = json.Unmarshal(rawKey, parsedKey)
+	if err != nil {
+		return nil, err
+	}
+	// Turn the JSON key from the remote server into a TUFKey
+	key := data.NewPublicKey(parsedKey.Algorithm(), parsedKey.Public())
+	logrus.Debugf("got remote %s %s key with keyID: %s", parsedKey.Algorithm(), role, key.ID())
+	return key, nil
+}
+
+// createRemoteKey asks the notary server to create a key for a role it
+// manages, returning the public key. For the snapshot, the server creates
+// the key only if it doesn't hold one yet. For the timestamp, the server
+// creates a new key and keeps using the current one until a root listing the
+// new key has been published.
+func createRemoteKey(baseURL, gun string, rt http.RoundTripper, role string) (*data.PublicKey, error) {
+	req, err := http.NewRequest("POST", baseURL+"/v2/"+gun+"/_trust/tuf/"+role+".key", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, serverError(resp.StatusCode, body)
+	}
+	parsedKey := &data.TUFKey{}
+	err = json.Unmarshal(body, parsedKey)
+	if err != nil {
+		return nil, err
+	}
+	key := data.NewPublicKey(parsedKey.Algorithm(), parsedKey.Public())
+	logrus.Debugf("created remote %s %s key with keyID: %s", parsedKey.Algorithm(), role, key.ID())
+	return key, nil
+}
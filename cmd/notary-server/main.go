@@ -79,22 +79,39 @@ func main() {
 			viper.GetString("trust_service.hostname"),
 			viper.GetString("trust_service.port"),
 			viper.GetString("trust_service.tls_ca_file"),
+			viper.GetString("trust_service.tls_client_cert"),
+			viper.GetString("trust_service.tls_client_key"),
 		)
 	} else {
 		logrus.Info("[Notary Server] : Using local signing service")
 		trust = signed.NewEd25519()
 	}
 
-	if viper.GetString("storage.backend") == "mysql" {
-		logrus.Debug("Using mysql backend")
-		dbURL := viper.GetString("storage.db_url")
-		db, err := sql.Open("mysql", dbURL)
+	switch backend := viper.GetString("storage.backend"); backend {
+	case "mysql", "postgres":
+		logrus.Debug("Using ", backend, " backend")
+		store, err := openSQLStorage(backend, viper.GetString("storage.db_url"))
 		if err != nil {
 			logrus.Fatal("[Notary Server] Error starting DB driver: ", err.Error())
 			return // not strictly needed but let's be explicit
 		}
-		ctx = context.WithValue(ctx, "metaStore", storage.NewMySQLStorage(db))
-	} else {
+		if viper.GetBool("storage.migrate") {
+			if err := store.MigrateSchema(); err != nil {
+				logrus.Fatal("[Notary Server] Error migrating the DB schema: ", err.Error())
+				return
+			}
+		}
+		ctx = context.WithValue(ctx, "metaStore", store)
+	case "file":
+		logrus.Debug("Using file backend")
+		store, err := storage.NewFileStorage(viper.GetString("storage.file"))
+		if err != nil {
+			logrus.Fatal("[Notary Server] Error opening the storage file: ", err.Error())
+			return
+		}
+		defer store.Close()
+		ctx = context.WithValue(ctx, "metaStore", store)
+	default:
 		logrus.Debug("Using memory backend")
 		ctx = context.WithValue(ctx, "metaStore", storage.NewMemStorage())
 	}
@@ -113,6 +130,22 @@ func main() {
 	return
 }
 
+// openSQLStorage opens the database of the given backend. The PostgreSQL
+// driver is only registered when building with the postgres tag.
+func openSQLStorage(backend, dbURL string) (*storage.SQLStorage, error) {
+	db, err := sql.Open(backend, dbURL)
+	if err != nil {
+		if backend == "postgres" {
+			return nil, fmt.Errorf("%v, notary-server must be built with -tags postgres", err)
+		}
+		return nil, err
+	}
+	if backend == "postgres" {
+		return storage.NewPostgresStorage(db), nil
+	}
+	return storage.NewMySQLStorage(db), nil
+}
+
 func usage() {
 	fmt.Println("usage:", os.Args[0])
 	flag.PrintDefaults()
@@ -0,0 +1,9 @@
+//go:build postgres
+// +build postgres
+
+package main
+
+import (
+	// registers the "postgres" database driver
+	_ "github.com/lib/pq"
+)
@@ -3,12 +3,18 @@ package main
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	_ "expvar"
 	"flag"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -27,16 +33,34 @@ const (
 	_Addr      = ":4444"
 	_RpcAddr   = ":7899"
 	_DebugAddr = "localhost:8080"
+
+	// keyDBPassphraseEnv holds the passphrase the key database is encrypted
+	// with, when no KEK file is given
+	keyDBPassphraseEnv = "NOTARY_SIGNER_KEYDB_PASSPHRASE"
+
+	// ecdsaKeyDBDir is the subdirectory of the key database storing the
+	// ECDSA keys
+	ecdsaKeyDBDir = "ecdsa"
+
+	// keyMetadataFile is the file of the key database storing the metadata
+	// of the keys
+	keyMetadataFile = "metadata.json"
 )
 
 var debug bool
-var certFile, keyFile, pkcs11Lib, pin string
+var maxRPCConns int
+var certFile, keyFile, pkcs11Lib, pin, keyDBDir, kekFile, clientCAFile, clientAllowFile string
 
 func init() {
 	flag.StringVar(&certFile, "cert", "", "Intermediate certificates")
 	flag.StringVar(&keyFile, "key", "", "Private key file")
 	flag.StringVar(&pkcs11Lib, "pkcs11", "", "enables HSM mode and uses the provided pkcs11 library path")
 	flag.StringVar(&pin, "pin", "", "the PIN to use for the HSM")
+	flag.StringVar(&keyDBDir, "keydb", "", "directory storing the ED25519 and ECDSA keys, encrypted. Keys are kept in memory if unset")
+	flag.StringVar(&kekFile, "kek", "", "file holding the hex encoded 32 byte key encrypting the keydb. Otherwise the key is derived from the "+keyDBPassphraseEnv+" environment variable")
+	flag.StringVar(&clientCAFile, "client-ca", "", "file holding the CA certificates of the clients. If set, clients must authenticate with a certificate signed by one of them")
+	flag.StringVar(&clientAllowFile, "client-allow", "", "JSON file mapping RPC names to the client certificate names allowed to call them, i.e. {\"Sign\": [\"notary-server\"]}. Requires -client-ca")
+	flag.IntVar(&maxRPCConns, "max-rpc-conns", 100, "maximum number of RPC connections served at once when clients authenticate with -client-ca, further connections wait to be accepted")
 	flag.BoolVar(&debug, "debug", false, "show the version and exit")
 }
 
@@ -68,6 +92,15 @@ func main() {
 	}
 	tlsConfig.Rand = rand.Reader
 
+	var authz *api.ClientAuthorizer
+	if clientCAFile != "" {
+		tlsConfig.ClientCAs = loadCertPool(clientCAFile)
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		authz = setupClientAuthorizer()
+	} else if clientAllowFile != "" {
+		log.Fatalf("Using -client-allow requires -client-ca")
+	}
+
 	sigServices := make(signer.SigningServiceIndex)
 
 	if pkcs11Lib != "" {
@@ -79,33 +112,47 @@ func main() {
 
 		defer cleanup(ctx, session)
 
-		sigServices[data.RSAKey] = api.NewRSASigningService(ctx, session)
+		rsaSigService, err := api.NewRSASigningService(ctx, session)
+		if err != nil {
+			log.Fatalf("Failed to load the keys from the HSM: %v", err)
+		}
+		sigServices[data.RSAKey] = rsaSigService
 	}
 
-	sigServices[data.ED25519Key] = api.EdDSASigningService{KeyDB: keys.NewKeyDB()}
+	edKeyDB, ecdsaKeyDB := setupKeyDBs()
+	sigServices[data.ED25519Key] = api.EdDSASigningService{KeyDB: edKeyDB}
+	sigServices[data.ECDSAKey] = api.ECDSASigningService{KeyDB: ecdsaKeyDB}
 
-	//RPC server setup
-	kms := &api.KeyManagementServer{SigServices: sigServices}
-	ss := &api.SignerServer{SigServices: sigServices}
+	keyMetadata := setupKeyMetadata()
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterKeyManagementServer(grpcServer, kms)
-	pb.RegisterSignerServer(grpcServer, ss)
+	//RPC server setup
+	kms := &api.KeyManagementServer{SigServices: sigServices, KeyMetadata: keyMetadata}
+	ss := &api.SignerServer{SigServices: sigServices, KeyMetadata: keyMetadata}
 
 	lis, err := net.Listen("tcp", _RpcAddr)
 	if err != nil {
 		log.Fatalf("failed to listen %v", err)
 	}
-	creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+	// the gRPC services use the same TLS settings as the HTTP ones
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		log.Fatalf("failed to generate credentials %v", err)
 	}
-	go grpcServer.Serve(creds.NewListener(lis))
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	creds := credentials.NewTLS(tlsConfig)
+	if clientCAFile == "" {
+		grpcServer := grpc.NewServer()
+		pb.RegisterKeyManagementServer(grpcServer, kms)
+		pb.RegisterSignerServer(grpcServer, ss)
+		go grpcServer.Serve(creds.NewListener(lis))
+	} else {
+		go api.ServeAuthorized(creds.NewListener(lis), kms, ss, authz, maxRPCConns)
+	}
 
 	//HTTP server setup
 	server := http.Server{
 		Addr:      _Addr,
-		Handler:   api.Handlers(sigServices),
+		Handler:   api.AuthorizedHandlers(sigServices, authz),
 		TLSConfig: tlsConfig,
 	}
 
@@ -135,6 +182,98 @@ func debugServer(addr string) {
 	}
 }
 
+// loadCertPool returns a pool of the certificates in a PEM file
+func loadCertPool(file string) *x509.CertPool {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Failed to read the client CA certificates: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		log.Fatalf("No certificates found in %s", file)
+	}
+	return pool
+}
+
+// setupClientAuthorizer returns the authorizer restricting the RPCs clients
+// may call, which is nil if no allow-list is given
+func setupClientAuthorizer() *api.ClientAuthorizer {
+	if clientAllowFile == "" {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(clientAllowFile)
+	if err != nil {
+		log.Fatalf("Failed to read the client allow-list: %v", err)
+	}
+	var allowList map[string][]string
+	if err := json.Unmarshal(raw, &allowList); err != nil {
+		log.Fatalf("Failed to parse the client allow-list: %v", err)
+	}
+	authz, err := api.NewClientAuthorizer(allowList)
+	if err != nil {
+		log.Fatalf("Invalid client allow-list: %v", err)
+	}
+	return authz
+}
+
+// setupKeyDBs returns the databases storing the ED25519 and ECDSA keys, which
+// are only persisted if a keydb directory is given. The ECDSA keys are kept in
+// a subdirectory, encrypted with the same key, so each signing service only
+// finds its own keys.
+func setupKeyDBs() (signer.KeyDatabase, signer.KeyDatabase) {
+	if keyDBDir == "" {
+		log.Println("[Notary-signer] : ED25519 and ECDSA keys are kept in memory and will be lost on restart")
+		return keys.NewKeyDB(), keys.NewKeyDB()
+	}
+
+	var kek []byte
+	if kekFile != "" {
+		raw, err := ioutil.ReadFile(kekFile)
+		if err != nil {
+			log.Fatalf("Failed to read the key-encryption key: %v", err)
+		}
+		kek, err = hex.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil {
+			log.Fatalf("Failed to decode the key-encryption key: %v", err)
+		}
+	} else {
+		passphrase := os.Getenv(keyDBPassphraseEnv)
+		if passphrase == "" {
+			log.Fatalf("Using -keydb requires -kek or the %s environment variable", keyDBPassphraseEnv)
+		}
+		var err error
+		kek, err = keys.DeriveKEK(keyDBDir, passphrase)
+		if err != nil {
+			log.Fatalf("Failed to derive the key-encryption key: %v", err)
+		}
+	}
+
+	edKeyDB, err := keys.NewFileKeyDB(keyDBDir, kek)
+	if err != nil {
+		log.Fatalf("Failed to open the key database: %v", err)
+	}
+	ecdsaKeyDB, err := keys.NewFileKeyDB(filepath.Join(keyDBDir, ecdsaKeyDBDir), kek)
+	if err != nil {
+		log.Fatalf("Failed to open the ECDSA key database: %v", err)
+	}
+	return edKeyDB, ecdsaKeyDB
+}
+
+// setupKeyMetadata returns the database storing the metadata of the keys of
+// all the signing services, which is kept in the keydb directory if one is
+// given
+func setupKeyMetadata() signer.KeyMetadataStore {
+	path := ""
+	if keyDBDir != "" {
+		path = filepath.Join(keyDBDir, keyMetadataFile)
+	}
+	metadata, err := keys.NewMetadataDB(path)
+	if err != nil {
+		log.Fatalf("Failed to open the key metadata database: %v", err)
+	}
+	return metadata
+}
+
 // SetupHSMEnv is a method that depends on the existences
 func SetupHSMEnv(libraryPath string) (*pkcs11.Ctx, pkcs11.SessionHandle) {
 	p := pkcs11.New(libraryPath)
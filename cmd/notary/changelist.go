@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	notaryclient "github.com/docker/notary/client"
+	"github.com/docker/notary/client/changelist"
+	"github.com/endophage/gotuf/data"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var resetNumber int
+
+var cmdTufStatus = &cobra.Command{
+	Use:   "status [ GUN ]",
+	Short: "Lists the unpublished changes to a trusted collection.",
+	Long:  "lists the changes to the local trusted collection identified by the Globally Unique Name that will be sent to the remote trusted server on the next publish.",
+	Run:   tufStatus,
+}
+
+var cmdTufReset = &cobra.Command{
+	Use:   "reset [ GUN ]",
+	Short: "Discards unpublished changes to a trusted collection.",
+	Long:  "discards the changes to the local trusted collection identified by the Globally Unique Name that haven't been published yet, either all of them or the one selected by its number in notary status.",
+	Run:   tufReset,
+}
+
+var cmdTufHistory = &cobra.Command{
+	Use:   "history [ GUN ]",
+	Short: "Lists the published changes to a trusted collection.",
+	Long:  "lists the changelists of the local trusted collection identified by the Globally Unique Name that were accepted by the remote trusted server, from the oldest to the latest.",
+	Run:   tufHistory,
+}
+
+var cmdTufReplay = &cobra.Command{
+	Use:   "replay [ GUN ] [ changelist ] [ target GUN ]",
+	Short: "Reapplies published changes to another trusted collection.",
+	Long:  "adds the target and delegation changes of a changelist listed by notary history to the local trusted collection identified by the target Globally Unique Name, to be sent on its next publish.",
+	Run:   tufReplay,
+}
+
+var changeActions = map[int]string{
+	changelist.ActionCreate: "create",
+	changelist.ActionUpdate: "update",
+	changelist.ActionDelete: "delete",
+}
+
+func tufStatus(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		fatalf("must specify a GUN")
+	}
+	gun := args[0]
+
+	cl := getChangelist(gun)
+	changes := cl.List()
+	if len(changes) == 0 {
+		fmt.Printf("No unpublished changes for %s\n", gun)
+		return
+	}
+
+	fmt.Printf("Unpublished changes for %s:\n\n", gun)
+	printChanges(changes)
+}
+
+func tufReset(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		fatalf("must specify a GUN")
+	}
+	gun := args[0]
+
+	cl := getChangelist(gun)
+	if resetNumber < 0 {
+		if err := cl.Clear(""); err != nil {
+			fatalf(err.Error())
+		}
+		fmt.Printf("Discarded all the unpublished changes for %s\n", gun)
+		return
+	}
+	if err := cl.Remove([]int{resetNumber}); err != nil {
+		fatalf(err.Error())
+	}
+	fmt.Printf("Discarded change %d for %s\n", resetNumber, gun)
+}
+
+func tufHistory(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		fatalf("must specify a GUN")
+	}
+	gun := args[0]
+
+	history, err := getRepository(gun).ChangelistHistory()
+	if err != nil {
+		fatalf(err.Error())
+	}
+	if len(history) == 0 {
+		fmt.Printf("No published changes for %s\n", gun)
+		return
+	}
+	for _, h := range history {
+		fmt.Printf("changelist %s, published %s\n\n", h.Name, h.Published.Format(time.RFC1123))
+		printChanges(h.Changelist.List())
+		fmt.Println()
+	}
+}
+
+func tufReplay(cmd *cobra.Command, args []string) {
+	if len(args) < 3 {
+		cmd.Usage()
+		fatalf("must specify a GUN, a changelist and a target GUN")
+	}
+	gun := args[0]
+	name := args[1]
+	targetGUN := args[2]
+
+	history, err := getRepository(gun).ChangelistHistory()
+	if err != nil {
+		fatalf(err.Error())
+	}
+	for _, h := range history {
+		if h.Name != name {
+			continue
+		}
+		replayed, err := getRepository(targetGUN).Replay(h.Changelist)
+		if err != nil {
+			fatalf(err.Error())
+		}
+		fmt.Printf("Added %d changes to %s, they will be sent on its next publish\n", replayed, targetGUN)
+		return
+	}
+	fatalf("no changelist %s in the history of %s", name, gun)
+}
+
+// printChanges lists the changes numbered by their position
+func printChanges(changes []changelist.Change) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tACTION\tROLE\tTYPE\tPATH\tHASHES")
+	for i, c := range changes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i, changeActions[c.Action()], c.Scope(), c.Type(), c.Path(), changeHashes(c))
+	}
+	w.Flush()
+}
+
+func getRepository(gun string) *notaryclient.NotaryRepository {
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	return repo
+}
+
+func getChangelist(gun string) changelist.Changelist {
+	cl, err := getRepository(gun).GetChangelist()
+	if err != nil {
+		fatalf(err.Error())
+	}
+	return cl
+}
+
+// changeHashes returns the hashes of the target added by c, if any
+func changeHashes(c changelist.Change) string {
+	if c.Type() != changelist.TypeTargetsTarget || c.Action() == changelist.ActionDelete {
+		return ""
+	}
+	meta := data.FileMeta{}
+	if err := json.Unmarshal(c.Content(), &meta); err != nil {
+		return ""
+	}
+	var hashes []string
+	for alg, h := range meta.Hashes {
+		hashes = append(hashes, alg+":"+hex.EncodeToString(h))
+	}
+	sort.Strings(hashes)
+	return strings.Join(hashes, " ")
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	notaryclient "github.com/docker/notary/client"
 	"github.com/docker/notary/trustmanager"
 
 	"github.com/spf13/cobra"
@@ -29,6 +30,7 @@ func init() {
 	cmdKeys.AddCommand(cmdKeysTrust)
 	cmdKeys.AddCommand(cmdKeysRemove)
 	cmdKeys.AddCommand(cmdKeysGenerate)
+	cmdKeys.AddCommand(cmdKeysRotate)
 }
 
 var cmdKeysRemove = &cobra.Command{
@@ -52,6 +54,13 @@ var cmdKeysGenerate = &cobra.Command{
 	Run:   keysGenerate,
 }
 
+var cmdKeysRotate = &cobra.Command{
+	Use:   "rotate [ GUN ] [ root|targets|snapshot|timestamp ]",
+	Short: "Rotates a key for a specific GUN.",
+	Long:  "replaces the key of a role in the trust data of a specific Global Unique Name, and publishes the change.",
+	Run:   keysRotate,
+}
+
 // keysRemove deletes Certificates based on hash and Private Keys
 // based on GUNs.
 func keysRemove(cmd *cobra.Command, args []string) {
@@ -214,6 +223,50 @@ func keysGenerate(cmd *cobra.Command, args []string) {
 	// fmt.Println("Generated new keypair with ID: ", fingerprint)
 }
 
+func keysRotate(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		fatalf("must specify a GUN and a role")
+	}
+
+	gun := args[0]
+	role := args[1]
+
+	nRepo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if role != "root" {
+		err = nRepo.RotateKey(role, passphraseRetriever)
+		if err != nil {
+			fatalf(err.Error())
+		}
+		fmt.Printf("Rotated the %s key of %s\n", role, gun)
+		return
+	}
+
+	fmt.Println("Generating a new root key...")
+	passphrase, err := passphraseRetriever()
+	if err != nil {
+		fatalf(err.Error())
+	}
+	rootKeyID, err := nRepo.KeyStoreManager.GenRootKey("ECDSA", passphrase)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	rootCryptoService, err := nRepo.KeyStoreManager.GetRootCryptoService(rootKeyID, passphrase)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = nRepo.RotateRootKey(rootCryptoService, passphraseRetriever)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	fmt.Printf("Rotated the root key of %s, the new root key ID is %s\n", gun, rootKeyID)
+}
+
 func printCert(cert *x509.Certificate) {
 	timeDifference := cert.NotAfter.Sub(time.Now())
 	keyID, err := trustmanager.FingerprintCert(cert)
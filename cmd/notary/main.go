@@ -57,6 +57,7 @@ func init() {
 
 	// Set up the defaults for our config
 	viper.SetDefault("baseTrustDir", path.Join(homeDir, path.Dir(configPath)))
+	viper.SetDefault("changelist", "file")
 
 	// Get the final value for the CA directory
 	finalTrustDir := path.Join(viper.GetString("baseTrustDir"), trustDir)
@@ -101,21 +102,38 @@ func main() {
 	}
 
 	NotaryCmd.AddCommand(cmdKeys)
+	NotaryCmd.AddCommand(cmdRoot)
 	NotaryCmd.AddCommand(cmdTufInit)
+	cmdTufInit.Flags().BoolVarP(&serverManagedSnapshot, "server-managed-snapshot", "", false, "Lets the notary-server hold the snapshot key and sign the snapshot for every publish.")
 	NotaryCmd.AddCommand(cmdTufList)
 	cmdTufList.Flags().BoolVarP(&rawOutput, "raw", "", false, "Instructs notary list to output a nonpretty printed version of the targets list. Useful if you need to parse the list.")
 	NotaryCmd.AddCommand(cmdTufAdd)
 	NotaryCmd.AddCommand(cmdTufRemove)
 	NotaryCmd.AddCommand(cmdTufPublish)
 	cmdTufPublish.Flags().StringVarP(&remoteTrustServer, "remote", "r", "", "Remote trust server location")
+	NotaryCmd.AddCommand(cmdTufStatus)
+	NotaryCmd.AddCommand(cmdTufReset)
+	cmdTufReset.Flags().IntVarP(&resetNumber, "number", "n", -1, "Discards only the change with this number in notary status.")
+	NotaryCmd.AddCommand(cmdTufHistory)
+	NotaryCmd.AddCommand(cmdTufReplay)
 	NotaryCmd.AddCommand(cmdTufLookup)
 	cmdTufLookup.Flags().BoolVarP(&rawOutput, "raw", "", false, "Instructs notary lookup to output a nonpretty printed version of the targets list. Useful if you need to parse the list.")
 	cmdTufLookup.Flags().StringVarP(&remoteTrustServer, "remote", "r", "", "Remote trust server location")
 	NotaryCmd.AddCommand(cmdVerify)
+	addVerificationFlags(cmdTufList, cmdTufLookup, cmdVerify)
 
 	NotaryCmd.Execute()
 }
 
+// addVerificationFlags adds the flags controlling how the trust data is
+// verified to the commands reading targets from the remote trust server
+func addVerificationFlags(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.Flags().BoolVarP(&offline, "offline", "", false, "Verifies against the locally cached trust data if the remote trust server can't be reached or fails to process the request (5xx).")
+		cmd.Flags().BoolVarP(&trustOnFirstUse, "tofu", "", false, "Trusts and pins the root certificate of a trusted collection that has no trusted certificate yet, and follows rotations of the pinned certificate.")
+	}
+}
+
 func fatalf(format string, args ...interface{}) {
 	fmt.Printf("* fatal: "+format+"\n", args...)
 	os.Exit(1)
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	notaryclient "github.com/docker/notary/client"
+	"github.com/docker/notary/keystoremanager"
+	"github.com/endophage/gotuf/data"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const unsignedRootFile string = "root.json"
+
+var cmdRoot = &cobra.Command{
+	Use:   "root",
+	Short: "Signs root metadata offline.",
+	Long:  "signs the root metadata of trusted collections on a machine holding the root keys, without exposing them to the machine publishing the collection.",
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdRootExport)
+	cmdRoot.AddCommand(cmdRootSign)
+	cmdRoot.AddCommand(cmdRootImport)
+}
+
+var cmdRootExport = &cobra.Command{
+	Use:   "export-unsigned [ GUN ] <file>",
+	Short: "Exports the root metadata that needs to be signed.",
+	Long:  "writes the root metadata of the trusted collection identified by the Globally Unique Name, with its pending changes applied, to a file to be signed with the root keys. The file defaults to " + unsignedRootFile + ".",
+	Run:   rootExport,
+}
+
+var cmdRootSign = &cobra.Command{
+	Use:   "sign [ file ] <key ID>...",
+	Short: "Signs exported root metadata.",
+	Long:  "signs the root metadata in the file with each of the root keys it lists that are held locally, along with any extra root keys given by ID, such as keys being removed from the collection. The remote trusted server isn't contacted.",
+	Run:   rootSign,
+}
+
+var cmdRootImport = &cobra.Command{
+	Use:   "import-signature [ GUN ] [ file ]",
+	Short: "Publishes signed root metadata.",
+	Long:  "publishes the signed root metadata in the file to the trusted collection identified by the Globally Unique Name, once it carries enough valid signatures.",
+	Run:   rootImport,
+}
+
+func rootExport(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		fatalf("must specify a GUN")
+	}
+
+	gun := args[0]
+	filename := unsignedRootFile
+	if len(args) > 1 {
+		filename = args[1]
+	}
+
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	root, roles, err := repo.ExportUnsignedRoot()
+	if err != nil {
+		fatalf(err.Error())
+	}
+	writeRoot(filename, root)
+
+	fmt.Printf("Wrote the root of %s to %s. It must be signed by:\n", gun, filename)
+	for _, role := range roles {
+		fmt.Printf("  %d of %s\n", role.Threshold, strings.Join(role.KeyIDs, ", "))
+	}
+}
+
+func rootSign(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		fatalf("must specify a root file")
+	}
+
+	filename := args[0]
+	root := readRoot(filename)
+
+	keyStoreManager, err := keystoremanager.NewKeyStoreManager(viper.GetString("baseTrustDir"))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	signedBy, err := notaryclient.SignRoot(keyStoreManager, root, passphraseRetriever, args[1:]...)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	writeRoot(filename, root)
+
+	for _, keyID := range signedBy {
+		fmt.Printf("Signed %s with root key %s\n", filename, keyID)
+	}
+}
+
+func rootImport(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		fatalf("must specify a GUN and a root file")
+	}
+
+	gun := args[0]
+	root := readRoot(args[1])
+
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = repo.ImportRootSignatures(root)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	fmt.Printf("Published the signed root of %s\n", gun)
+}
+
+func readRoot(filename string) *data.Signed {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fatalf("could not read %s: %v", filename, err)
+	}
+	root := &data.Signed{}
+	if err := json.Unmarshal(b, root); err != nil {
+		fatalf("could not parse %s: %v", filename, err)
+	}
+	return root
+}
+
+func writeRoot(filename string, root *data.Signed) {
+	b, err := json.Marshal(root)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fatalf("could not write %s: %v", filename, err)
+	}
+}
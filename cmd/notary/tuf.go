@@ -23,6 +23,12 @@ const hardcodedBaseURL = "https://notary-server:4443"
 
 var remoteTrustServer string
 
+var serverManagedSnapshot bool
+
+var offline bool
+
+var trustOnFirstUse bool
+
 var cmdTufList = &cobra.Command{
 	Use:   "list [ GUN ]",
 	Short: "Lists targets for a trusted collection.",
@@ -82,7 +88,7 @@ func tufAdd(cmd *cobra.Command, args []string) {
 	targetName := args[1]
 	targetPath := args[2]
 
-	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
@@ -106,7 +112,7 @@ func tufInit(cmd *cobra.Command, args []string) {
 
 	gun := args[0]
 
-	nRepo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	nRepo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
@@ -139,7 +145,11 @@ func tufInit(cmd *cobra.Command, args []string) {
 		fatalf(err.Error())
 	}
 
-	nRepo.Initialize(rootCryptoService)
+	var serverManagedRoles []string
+	if serverManagedSnapshot {
+		serverManagedRoles = append(serverManagedRoles, "snapshot")
+	}
+	err = nRepo.Initialize(rootCryptoService, serverManagedRoles...)
 	if err != nil {
 		fatalf(err.Error())
 	}
@@ -152,10 +162,11 @@ func tufList(cmd *cobra.Command, args []string) {
 	}
 	gun := args[0]
 
-	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
+	repo.KeyStoreManager.SetTrustOnFirstUse(trustOnFirstUse)
 
 	// Retreive the remote list of signed targets
 	targetList, err := repo.ListTargets()
@@ -177,10 +188,11 @@ func tufLookup(cmd *cobra.Command, args []string) {
 	gun := args[0]
 	targetName := args[1]
 
-	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
+	repo.KeyStoreManager.SetTrustOnFirstUse(trustOnFirstUse)
 
 	// TODO(diogo): Parse Targets and print them
 	target, err := repo.GetTargetByName(targetName)
@@ -201,7 +213,7 @@ func tufPublish(cmd *cobra.Command, args []string) {
 
 	fmt.Println("Pushing changes to ", gun, ".")
 
-	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
@@ -246,10 +258,11 @@ func verify(cmd *cobra.Command, args []string) {
 	//TODO (diogo): This code is copy/pasted from lookup.
 	gun := args[0]
 	targetName := args[1]
-	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport())
+	repo, err := notaryclient.NewNotaryRepository(viper.GetString("baseTrustDir"), gun, hardcodedBaseURL, getInsecureTransport(), repositoryOptions()...)
 	if err != nil {
 		fatalf(err.Error())
 	}
+	repo.KeyStoreManager.SetTrustOnFirstUse(trustOnFirstUse)
 
 	// TODO(diogo): Parse Targets and print them
 	target, err := repo.GetTargetByName(targetName)
@@ -331,3 +344,23 @@ func getInsecureTransport() *http.Transport {
 		},
 	}
 }
+
+// repositoryOptions returns the options for opening trusted collections
+// selected in the configuration and on the command line. Setting
+// "changelist" to "db" stores the pending changes of each collection in a
+// single transactional file, which is safe to modify from concurrent notary
+// runs.
+func repositoryOptions() []notaryclient.RepositoryOption {
+	var opts []notaryclient.RepositoryOption
+	switch backend := viper.GetString("changelist"); backend {
+	case "file":
+	case "db":
+		opts = append(opts, notaryclient.WithChangelist(notaryclient.DBChangelist))
+	default:
+		fatalf("unknown changelist backend: %s", backend)
+	}
+	if offline {
+		opts = append(opts, notaryclient.WithOffline())
+	}
+	return opts
+}
@@ -101,7 +101,7 @@ func (ccs *CryptoService) Sign(keyIDs []string, payload []byte) ([]data.Signatur
 			sig, err = rsaSign(privKey, hash, hashed[:])
 			sigAlgorithm = data.RSAPSSSignature
 		case data.ECDSAKey:
-			sig, err = ecdsaSign(privKey, hashed[:])
+			sig, err = ECDSASign(privKey, hashed[:])
 			sigAlgorithm = data.ECDSASignature
 		}
 		if err != nil {
@@ -142,7 +142,10 @@ func rsaSign(privKey *data.PrivateKey, hash crypto.Hash, hashed []byte) ([]byte,
 	return sig, nil
 }
 
-func ecdsaSign(privKey *data.PrivateKey, hashed []byte) ([]byte, error) {
+// ECDSASign signs a SHA256 digest with an ECDSA private key. The signature is
+// encoded as r and s concatenated, each padded to the size of the curve,
+// which is what gotuf's ECDSA verifier expects.
+func ECDSASign(privKey data.Key, hashed []byte) ([]byte, error) {
 	if privKey.Algorithm() != data.ECDSAKey {
 		return nil, fmt.Errorf("private key type not supported: %s", privKey.Algorithm())
 	}
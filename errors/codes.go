@@ -0,0 +1,155 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode identifies a kind of error returned by the notary-server. Each
+// code is registered with a descriptor giving the value identifying it in
+// JSON error envelopes and a generic description of the error.
+type ErrorCode int
+
+// ErrorDescriptor describes an ErrorCode
+type ErrorDescriptor struct {
+	// Value identifies the code in JSON error envelopes, i.e. "OLD_VERSION"
+	Value string
+	// Message is a generic description of the error
+	Message string
+}
+
+// ErrorCodeUnknown is returned for errors that don't have a more specific
+// code, usually internal server errors.
+const ErrorCodeUnknown ErrorCode = 9999
+
+const (
+	// ErrorCodeUnsupported is returned for requests to unsupported endpoints
+	// or with unsupported methods.
+	ErrorCodeUnsupported ErrorCode = 1000 + iota
+	// ErrorCodeUnauthorized is returned when the client isn't authorized to
+	// perform the request.
+	ErrorCodeUnauthorized
+	// ErrorCodeInvalidParameter is returned when a parameter in the request
+	// URL can't be parsed.
+	ErrorCodeInvalidParameter
+	// ErrorCodeMalformedUpload is returned when uploaded metadata can't be
+	// read or parsed.
+	ErrorCodeMalformedUpload
+	// ErrorCodeInvalidRole is returned when metadata is uploaded for a role
+	// that isn't valid.
+	ErrorCodeInvalidRole
+	// ErrorCodeInvalidUpdate is returned when uploaded metadata fails
+	// validation, i.e. because it isn't signed by the right keys.
+	ErrorCodeInvalidUpdate
+	// ErrorCodeOldVersion is returned when uploaded metadata isn't newer
+	// than the stored metadata, usually because of a concurrent update.
+	ErrorCodeOldVersion
+	// ErrorCodeMetadataNotFound is returned when the requested metadata
+	// doesn't exist.
+	ErrorCodeMetadataNotFound
+	// ErrorCodeNoTimestampKey is returned when the server doesn't hold a
+	// timestamp key for the GUN.
+	ErrorCodeNoTimestampKey
+	// ErrorCodeNoStorage is returned when the server's metadata store isn't
+	// configured.
+	ErrorCodeNoStorage
+	// ErrorCodeNoCryptoService is returned when the server's signing
+	// service isn't configured.
+	ErrorCodeNoCryptoService
+	// ErrorCodeNoSnapshotKey is returned when the server doesn't hold a
+	// snapshot key for the GUN.
+	ErrorCodeNoSnapshotKey
+)
+
+var errorDescriptors = map[ErrorCode]ErrorDescriptor{
+	ErrorCodeUnknown:          {Value: "UNKNOWN", Message: "unknown error"},
+	ErrorCodeUnsupported:      {Value: "UNSUPPORTED", Message: "the operation is unsupported"},
+	ErrorCodeUnauthorized:     {Value: "UNAUTHORIZED", Message: "authentication required"},
+	ErrorCodeInvalidParameter: {Value: "INVALID_PARAMETER", Message: "invalid request parameter"},
+	ErrorCodeMalformedUpload:  {Value: "MALFORMED_UPLOAD", Message: "the uploaded metadata could not be read"},
+	ErrorCodeInvalidRole:      {Value: "INVALID_ROLE", Message: "invalid role"},
+	ErrorCodeInvalidUpdate:    {Value: "INVALID_UPDATE", Message: "the update failed validation"},
+	ErrorCodeOldVersion:       {Value: "OLD_VERSION", Message: "a newer version of the metadata is already stored"},
+	ErrorCodeMetadataNotFound: {Value: "METADATA_NOT_FOUND", Message: "metadata not found"},
+	ErrorCodeNoTimestampKey:   {Value: "NO_TIMESTAMP_KEY", Message: "no timestamp key exists for the repository"},
+	ErrorCodeNoStorage:        {Value: "NO_STORAGE", Message: "the metadata store is not configured"},
+	ErrorCodeNoCryptoService:  {Value: "NO_CRYPTO_SERVICE", Message: "the signing service is not configured"},
+	ErrorCodeNoSnapshotKey:    {Value: "NO_SNAPSHOT_KEY", Message: "no snapshot key exists for the repository"},
+}
+
+// Descriptor returns the descriptor registered for the code, or the one for
+// ErrorCodeUnknown if the code isn't registered.
+func (c ErrorCode) Descriptor() ErrorDescriptor {
+	d, ok := errorDescriptors[c]
+	if !ok {
+		return errorDescriptors[ErrorCodeUnknown]
+	}
+	return d
+}
+
+// String returns the value identifying the code in JSON error envelopes
+func (c ErrorCode) String() string {
+	return c.Descriptor().Value
+}
+
+// MarshalText encodes the code as its value, i.e. "OLD_VERSION"
+func (c ErrorCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a code from its value. Unregistered values, such as
+// codes added by newer servers, are decoded as ErrorCodeUnknown.
+func (c *ErrorCode) UnmarshalText(text []byte) error {
+	*c = ErrorCodeUnknown
+	for code, d := range errorDescriptors {
+		if d.Value == string(text) {
+			*c = code
+			break
+		}
+	}
+	return nil
+}
+
+// Error is an error in a JSON error envelope, using the format of the
+// docker/distribution errcode package.
+type Error struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+// Error implements the error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", strings.ToLower(strings.Replace(e.Code.String(), "_", " ", -1)), e.Message)
+}
+
+// Errors is a JSON error envelope: {"errors": [...]}
+type Errors struct {
+	Errors []Error `json:"errors"`
+}
+
+// ServeJSON writes the error to w as a JSON error envelope, with its HTTP
+// status.
+func ServeJSON(w http.ResponseWriter, he *HTTPError) error {
+	msg := he.Code.Descriptor().Message
+	if he.Err != nil {
+		msg = he.Err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(he.HTTPStatus)
+	return json.NewEncoder(w).Encode(Errors{Errors: []Error{{Code: he.Code, Message: msg}}})
+}
+
+// ParseError decodes the JSON error envelope in the body of a failed
+// notary-server response, returning its first error. Bodies that aren't
+// envelopes, i.e. from older servers or proxies, are returned as the message
+// of an ErrorCodeUnknown error.
+func ParseError(body []byte) Error {
+	envelope := Errors{}
+	if err := json.Unmarshal(body, &envelope); err != nil || len(envelope.Errors) == 0 {
+		return Error{Code: ErrorCodeUnknown, Message: strings.TrimSpace(string(body))}
+	}
+	return envelope.Errors[0]
+}
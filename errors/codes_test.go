@@ -0,0 +1,60 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorCodeJSON(t *testing.T) {
+	for code, d := range errorDescriptors {
+		b, err := json.Marshal(Error{Code: code, Message: d.Message})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := Error{}
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Code != code {
+			t.Fatalf("%s was decoded as %s", d.Value, decoded.Code)
+		}
+	}
+
+	decoded := Error{}
+	if err := json.Unmarshal([]byte(`{"code":"NOT_A_CODE","message":"new"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Code != ErrorCodeUnknown {
+		t.Fatalf("Expected an unknown code, received %s", decoded.Code)
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	ServeJSON(w, &HTTPError{
+		HTTPStatus: http.StatusConflict,
+		Code:       ErrorCodeOldVersion,
+		Err:        fmt.Errorf("root is too old"),
+	})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected 409, received %d", w.Code)
+	}
+
+	err := ParseError(w.Body.Bytes())
+	if err.Code != ErrorCodeOldVersion {
+		t.Fatalf("Expected OLD_VERSION, received %s", err.Code)
+	}
+	if err.Error() != "old version: root is too old" {
+		t.Fatalf("Error did not create expected string: %s", err.Error())
+	}
+}
+
+func TestParseErrorPlainText(t *testing.T) {
+	err := ParseError([]byte("502 Bad Gateway\n"))
+	if err.Code != ErrorCodeUnknown || err.Message != "502 Bad Gateway" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -8,7 +8,7 @@ import (
 // an HTTP status code and returned error object.
 type HTTPError struct {
 	HTTPStatus int
-	Code       int
+	Code       ErrorCode
 	Err        error
 }
 
@@ -7,7 +7,10 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,10 +29,28 @@ type KeyStoreManager struct {
 
 	trustedCAStore          trustmanager.X509Store
 	trustedCertificateStore trustmanager.X509Store
+
+	// rootThresholdsPath holds the threshold of the root whose certificates
+	// are pinned for each GUN
+	rootThresholdsPath string
+
+	trustOnFirstUse bool
+}
+
+// ErrCertChanged is returned by ValidateRoot when the root certificates
+// pinned for a GUN have been replaced by certificates that weren't signed
+// off by the pinned ones
+type ErrCertChanged struct {
+	GUN string
+}
+
+func (err ErrCertChanged) Error() string {
+	return fmt.Sprintf("certificate changed for %s: the new root is not signed by the pinned certificate", err.GUN)
 }
 
 const (
 	trustDir          = "trusted_certificates"
+	rootThresholdsDir = "root_thresholds"
 	privDir           = "private"
 	rootKeysSubdir    = "root_keys"
 	nonRootKeysSubdir = "tuf_keys"
@@ -83,6 +104,7 @@ func NewKeyStoreManager(baseDir string) (*KeyStoreManager, error) {
 		nonRootKeyStore:         nonRootKeyStore,
 		trustedCAStore:          trustedCAStore,
 		trustedCertificateStore: trustedCertificateStore,
+		rootThresholdsPath:      filepath.Join(baseDir, rootThresholdsDir),
 	}, nil
 }
 
@@ -160,11 +182,20 @@ func (km *KeyStoreManager) GetRootCryptoService(rootKeyID, passphrase string) (*
 	return cryptoservice.NewUnlockedCryptoService(privKey, cryptoService), nil
 }
 
+// SetTrustOnFirstUse sets whether ValidateRoot should trust and pin the
+// root certificates of a GUN that it has no certificates for yet, and follow
+// rotations of the pinned certificates.
+func (km *KeyStoreManager) SetTrustOnFirstUse(enabled bool) {
+	km.trustOnFirstUse = enabled
+}
+
 /*
 ValidateRoot iterates over every root key included in the TUF data and
 attempts to validate the certificate by first checking for an exact match on
 the certificate store, and subsequently trying to find a valid chain on the
-trustedCAStore.
+trustedCAStore. The root must be signed by as many of the trusted keys as
+the threshold of its root role, and of the pinned root if it was signed by
+the pinned keys.
 
 When this is being used with a notary repository, the dnsName parameter should
 be the GUN associated with the repository.
@@ -234,11 +265,221 @@ func (km *KeyStoreManager) ValidateRoot(root *data.Signed, dnsName string) error
 		}
 	}
 
-	if len(certs) < 1 {
+	threshold := rootThreshold(rootSigned)
+	pinned := km.pinnedCerts(dnsName)
+	if len(certs) > 0 {
+		if len(pinned) == 0 {
+			return verifyRootSignedByKeys(root, certs, threshold)
+		}
+		// a root signed by the pinned keys must meet the pinned root's
+		// threshold, so fewer of them can't lower it
+		if pinnedThreshold := km.pinnedThreshold(dnsName); pinnedThreshold > threshold {
+			threshold = pinnedThreshold
+		}
+		if err := verifyRootSignedByKeys(root, certs, threshold); err != nil {
+			return err
+		}
+		return km.pinThreshold(dnsName, rootThreshold(rootSigned))
+	}
+
+	// None of the root certificates are trusted directly, fall back to the
+	// certificates pinned for the GUN, if any.
+	rootCerts := gunCerts(rootSigned, dnsName)
+	if len(pinned) > 0 {
+		// certificates pinned explicitly are only replaced by hand, unless
+		// trust on first use was asked for
+		if !km.trustOnFirstUse {
+			return ErrCertChanged{GUN: dnsName}
+		}
+		return km.validateRotatedRoot(root, rootCerts, threshold, pinned, dnsName)
+	}
+	if !km.trustOnFirstUse || len(rootCerts) < 1 {
 		return errors.New("could not validate the path to a trusted root")
 	}
 
-	_, err = signed.VerifyRoot(root, 0, certs, 1)
+	// Trust on first use: the root only has to be signed by its own
+	// certificates, which are pinned along with its threshold so any later
+	// root must be signed by them.
+	if err := verifyRootSignedBy(root, rootCerts, threshold); err != nil {
+		return err
+	}
+	logrus.Debugf("pinning the root certificates of %s on first use", dnsName)
+	if err := km.pinCerts(rootCerts); err != nil {
+		return err
+	}
+	return km.pinThreshold(dnsName, threshold)
+}
+
+// validateRotatedRoot checks a root whose certificates don't match the ones
+// pinned for the GUN was signed by the threshold of the pinned root as well
+// as its own threshold of its own certificates, in which case its
+// certificates replace the pinned ones. It's only used when trusting on
+// first use.
+func (km *KeyStoreManager) validateRotatedRoot(root *data.Signed, rootCerts map[string]*x509.Certificate, threshold int, pinned []*x509.Certificate, gun string) error {
+	pinnedCerts := make(map[string]*x509.Certificate)
+	for _, cert := range pinned {
+		pinnedCerts[certToKey(cert).ID()] = cert
+	}
+	if err := verifyRootSignedBy(root, pinnedCerts, km.pinnedThreshold(gun)); err != nil {
+		return ErrCertChanged{GUN: gun}
+	}
+	if err := verifyRootSignedBy(root, rootCerts, threshold); err != nil {
+		return err
+	}
+
+	logrus.Debugf("replacing the pinned root certificates of %s", gun)
+	return km.replacePinnedCerts(rootCerts, threshold, gun)
+}
+
+// PinRoot pins the certificates and the threshold of a root published for
+// the GUN, in place of the ones pinned for it. It's used once the root has
+// been signed and published, so the publisher goes on trusting the root it
+// signed off.
+func (km *KeyStoreManager) PinRoot(root *data.Signed, gun string) error {
+	rootSigned := &data.Root{}
+	if err := json.Unmarshal(root.Signed, rootSigned); err != nil {
+		return err
+	}
+	rootCerts := gunCerts(rootSigned, gun)
+	if len(rootCerts) == 0 {
+		return fmt.Errorf("the root of %s has no certificates for it", gun)
+	}
+	return km.replacePinnedCerts(rootCerts, rootThreshold(rootSigned), gun)
+}
+
+// replacePinnedCerts pins the root certificates and threshold for the GUN,
+// and stops trusting the certificates previously pinned for it
+func (km *KeyStoreManager) replacePinnedCerts(rootCerts map[string]*x509.Certificate, threshold int, gun string) error {
+	pinned := km.pinnedCerts(gun)
+	if err := km.pinCerts(rootCerts); err != nil {
+		return err
+	}
+	if err := km.pinThreshold(gun, threshold); err != nil {
+		return err
+	}
+	for _, cert := range pinned {
+		if _, ok := rootCerts[certToKey(cert).ID()]; !ok {
+			if err := km.trustedCertificateStore.RemoveCert(cert); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pinnedCerts returns the certificates trusted for the GUN
+func (km *KeyStoreManager) pinnedCerts(gun string) []*x509.Certificate {
+	var pinned []*x509.Certificate
+	for _, cert := range km.trustedCertificateStore.GetCertificates() {
+		if cert.Subject.CommonName == gun {
+			pinned = append(pinned, cert)
+		}
+	}
+	return pinned
+}
+
+// pinCerts adds the certs to the trusted certificates, under the directory
+// for their GUN
+func (km *KeyStoreManager) pinCerts(certs map[string]*x509.Certificate) error {
+	for _, cert := range certs {
+		id, err := trustmanager.FingerprintCert(cert)
+		if err != nil {
+			return err
+		}
+		if _, err := km.trustedCertificateStore.GetCertificateByKeyID(id); err == nil {
+			continue
+		}
+		if err := km.trustedCertificateStore.AddCert(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinnedThreshold returns the threshold of the root whose certificates are
+// pinned for the GUN. Certificates pinned before thresholds were recorded
+// only had to sign with a single key.
+func (km *KeyStoreManager) pinnedThreshold(gun string) int {
+	raw, err := ioutil.ReadFile(filepath.Join(km.rootThresholdsPath, gun))
+	if err != nil {
+		return 1
+	}
+	threshold, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil || threshold < 1 {
+		logrus.Warnf("invalid root threshold recorded for %s: %q", gun, raw)
+		return 1
+	}
+	return threshold
+}
+
+// pinThreshold records the threshold of the root whose certificates are
+// pinned for the GUN
+func (km *KeyStoreManager) pinThreshold(gun string, threshold int) error {
+	path := filepath.Join(km.rootThresholdsPath, gun)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(threshold)), 0644)
+}
+
+// rootThreshold returns the number of signatures the root requires from its
+// own keys
+func rootThreshold(root *data.Root) int {
+	role, ok := root.Roles["root"]
+	if !ok || role.Threshold < 1 {
+		return 1
+	}
+	return role.Threshold
+}
+
+// gunCerts returns the leaf certificates of the root keys for the GUN,
+// keyed by the ID of the TUF key containing them
+func gunCerts(root *data.Root, gun string) map[string]*x509.Certificate {
+	certs := make(map[string]*x509.Certificate)
+	for _, keyID := range root.Roles["root"].KeyIDs {
+		key, ok := root.Keys[keyID]
+		if !ok {
+			continue
+		}
+		cert, err := trustmanager.LoadCertFromPEM([]byte(key.Public()))
+		if err != nil || cert.Subject.CommonName != gun {
+			continue
+		}
+		certs[keyID] = cert
+	}
+	return certs
+}
+
+// certToKey returns the TUF key for a root certificate
+func certToKey(cert *x509.Certificate) *data.PublicKey {
+	algorithm := data.RSAx509Key
+	if cert.PublicKeyAlgorithm == x509.ECDSA {
+		algorithm = data.ECDSAx509Key
+	}
+	return data.NewPublicKey(algorithm, trustmanager.CertToPEM(cert))
+}
+
+// verifyRootSignedBy checks the root carries valid signatures from at least
+// threshold of the certs, ignoring signatures from any other keys
+func verifyRootSignedBy(root *data.Signed, certs map[string]*x509.Certificate, threshold int) error {
+	keys := make(map[string]*data.PublicKey)
+	for _, cert := range certs {
+		key := certToKey(cert)
+		keys[key.ID()] = key
+	}
+	return verifyRootSignedByKeys(root, keys, threshold)
+}
 
+// verifyRootSignedByKeys checks the root carries valid signatures from at
+// least threshold of the keys. Signatures from other keys, such as the
+// previous root keys during a rotation, are ignored.
+func verifyRootSignedByKeys(root *data.Signed, keys map[string]*data.PublicKey, threshold int) error {
+	filtered := &data.Signed{Signed: root.Signed}
+	for _, sig := range root.Signatures {
+		if _, ok := keys[sig.KeyID]; ok {
+			filtered.Signatures = append(filtered.Signatures, sig)
+		}
+	}
+	_, err := signed.VerifyRoot(filtered, 0, keys, threshold)
 	return err
 }
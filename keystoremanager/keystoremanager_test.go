@@ -0,0 +1,157 @@
+package keystoremanager_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/notary/keystoremanager"
+	"github.com/docker/notary/trustmanager"
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGUN = "docker.com/notary"
+
+// rootCert is a root certificate for testGUN, along with the key manager
+// holding its private key
+type rootCert struct {
+	km  *keystoremanager.KeyStoreManager
+	key *data.PublicKey
+}
+
+func newRootCert(t *testing.T, km *keystoremanager.KeyStoreManager) rootCert {
+	rootKeyID, err := km.GenRootKey(data.ECDSAKey.String(), "passphrase")
+	assert.NoError(t, err, "error generating root key: %s", err)
+	cs, err := km.GetRootCryptoService(rootKeyID, "passphrase")
+	assert.NoError(t, err, "error retrieving root key: %s", err)
+	cert, err := cs.GenerateCertificate(testGUN)
+	assert.NoError(t, err, "error generating root certificate: %s", err)
+	key := data.NewPublicKey(data.ECDSAx509Key, trustmanager.CertToPEM(cert))
+	assert.NoError(t, km.RootKeyStore().Link(cs.ID(), key.ID()))
+	return rootCert{km: km, key: key}
+}
+
+// signedRoot returns a root trusting the keys of certs, signed by signers
+func signedRoot(t *testing.T, certs []rootCert, signers ...rootCert) *data.Signed {
+	return signedThresholdRoot(t, 1, certs, signers...)
+}
+
+// signedThresholdRoot returns a root requiring threshold signatures from the
+// keys of certs, signed by signers
+func signedThresholdRoot(t *testing.T, threshold int, certs []rootCert, signers ...rootCert) *data.Signed {
+	keys := make(map[string]*data.PublicKey)
+	var keyIDs []string
+	for _, c := range certs {
+		keys[c.key.ID()] = c.key
+		keyIDs = append(keyIDs, c.key.ID())
+	}
+	role, err := data.NewRole("root", threshold, keyIDs, nil, nil)
+	assert.NoError(t, err)
+	root, err := data.NewRoot(keys, map[string]*data.RootRole{"root": &role.RootRole}, false)
+	assert.NoError(t, err)
+	s, err := root.ToSigned()
+	assert.NoError(t, err)
+	for _, c := range signers {
+		cs, err := c.km.GetRootCryptoService(c.key.ID(), "passphrase")
+		assert.NoError(t, err, "error retrieving root key: %s", err)
+		assert.NoError(t, signed.Sign(cs.CryptoService, s, c.key))
+	}
+	return s
+}
+
+func newKeyStoreManager(t *testing.T) (*keystoremanager.KeyStoreManager, func()) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err, "failed to create a temporary directory: %s", err)
+	km, err := keystoremanager.NewKeyStoreManager(tempBaseDir)
+	assert.NoError(t, err)
+	return km, func() { os.RemoveAll(tempBaseDir) }
+}
+
+func TestValidateRootTrustOnFirstUse(t *testing.T) {
+	km, cleanup := newKeyStoreManager(t)
+	defer cleanup()
+	cert := newRootCert(t, km)
+	root := signedRoot(t, []rootCert{cert}, cert)
+
+	assert.Error(t, km.ValidateRoot(root, testGUN), "trusted an unknown root")
+	assert.Len(t, km.TrustedCertificateStore().GetCertificates(), 0)
+
+	km.SetTrustOnFirstUse(true)
+	assert.NoError(t, km.ValidateRoot(root, testGUN))
+	assert.Len(t, km.TrustedCertificateStore().GetCertificates(), 1)
+	assert.Error(t, km.ValidateRoot(root, "docker.com/other"), "trusted a root for another GUN")
+}
+
+func TestValidateRotatedRoot(t *testing.T) {
+	km, cleanup := newKeyStoreManager(t)
+	defer cleanup()
+	km.SetTrustOnFirstUse(true)
+	oldCert := newRootCert(t, km)
+	assert.NoError(t, km.ValidateRoot(signedRoot(t, []rootCert{oldCert}, oldCert), testGUN))
+
+	// the new root must be signed by the pinned certificate and its own
+	newCert := newRootCert(t, km)
+	err := km.ValidateRoot(signedRoot(t, []rootCert{newCert}, newCert), testGUN)
+	assert.IsType(t, keystoremanager.ErrCertChanged{}, err)
+	err = km.ValidateRoot(signedRoot(t, []rootCert{newCert}, oldCert), testGUN)
+	assert.Error(t, err, "trusted a root not signed by its own certificate")
+
+	rotated := signedRoot(t, []rootCert{newCert}, oldCert, newCert)
+	assert.NoError(t, km.ValidateRoot(rotated, testGUN))
+	pinned := km.TrustedCertificateStore().GetCertificates()
+	assert.Len(t, pinned, 1)
+	assert.Equal(t, trustmanager.CertToPEM(pinned[0]), newCert.key.Public(), "the new certificate wasn't pinned")
+
+	// the old certificate is no longer trusted
+	err = km.ValidateRoot(signedRoot(t, []rootCert{oldCert}, oldCert), testGUN)
+	assert.IsType(t, keystoremanager.ErrCertChanged{}, err)
+}
+
+// TestValidateRotatedRootWithoutTOFU checks certificates pinned explicitly
+// aren't replaced by a rotation without trust on first use.
+func TestValidateRotatedRootWithoutTOFU(t *testing.T) {
+	km, cleanup := newKeyStoreManager(t)
+	defer cleanup()
+	oldCert := newRootCert(t, km)
+	cert, err := trustmanager.LoadCertFromPEM(oldCert.key.Public())
+	assert.NoError(t, err)
+	km.AddTrustedCert(cert)
+	assert.NoError(t, km.ValidateRoot(signedRoot(t, []rootCert{oldCert}, oldCert), testGUN))
+
+	newCert := newRootCert(t, km)
+	rotated := signedRoot(t, []rootCert{newCert}, oldCert, newCert)
+	err = km.ValidateRoot(rotated, testGUN)
+	assert.IsType(t, keystoremanager.ErrCertChanged{}, err)
+	pinned := km.TrustedCertificateStore().GetCertificates()
+	assert.Len(t, pinned, 1)
+	assert.Equal(t, cert.Raw, pinned[0].Raw, "the pinned certificate was replaced")
+}
+
+// TestValidateRootThreshold checks roots are only trusted when they're
+// signed by the threshold of their root role, and of the pinned root.
+func TestValidateRootThreshold(t *testing.T) {
+	km, cleanup := newKeyStoreManager(t)
+	defer cleanup()
+	km.SetTrustOnFirstUse(true)
+	certs := []rootCert{newRootCert(t, km), newRootCert(t, km), newRootCert(t, km)}
+
+	err := km.ValidateRoot(signedThresholdRoot(t, 2, certs, certs[0]), testGUN)
+	assert.Error(t, err, "trusted a 2 of 3 root signed by a single key")
+	assert.Len(t, km.TrustedCertificateStore().GetCertificates(), 0)
+	assert.NoError(t, km.ValidateRoot(signedThresholdRoot(t, 2, certs, certs[0], certs[1]), testGUN))
+	assert.Len(t, km.TrustedCertificateStore().GetCertificates(), 3)
+
+	// a single pinned key can't lower the threshold
+	err = km.ValidateRoot(signedThresholdRoot(t, 1, certs, certs[0]), testGUN)
+	assert.Error(t, err, "trusted a root lowering the threshold signed by a single key")
+
+	// nor rotate the root certificates
+	newCert := newRootCert(t, km)
+	err = km.ValidateRoot(signedRoot(t, []rootCert{newCert}, certs[0], newCert), testGUN)
+	assert.IsType(t, keystoremanager.ErrCertChanged{}, err)
+	assert.NoError(t, km.ValidateRoot(signedRoot(t, []rootCert{newCert}, certs[0], certs[1], newCert), testGUN))
+	pinned := km.TrustedCertificateStore().GetCertificates()
+	assert.Len(t, pinned, 1)
+}
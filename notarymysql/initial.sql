@@ -4,15 +4,43 @@ CREATE TABLE `tuf_files` (
 	`gun` varchar(255) NOT NULL,
 	`role` varchar(255) NOT NULL,
 	`version` int(11) NOT NULL,
+	`sha256` char(64) NOT NULL,
 	`data` longblob NOT NULL,
 	PRIMARY KEY (`id`),
-	UNIQUE KEY `gun` (`gun`,`role`,`version`)
+	UNIQUE KEY `gun` (`gun`,`role`,`version`),
+	INDEX `gun_role_sha256` (`gun`,`role`,`sha256`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
-DROP TABLE IF EXISTS `timestamp_keys`;
-CREATE TABLE `timestamp_keys` (
+DROP TABLE IF EXISTS `tuf_keys`;
+CREATE TABLE `tuf_keys` (
+	`id` int(11) NOT NULL AUTO_INCREMENT,
 	`gun` varchar(255) NOT NULL,
-	`cipher` int(11) NOT NULL,
+	`role` varchar(255) NOT NULL,
+	`cipher` varchar(30) NOT NULL,
+	`public` blob NOT NULL,
+	PRIMARY KEY (`id`),
+	UNIQUE KEY `gun_role` (`gun`,`role`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `tuf_pending_keys`;
+CREATE TABLE `tuf_pending_keys` (
+	`id` int(11) NOT NULL AUTO_INCREMENT,
+	`gun` varchar(255) NOT NULL,
+	`role` varchar(255) NOT NULL,
+	`cipher` varchar(30) NOT NULL,
 	`public` blob NOT NULL,
-	PRIMARY KEY (`gun`)
+	PRIMARY KEY (`id`),
+	UNIQUE KEY `gun_role` (`gun`,`role`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `changefeed`;
+CREATE TABLE `changefeed` (
+	`id` int(11) NOT NULL AUTO_INCREMENT,
+	`created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	`gun` varchar(255) NOT NULL,
+	`role` varchar(255) NOT NULL,
+	`version` int(11) NOT NULL,
+	`user` varchar(255) NOT NULL,
+	PRIMARY KEY (`id`),
+	INDEX `gun_id` (`gun`,`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
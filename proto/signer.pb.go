@@ -12,7 +12,11 @@ It has these top-level messages:
 	KeyInfo
 	KeyID
 	Algorithm
+	CreateKeyRequest
+	KeyMetadata
 	PublicKey
+	ListKeysRequest
+	KeyList
 	Signature
 	SignatureRequest
 	Void
@@ -75,10 +79,36 @@ func (m *Algorithm) Reset()         { *m = Algorithm{} }
 func (m *Algorithm) String() string { return proto1.CompactTextString(m) }
 func (*Algorithm) ProtoMessage()    {}
 
+// CreateKeyRequest specifies the algorithm of a new key, and the GUN and role it is created for if known.
+// The algorithm is the first field so older clients can send an Algorithm instead.
+type CreateKeyRequest struct {
+	Algorithm string `protobuf:"bytes,1,opt,name=algorithm" json:"algorithm,omitempty"`
+	Gun       string `protobuf:"bytes,2,opt,name=gun" json:"gun,omitempty"`
+	Role      string `protobuf:"bytes,3,opt,name=role" json:"role,omitempty"`
+}
+
+func (m *CreateKeyRequest) Reset()         { *m = CreateKeyRequest{} }
+func (m *CreateKeyRequest) String() string { return proto1.CompactTextString(m) }
+func (*CreateKeyRequest) ProtoMessage()    {}
+
+// KeyMetadata holds the GUN and role a key was created for, and when it was created and last used to sign,
+// in seconds since the Unix epoch. Unknown values are empty.
+type KeyMetadata struct {
+	Gun        string `protobuf:"bytes,1,opt,name=gun" json:"gun,omitempty"`
+	Role       string `protobuf:"bytes,2,opt,name=role" json:"role,omitempty"`
+	CreatedAt  int64  `protobuf:"varint,3,opt,name=createdAt" json:"createdAt,omitempty"`
+	LastUsedAt int64  `protobuf:"varint,4,opt,name=lastUsedAt" json:"lastUsedAt,omitempty"`
+}
+
+func (m *KeyMetadata) Reset()         { *m = KeyMetadata{} }
+func (m *KeyMetadata) String() string { return proto1.CompactTextString(m) }
+func (*KeyMetadata) ProtoMessage()    {}
+
 // PublicKey has a KeyInfo that is used to reference the key, and opaque bytes of a publicKey
 type PublicKey struct {
-	KeyInfo   *KeyInfo `protobuf:"bytes,1,opt,name=keyInfo" json:"keyInfo,omitempty"`
-	PublicKey []byte   `protobuf:"bytes,2,opt,name=publicKey,proto3" json:"publicKey,omitempty"`
+	KeyInfo   *KeyInfo     `protobuf:"bytes,1,opt,name=keyInfo" json:"keyInfo,omitempty"`
+	PublicKey []byte       `protobuf:"bytes,2,opt,name=publicKey,proto3" json:"publicKey,omitempty"`
+	Metadata  *KeyMetadata `protobuf:"bytes,3,opt,name=metadata" json:"metadata,omitempty"`
 }
 
 func (m *PublicKey) Reset()         { *m = PublicKey{} }
@@ -92,6 +122,44 @@ func (m *PublicKey) GetKeyInfo() *KeyInfo {
 	return nil
 }
 
+func (m *PublicKey) GetMetadata() *KeyMetadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+// ListKeysRequest filters the keys to list by GUN, role and algorithm, empty filters match all the keys.
+// pageToken is the nextPageToken of the previous page, and pageSize the maximum number of keys to return.
+type ListKeysRequest struct {
+	Gun       string `protobuf:"bytes,1,opt,name=gun" json:"gun,omitempty"`
+	Role      string `protobuf:"bytes,2,opt,name=role" json:"role,omitempty"`
+	Algorithm string `protobuf:"bytes,3,opt,name=algorithm" json:"algorithm,omitempty"`
+	PageSize  int32  `protobuf:"varint,4,opt,name=pageSize" json:"pageSize,omitempty"`
+	PageToken string `protobuf:"bytes,5,opt,name=pageToken" json:"pageToken,omitempty"`
+}
+
+func (m *ListKeysRequest) Reset()         { *m = ListKeysRequest{} }
+func (m *ListKeysRequest) String() string { return proto1.CompactTextString(m) }
+func (*ListKeysRequest) ProtoMessage()    {}
+
+// KeyList holds a page of keys, and the token of the next page if there is one
+type KeyList struct {
+	Keys          []*PublicKey `protobuf:"bytes,1,rep,name=keys" json:"keys,omitempty"`
+	NextPageToken string       `protobuf:"bytes,2,opt,name=nextPageToken" json:"nextPageToken,omitempty"`
+}
+
+func (m *KeyList) Reset()         { *m = KeyList{} }
+func (m *KeyList) String() string { return proto1.CompactTextString(m) }
+func (*KeyList) ProtoMessage()    {}
+
+func (m *KeyList) GetKeys() []*PublicKey {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
 // Signature specifies a KeyInfo that was used for signing and signed content
 type Signature struct {
 	KeyInfo *KeyInfo `protobuf:"bytes,1,opt,name=keyInfo" json:"keyInfo,omitempty"`
@@ -138,11 +206,13 @@ func (*Void) ProtoMessage()    {}
 
 type KeyManagementClient interface {
 	// CreateKey creates as asymmetric key pair and returns the PublicKey
-	CreateKey(ctx context.Context, in *Algorithm, opts ...grpc.CallOption) (*PublicKey, error)
+	CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*PublicKey, error)
 	// DeleteKey deletes the key associated with a KeyID
 	DeleteKey(ctx context.Context, in *KeyID, opts ...grpc.CallOption) (*Void, error)
-	// GetKeyInfo returns the PublicKey associated with a KeyID
+	// GetKeyInfo returns the PublicKey associated with a KeyID, with its metadata
 	GetKeyInfo(ctx context.Context, in *KeyID, opts ...grpc.CallOption) (*PublicKey, error)
+	// ListKeys returns a page of the keys matching a ListKeysRequest, sorted by KeyID
+	ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeyList, error)
 }
 
 type keyManagementClient struct {
@@ -153,7 +223,7 @@ func NewKeyManagementClient(cc *grpc.ClientConn) KeyManagementClient {
 	return &keyManagementClient{cc}
 }
 
-func (c *keyManagementClient) CreateKey(ctx context.Context, in *Algorithm, opts ...grpc.CallOption) (*PublicKey, error) {
+func (c *keyManagementClient) CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*PublicKey, error) {
 	out := new(PublicKey)
 	err := grpc.Invoke(ctx, "/proto.KeyManagement/CreateKey", in, out, c.cc, opts...)
 	if err != nil {
@@ -180,15 +250,26 @@ func (c *keyManagementClient) GetKeyInfo(ctx context.Context, in *KeyID, opts ..
 	return out, nil
 }
 
+func (c *keyManagementClient) ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeyList, error) {
+	out := new(KeyList)
+	err := grpc.Invoke(ctx, "/proto.KeyManagement/ListKeys", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for KeyManagement service
 
 type KeyManagementServer interface {
 	// CreateKey creates as asymmetric key pair and returns the PublicKey
-	CreateKey(context.Context, *Algorithm) (*PublicKey, error)
+	CreateKey(context.Context, *CreateKeyRequest) (*PublicKey, error)
 	// DeleteKey deletes the key associated with a KeyID
 	DeleteKey(context.Context, *KeyID) (*Void, error)
-	// GetKeyInfo returns the PublicKey associated with a KeyID
+	// GetKeyInfo returns the PublicKey associated with a KeyID, with its metadata
 	GetKeyInfo(context.Context, *KeyID) (*PublicKey, error)
+	// ListKeys returns a page of the keys matching a ListKeysRequest, sorted by KeyID
+	ListKeys(context.Context, *ListKeysRequest) (*KeyList, error)
 }
 
 func RegisterKeyManagementServer(s *grpc.Server, srv KeyManagementServer) {
@@ -196,7 +277,7 @@ func RegisterKeyManagementServer(s *grpc.Server, srv KeyManagementServer) {
 }
 
 func _KeyManagement_CreateKey_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(Algorithm)
+	in := new(CreateKeyRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
@@ -231,6 +312,18 @@ func _KeyManagement_GetKeyInfo_Handler(srv interface{}, ctx context.Context, cod
 	return out, nil
 }
 
+func _KeyManagement_ListKeys_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListKeysRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(KeyManagementServer).ListKeys(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _KeyManagement_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.KeyManagement",
 	HandlerType: (*KeyManagementServer)(nil),
@@ -247,6 +340,10 @@ var _KeyManagement_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetKeyInfo",
 			Handler:    _KeyManagement_GetKeyInfo_Handler,
 		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _KeyManagement_ListKeys_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/docker/notary/errors"
+	"github.com/docker/notary/server/storage"
+)
+
+const (
+	defaultChangesPageSize = 100
+	maxChangesPageSize     = 1000
+)
+
+// changefeedResponse is the body returned by ChangefeedHandler
+type changefeedResponse struct {
+	Count   int              `json:"count"`
+	Records []storage.Change `json:"records"`
+}
+
+// ChangefeedHandler returns the changes published to a GUN, oldest first.
+// The "change_id" query parameter returns only the changes after the given
+// change, and "records" sets the number of changes returned.
+func ChangefeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
+	s := ctx.Value("metaStore")
+	store, ok := s.(storage.MetaStore)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoStorage,
+			Err:        fmt.Errorf("Version store not configured"),
+		}
+	}
+	vars := mux.Vars(r)
+	gun := vars["imageName"]
+
+	changeID, err := queryInt(r, "change_id", 0)
+	if err != nil {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusBadRequest,
+			Code:       errors.ErrorCodeInvalidParameter,
+			Err:        err,
+		}
+	}
+	pageSize, err := queryInt(r, "records", defaultChangesPageSize)
+	if err != nil || pageSize < 1 {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusBadRequest,
+			Code:       errors.ErrorCodeInvalidParameter,
+			Err:        fmt.Errorf("records must be a positive number"),
+		}
+	}
+	if pageSize > maxChangesPageSize {
+		pageSize = maxChangesPageSize
+	}
+
+	changes, err := store.GetChanges(gun, changeID, pageSize)
+	if err != nil {
+		logrus.Errorf("[Notary Server] 500 GET changefeed: %s", gun)
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        err,
+		}
+	}
+
+	out, err := json.Marshal(&changefeedResponse{Count: len(changes), Records: changes})
+	if err != nil {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        fmt.Errorf("Error serializing changes."),
+		}
+	}
+	w.Write(out)
+	return nil
+}
+
+// queryInt parses the query parameter name as an integer, returning def if
+// it isn't set
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	return i, nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/endophage/gotuf/signed"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	"github.com/docker/notary/server/storage"
+	"github.com/docker/notary/utils"
+)
+
+func changefeedServer(store storage.MetaStore) *httptest.Server {
+	ctx := context.WithValue(context.Background(), "metaStore", store)
+	hand := utils.RootHandlerFactory(nil, ctx, signed.NewEd25519())
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/changefeed").Handler(hand(ChangefeedHandler, "pull"))
+	return httptest.NewServer(r)
+}
+
+func getChanges(t *testing.T, url string) (int, *changefeedResponse) {
+	res, err := http.Get(url)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+	changes := &changefeedResponse{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(changes))
+	return res.StatusCode, changes
+}
+
+// TestStoreUpdatesRecordsChanges checks published updates are recorded in
+// the changefeed along with the authenticated user
+func TestStoreUpdatesRecordsChanges(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, cs := testRepo(t)
+	ctx := context.WithValue(context.Background(), "auth.user.name", "alice")
+	updates := signUpdates(t, repo)
+	assert.Nil(t, storeUpdates(ctx, store, cs, "gun", updates))
+
+	changes, err := store.GetChanges("gun", 0, 10)
+	assert.NoError(t, err)
+	if !assert.Len(t, changes, len(updates)) {
+		return
+	}
+	for i, c := range changes {
+		assert.Equal(t, "alice", c.User)
+		assert.Equal(t, updates[i].Role, c.Role)
+		assert.Equal(t, updates[i].Version, c.Version)
+	}
+}
+
+func TestChangefeedHandler(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.PublishUpdates("gun", "alice", []storage.MetaUpdate{{Role: "root", Version: 1}, {Role: "targets", Version: 1}})
+	store.PublishUpdates("other", "bob", []storage.MetaUpdate{{Role: "targets", Version: 1}})
+	store.PublishUpdates("gun", "bob", []storage.MetaUpdate{{Role: "targets", Version: 2}})
+
+	ts := changefeedServer(store)
+	defer ts.Close()
+
+	status, changes := getChanges(t, ts.URL+"/v2/gun/_trust/changefeed")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, changes.Count)
+	assert.Equal(t, "bob", changes.Records[2].User)
+
+	// page through the changes
+	status, changes = getChanges(t, ts.URL+"/v2/gun/_trust/changefeed?records=2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, changes.Count)
+	last := changes.Records[1].ID
+	status, changes = getChanges(t, ts.URL+"/v2/gun/_trust/changefeed?records=2&change_id="+strconv.Itoa(last))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, changes.Count)
+	assert.Equal(t, 2, changes.Records[0].Version)
+
+	status, _ = getChanges(t, ts.URL+"/v2/gun/_trust/changefeed?records=0")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = getChanges(t, ts.URL+"/v2/gun/_trust/changefeed?change_id=latest")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
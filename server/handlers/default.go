@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +19,8 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/docker/notary/errors"
+	"github.com/docker/notary/server/keys"
+	"github.com/docker/notary/server/snapshot"
 	"github.com/docker/notary/server/storage"
 	"github.com/docker/notary/server/timestamp"
 )
@@ -31,7 +36,7 @@ func MainHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *e
 		//w.WriteHeader(http.StatusNotFound)
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusNotFound,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnsupported,
 			Err:        nil,
 		}
 	}
@@ -46,7 +51,7 @@ func AtomicUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 	if s == nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store is nil"),
 		}
 	}
@@ -54,17 +59,26 @@ func AtomicUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
+	cryptoServiceVal := ctx.Value("cryptoService")
+	cryptoService, ok := cryptoServiceVal.(signed.CryptoService)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoCryptoService,
+			Err:        fmt.Errorf("CryptoService not configured"),
+		}
+	}
 	vars := mux.Vars(r)
 	gun := vars["imageName"]
 	reader, err := r.MultipartReader()
 	if err != nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusBadRequest,
-			Code:       9999,
+			Code:       errors.ErrorCodeMalformedUpload,
 			Err:        err,
 		}
 	}
@@ -74,17 +88,17 @@ func AtomicUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 		if err == io.EOF {
 			break
 		}
-		role := strings.TrimSuffix(part.FileName(), ".json")
+		role := strings.TrimSuffix(partFileName(part), ".json")
 		if role == "" {
 			return &errors.HTTPError{
 				HTTPStatus: http.StatusBadRequest,
-				Code:       9999,
+				Code:       errors.ErrorCodeMalformedUpload,
 				Err:        fmt.Errorf("Empty filename provided. No updates performed"),
 			}
 		} else if !data.ValidRole(role) {
 			return &errors.HTTPError{
 				HTTPStatus: http.StatusBadRequest,
-				Code:       9999,
+				Code:       errors.ErrorCodeInvalidRole,
 				Err:        fmt.Errorf("Invalid role: %s. No updates performed", role),
 			}
 		}
@@ -96,7 +110,7 @@ func AtomicUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 		if err != nil {
 			return &errors.HTTPError{
 				HTTPStatus: http.StatusBadRequest,
-				Code:       9999,
+				Code:       errors.ErrorCodeMalformedUpload,
 				Err:        err,
 			}
 		}
@@ -107,17 +121,80 @@ func AtomicUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 			Data:    inBuf.Bytes(),
 		})
 	}
-	err = store.UpdateMany(gun, updates)
+	return storeUpdates(ctx, store, cryptoService, gun, updates)
+}
+
+// storeUpdates validates and stores the updates for gun. If the updates
+// don't include a snapshot and the server holds the snapshot key, the server
+// signs a snapshot for them once the metadata signed by the publisher has
+// been validated. Updates that aren't newer than the stored metadata are
+// rejected with 409 Conflict, so the client can fetch the latest metadata
+// and apply its changes on top of it.
+func storeUpdates(ctx context.Context, store storage.MetaStore, cryptoService signed.CryptoService, gun string, updates []storage.MetaUpdate) *errors.HTTPError {
+	roles, kdb, err := validateRoles(gun, updates, store)
 	if err != nil {
+		return validationError(err)
+	}
+	if _, ok := roles[data.ValidRoles["snapshot"]]; !ok {
+		update, err := snapshot.GenerateSnapshot(gun, updates, store, cryptoService)
+		if err == nil {
+			updates = append(updates, *update)
+			roles[update.Role] = *update
+		} else if _, ok := err.(*storage.ErrNoKey); !ok {
+			return &errors.HTTPError{
+				HTTPStatus: http.StatusInternalServerError,
+				Code:       errors.ErrorCodeUnknown,
+				Err:        err,
+			}
+		}
+		// without a snapshot key, the snapshot is managed by the publisher
+		// and validateSnapshot rejects the missing snapshot
+	}
+	if err := validateSnapshot(gun, roles, store, kdb); err != nil {
+		return validationError(err)
+	}
+	// the updates are recorded in the changefeed along with the name of the
+	// authenticated user that published them
+	user, _ := ctx.Value("auth.user.name").(string)
+	if err := store.PublishUpdates(gun, user, updates); err != nil {
+		status, code := http.StatusInternalServerError, errors.ErrorCodeUnknown
+		if _, ok := err.(*storage.ErrOldVersion); ok {
+			// another update was stored since the updates were validated
+			status, code = http.StatusConflict, errors.ErrorCodeOldVersion
+		}
 		return &errors.HTTPError{
-			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			HTTPStatus: status,
+			Code:       code,
 			Err:        err,
 		}
 	}
 	return nil
 }
 
+// validationError returns the HTTP error for updates failing validation
+func validationError(err error) *errors.HTTPError {
+	status, code := http.StatusBadRequest, errors.ErrorCodeInvalidUpdate
+	if _, ok := err.(ErrConflict); ok {
+		status, code = http.StatusConflict, errors.ErrorCodeOldVersion
+	}
+	return &errors.HTTPError{
+		HTTPStatus: status,
+		Code:       code,
+		Err:        err,
+	}
+}
+
+// partFileName returns the unmodified filename of a multipart part.
+// multipart.Part.FileName strips any directories, which would lose the
+// parents of delegated roles (i.e. "targets/releases.json").
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
 // UpdateHandler adds the provided json data for the role and GUN specified in the URL
 func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
 	defer r.Body.Close()
@@ -125,7 +202,7 @@ func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if s == nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store is nil"),
 		}
 	}
@@ -133,10 +210,19 @@ func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
+	cryptoServiceVal := ctx.Value("cryptoService")
+	cryptoService, ok := cryptoServiceVal.(signed.CryptoService)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoCryptoService,
+			Err:        fmt.Errorf("CryptoService not configured"),
+		}
+	}
 	vars := mux.Vars(r)
 	gun := vars["imageName"]
 	tufRole := vars["tufRole"]
@@ -144,7 +230,7 @@ func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusBadRequest,
-			Code:       9999,
+			Code:       errors.ErrorCodeMalformedUpload,
 			Err:        err,
 		}
 	}
@@ -153,7 +239,7 @@ func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusBadRequest,
-			Code:       9999,
+			Code:       errors.ErrorCodeMalformedUpload,
 			Err:        err,
 		}
 	}
@@ -162,44 +248,58 @@ func UpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		Version: meta.Signed.Version,
 		Data:    input,
 	}
-	err = store.UpdateCurrent(gun, update)
-	if err != nil {
-		return &errors.HTTPError{
-			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
-			Err:        err,
-		}
-	}
-	return nil
+	return storeUpdates(ctx, store, cryptoService, gun, []storage.MetaUpdate{update})
 }
 
-// GetHandler returns the json for a specified role and GUN.
+// GetHandler returns the json for a specified role and GUN. A specific
+// version of the role may be requested by version number or by the hex
+// encoded sha256 checksum of its content, otherwise the current version is
+// returned.
 func GetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
 	s := ctx.Value("metaStore")
 	store, ok := s.(storage.MetaStore)
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
 	vars := mux.Vars(r)
 	gun := vars["imageName"]
 	tufRole := vars["tufRole"]
-	out, err := store.GetCurrent(gun, tufRole)
+	var (
+		out []byte
+		err error
+	)
+	switch {
+	case vars["checksum"] != "":
+		out, err = store.GetChecksum(gun, tufRole, vars["checksum"])
+	case vars["version"] != "":
+		version, convErr := strconv.Atoi(vars["version"])
+		if convErr != nil {
+			return &errors.HTTPError{
+				HTTPStatus: http.StatusBadRequest,
+				Code:       errors.ErrorCodeInvalidParameter,
+				Err:        convErr,
+			}
+		}
+		out, err = store.GetVersion(gun, tufRole, version)
+	default:
+		out, err = store.GetCurrent(gun, tufRole)
+	}
 	if err != nil {
 		if _, ok := err.(*storage.ErrNotFound); ok {
 			return &errors.HTTPError{
 				HTTPStatus: http.StatusNotFound,
-				Code:       9999,
+				Code:       errors.ErrorCodeMetadataNotFound,
 				Err:        err,
 			}
 		}
 		logrus.Errorf("[Notary Server] 500 GET repository: %s, role: %s", gun, tufRole)
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnknown,
 			Err:        err,
 		}
 	}
@@ -207,7 +307,7 @@ func GetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *er
 		logrus.Errorf("[Notary Server] 404 GET repository: %s, role: %s", gun, tufRole)
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusNotFound,
-			Code:       9999,
+			Code:       errors.ErrorCodeMetadataNotFound,
 			Err:        err,
 		}
 	}
@@ -223,7 +323,7 @@ func DeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
@@ -234,7 +334,7 @@ func DeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		logrus.Errorf("[Notary Server] 500 DELETE repository: %s", gun)
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnknown,
 			Err:        err,
 		}
 	}
@@ -248,7 +348,7 @@ func GetTimestampHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
@@ -257,7 +357,7 @@ func GetTimestampHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoCryptoService,
 			Err:        fmt.Errorf("CryptoService not configured"),
 		}
 	}
@@ -270,13 +370,13 @@ func GetTimestampHandler(ctx context.Context, w http.ResponseWriter, r *http.Req
 		if _, ok := err.(*storage.ErrNoKey); ok {
 			return &errors.HTTPError{
 				HTTPStatus: http.StatusNotFound,
-				Code:       9999,
+				Code:       errors.ErrorCodeNoTimestampKey,
 				Err:        err,
 			}
 		}
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnknown,
 			Err:        err,
 		}
 	}
@@ -294,7 +394,142 @@ func GetTimestampKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoStorage,
+			Err:        fmt.Errorf("Version store not configured"),
+		}
+	}
+	c := ctx.Value("cryptoService")
+	crypto, ok := c.(signed.CryptoService)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoCryptoService,
+			Err:        fmt.Errorf("CryptoService not configured"),
+		}
+	}
+
+	vars := mux.Vars(r)
+	gun := vars["imageName"]
+
+	key, err := keys.GetOrCreateKey(gun, "timestamp", store, crypto, data.ED25519Key)
+	if err != nil {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        err,
+		}
+	}
+
+	out, err := json.Marshal(key)
+	if err != nil {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        fmt.Errorf("Error serializing key."),
+		}
+	}
+	w.Write(out)
+	return nil
+}
+
+// RotateTimestampKeyHandler creates a new timestamp key for a GUN, returning
+// its public key. The server keeps signing timestamps with the current key
+// until a root listing the new key has been published.
+func RotateTimestampKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
+	s := ctx.Value("metaStore")
+	store, ok := s.(storage.MetaStore)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoStorage,
+			Err:        fmt.Errorf("Version store not configured"),
+		}
+	}
+	c := ctx.Value("cryptoService")
+	crypto, ok := c.(signed.CryptoService)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoCryptoService,
+			Err:        fmt.Errorf("CryptoService not configured"),
+		}
+	}
+
+	vars := mux.Vars(r)
+	gun := vars["imageName"]
+
+	key, err := timestamp.RotateTimestampKey(gun, store, crypto, data.ED25519Key)
+	if err != nil {
+		if _, ok := err.(*storage.ErrNoKey); ok {
+			return &errors.HTTPError{
+				HTTPStatus: http.StatusNotFound,
+				Code:       errors.ErrorCodeNoTimestampKey,
+				Err:        err,
+			}
+		}
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        err,
+		}
+	}
+
+	out, err := json.Marshal(key)
+	if err != nil {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        fmt.Errorf("Error serializing key."),
+		}
+	}
+	w.Write(out)
+	return nil
+}
+
+// GetSnapshotKeyHandler returns the snapshot public key the server holds for
+// a GUN. The key is created by CreateSnapshotKeyHandler.
+func GetSnapshotKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
+	s := ctx.Value("metaStore")
+	store, ok := s.(storage.MetaStore)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoStorage,
+			Err:        fmt.Errorf("Version store not configured"),
+		}
+	}
+
+	vars := mux.Vars(r)
+	gun := vars["imageName"]
+
+	algorithm, public, err := store.GetKey(gun, "snapshot")
+	if err != nil {
+		if _, ok := err.(*storage.ErrNoKey); ok {
+			return &errors.HTTPError{
+				HTTPStatus: http.StatusNotFound,
+				Code:       errors.ErrorCodeNoSnapshotKey,
+				Err:        err,
+			}
+		}
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeUnknown,
+			Err:        err,
+		}
+	}
+	return writeKey(w, data.NewTUFKey(algorithm, public, nil))
+}
+
+// CreateSnapshotKeyHandler returns a snapshot public key, creating a new
+// key-pair if it doesn't yet exist. Once the key has been created, the server
+// will generate the snapshot for any update that doesn't include one.
+func CreateSnapshotKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
+	s := ctx.Value("metaStore")
+	store, ok := s.(storage.MetaStore)
+	if !ok {
+		return &errors.HTTPError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       errors.ErrorCodeNoStorage,
 			Err:        fmt.Errorf("Version store not configured"),
 		}
 	}
@@ -303,7 +538,7 @@ func GetTimestampKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.
 	if !ok {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeNoCryptoService,
 			Err:        fmt.Errorf("CryptoService not configured"),
 		}
 	}
@@ -311,20 +546,24 @@ func GetTimestampKeyHandler(ctx context.Context, w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	gun := vars["imageName"]
 
-	key, err := timestamp.GetOrCreateTimestampKey(gun, store, crypto, data.ED25519Key)
+	key, err := keys.GetOrCreateKey(gun, "snapshot", store, crypto, data.ED25519Key)
 	if err != nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnknown,
 			Err:        err,
 		}
 	}
+	return writeKey(w, key)
+}
 
+// writeKey writes a public key as JSON
+func writeKey(w http.ResponseWriter, key *data.TUFKey) *errors.HTTPError {
 	out, err := json.Marshal(key)
 	if err != nil {
 		return &errors.HTTPError{
 			HTTPStatus: http.StatusInternalServerError,
-			Code:       9999,
+			Code:       errors.ErrorCodeUnknown,
 			Err:        fmt.Errorf("Error serializing key."),
 		}
 	}
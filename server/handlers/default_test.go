@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 
+	"github.com/endophage/gotuf/data"
 	"github.com/endophage/gotuf/signed"
 
+	"github.com/docker/notary/server/storage"
 	"github.com/docker/notary/utils"
 )
 
@@ -38,3 +46,86 @@ func TestMainHandlerNotGet(t *testing.T) {
 		t.Fatalf("Expected 404, received %d", res.StatusCode)
 	}
 }
+
+// keyHandlersServer serves the snapshot key and single role update handlers
+// backed by store
+func keyHandlersServer(store storage.MetaStore, crypto signed.CryptoService) *httptest.Server {
+	ctx := context.WithValue(context.Background(), "metaStore", store)
+	hand := utils.RootHandlerFactory(nil, ctx, crypto)
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/snapshot.key").Handler(hand(GetSnapshotKeyHandler))
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/snapshot.key").Handler(hand(CreateSnapshotKeyHandler))
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole}.json").Handler(hand(UpdateHandler))
+	return httptest.NewServer(r)
+}
+
+func requestKey(t *testing.T, method, url string) (int, *data.TUFKey) {
+	req, err := http.NewRequest(method, url, nil)
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	key := &data.TUFKey{}
+	assert.NoError(t, json.Unmarshal(body, key))
+	return res.StatusCode, key
+}
+
+func TestSnapshotKeyHandlers(t *testing.T) {
+	store := storage.NewMemStorage()
+	ts := keyHandlersServer(store, signed.NewEd25519())
+	defer ts.Close()
+	url := ts.URL + "/v2/gun/_trust/tuf/snapshot.key"
+
+	// fetching the key doesn't create it
+	status, _ := requestKey(t, "GET", url)
+	assert.Equal(t, http.StatusNotFound, status)
+	_, _, err := store.GetKey("gun", "snapshot")
+	assert.IsType(t, &storage.ErrNoKey{}, err)
+
+	status, created := requestKey(t, "POST", url)
+	assert.Equal(t, http.StatusOK, status)
+	status, again := requestKey(t, "POST", url)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, created.ID(), again.ID(), "the snapshot key was replaced")
+	status, fetched := requestKey(t, "GET", url)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, created.ID(), fetched.ID())
+}
+
+// failingSigner is a CryptoService that can't sign anything
+type failingSigner struct {
+	signed.CryptoService
+}
+
+func (failingSigner) Sign(keyIDs []string, toSign []byte) ([]data.Signature, error) {
+	return nil, fmt.Errorf("signing is unavailable")
+}
+
+// TestUpdateValidatedBeforeSnapshot checks metadata is validated before the
+// server signs a snapshot for it, so invalid metadata is rejected as a bad
+// request rather than failing to be signed.
+func TestUpdateValidatedBeforeSnapshot(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := failingSigner{signed.NewEd25519()}
+	key, err := crypto.Create("snapshot", data.ED25519Key)
+	assert.NoError(t, err)
+	assert.NoError(t, store.SetKey("gun", "snapshot", key.Algorithm(), key.Public()))
+	ts := keyHandlersServer(store, crypto)
+	defer ts.Close()
+
+	// the targets can't be validated without a root
+	repo, _ := testRepo(t)
+	s, err := repo.SignTargets("targets", data.DefaultExpires("targets"), nil)
+	assert.NoError(t, err)
+	body, err := json.Marshal(s)
+	assert.NoError(t, err)
+	res, err := http.Post(ts.URL+"/v2/gun/_trust/tuf/targets.json", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/keys"
+	"github.com/endophage/gotuf/signed"
+
+	"github.com/docker/notary/server/storage"
+)
+
+// ErrValidation represents a general validation error
+type ErrValidation struct {
+	Msg string
+}
+
+func (err ErrValidation) Error() string {
+	return fmt.Sprintf("An error occurred during validation: %s", err.Msg)
+}
+
+// ErrBadHierarchy represents a missing or inconsistent role in the
+// collection of metadata being updated
+type ErrBadHierarchy struct {
+	Msg string
+}
+
+func (err ErrBadHierarchy) Error() string {
+	return fmt.Sprintf("Hierarchy of updates is incorrect: %s", err.Msg)
+}
+
+// ErrBadRoot represents a failure validating the root
+type ErrBadRoot struct {
+	Msg string
+}
+
+func (err ErrBadRoot) Error() string {
+	return fmt.Sprintf("The root being updated is invalid: %s", err.Msg)
+}
+
+// ErrBadTargets represents a failure to validate a targets role, which may
+// be the base targets role or one of its delegations
+type ErrBadTargets struct {
+	Role string
+	Msg  string
+}
+
+func (err ErrBadTargets) Error() string {
+	return fmt.Sprintf("The %s being updated is invalid: %s", err.Role, err.Msg)
+}
+
+// ErrBadSnapshot represents a failure to validate the snapshot
+type ErrBadSnapshot struct {
+	Msg string
+}
+
+func (err ErrBadSnapshot) Error() string {
+	return fmt.Sprintf("The snapshot being updated is invalid: %s", err.Msg)
+}
+
+// ErrConflict represents an update that isn't newer than the stored
+// metadata for a role, usually because another update for the same role was
+// stored since the metadata being updated was retrieved
+type ErrConflict struct {
+	Role string
+	Msg  string
+}
+
+func (err ErrConflict) Error() string {
+	return fmt.Sprintf("The %s being updated conflicts with a newer version: %s", err.Role, err.Msg)
+}
+
+// validateRoles checks that the updates for gun, but for the snapshot, are
+// correctly signed by the keys authorised by the root (the root being pushed
+// if there is one, otherwise the stored root) and are newer than the stored
+// versions. The snapshot may be generated by the server once the others are
+// known to be valid, and is checked by validateSnapshot. It returns the
+// updates indexed by role, and the keys of the roles to validate the snapshot
+// with. Any error returned describes the role that failed.
+func validateRoles(gun string, updates []storage.MetaUpdate, store storage.MetaStore) (map[string]storage.MetaUpdate, *keys.KeyDB, error) {
+	roles := make(map[string]storage.MetaUpdate)
+	for _, u := range updates {
+		if _, ok := roles[u.Role]; ok {
+			return nil, nil, ErrValidation{Msg: fmt.Sprintf("%s was provided more than once", u.Role)}
+		}
+		roles[u.Role] = u
+	}
+	if _, ok := roles[data.ValidRoles["timestamp"]]; ok {
+		return nil, nil, ErrValidation{Msg: "the timestamp is managed by the server and can't be updated"}
+	}
+
+	kdb := keys.NewDB()
+	if err := validateRoot(gun, roles, store, kdb); err != nil {
+		return nil, nil, err
+	}
+
+	// Validate parents before the roles they delegate to, so the delegated
+	// keys are known by the time they're needed.
+	targetsRoles := make([]string, 0, len(roles))
+	for role := range roles {
+		if role == data.ValidRoles["targets"] || strings.HasPrefix(role, data.ValidRoles["targets"]+"/") {
+			targetsRoles = append(targetsRoles, role)
+		}
+	}
+	sort.Sort(byDepth(targetsRoles))
+	loaded := make(map[string]*data.SignedTargets)
+	for _, role := range targetsRoles {
+		if err := validateTargets(gun, role, roles, store, kdb, loaded); err != nil {
+			return nil, nil, err
+		}
+	}
+	return roles, kdb, nil
+}
+
+func validateRoot(gun string, roles map[string]storage.MetaUpdate, store storage.MetaStore, kdb *keys.KeyDB) error {
+	rootRole := data.ValidRoles["root"]
+	oldRoot, err := loadSigned(gun, rootRole, store)
+	if err != nil {
+		return err
+	}
+	update, ok := roles[rootRole]
+	if !ok {
+		if oldRoot == nil {
+			return ErrBadHierarchy{Msg: "no root exists, it must be provided with the first update"}
+		}
+		// no new root, the stored one determines the trusted keys
+		if err := loadRoot(oldRoot, kdb); err != nil {
+			return ErrBadRoot{Msg: err.Error()}
+		}
+		return nil
+	}
+
+	newRoot := &data.Signed{}
+	if err := json.Unmarshal(update.Data, newRoot); err != nil {
+		return ErrBadRoot{Msg: err.Error()}
+	}
+	minVersion := 1
+	if oldRoot != nil {
+		// the new root must be signed by the keys the existing root trusts
+		oldKDB := keys.NewDB()
+		if err := loadRoot(oldRoot, oldKDB); err != nil {
+			return ErrBadRoot{Msg: fmt.Sprintf("stored root is invalid: %v", err)}
+		}
+		if err := signed.VerifySignatures(newRoot, rootRole, oldKDB); err != nil {
+			return ErrBadRoot{Msg: fmt.Sprintf("not signed by the current root keys: %v", err)}
+		}
+		minVersion = signedVersion(oldRoot) + 1
+	}
+	if err := loadRoot(newRoot, kdb); err != nil {
+		return ErrBadRoot{Msg: err.Error()}
+	}
+	if err := signed.Verify(newRoot, rootRole, minVersion, kdb); err != nil {
+		if _, ok := err.(signed.ErrLowVersion); ok {
+			return ErrConflict{Role: rootRole, Msg: err.Error()}
+		}
+		return ErrBadRoot{Msg: err.Error()}
+	}
+	return nil
+}
+
+func validateTargets(gun, role string, roles map[string]storage.MetaUpdate, store storage.MetaStore, kdb *keys.KeyDB, loaded map[string]*data.SignedTargets) error {
+	s := &data.Signed{}
+	if err := json.Unmarshal(roles[role].Data, s); err != nil {
+		return ErrBadTargets{Role: role, Msg: err.Error()}
+	}
+
+	var delegation *data.Role
+	if role != data.ValidRoles["targets"] {
+		parentName := role[:strings.LastIndex(role, "/")]
+		parent, err := loadTargets(gun, parentName, store, kdb, loaded)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return ErrBadHierarchy{Msg: fmt.Sprintf("%s is delegated from %s, which doesn't exist", role, parentName)}
+		}
+		for _, r := range parent.Signed.Delegations.Roles {
+			if r.Name == role {
+				delegation = r
+				break
+			}
+		}
+		if delegation == nil {
+			return ErrBadHierarchy{Msg: fmt.Sprintf("%s is not delegated by %s", role, parentName)}
+		}
+	}
+
+	stored, err := loadSigned(gun, role, store)
+	if err != nil {
+		return err
+	}
+	minVersion := 1
+	if stored != nil {
+		minVersion = signedVersion(stored) + 1
+	}
+	if err := signed.Verify(s, role, minVersion, kdb); err != nil {
+		if _, ok := err.(signed.ErrLowVersion); ok {
+			return ErrConflict{Role: role, Msg: err.Error()}
+		}
+		return ErrBadTargets{Role: role, Msg: err.Error()}
+	}
+
+	t, err := data.TargetsFromSigned(s)
+	if err != nil {
+		return ErrBadTargets{Role: role, Msg: err.Error()}
+	}
+	if delegation != nil {
+		// a delegated role may only sign for the paths delegated to it
+		for path := range t.Signed.Targets {
+			if !delegation.CheckPaths(path) && !delegation.CheckPrefixes(pathHex(path)) {
+				return ErrBadTargets{Role: role, Msg: fmt.Sprintf("%s is outside of the delegated paths", path)}
+			}
+		}
+		// nor delegate more than was delegated to it
+		for _, r := range t.Signed.Delegations.Roles {
+			for _, path := range r.Paths {
+				if !delegation.CheckPaths(path) {
+					return ErrBadTargets{Role: role, Msg: fmt.Sprintf("%s delegates %s, which is outside of the delegated paths", r.Name, path)}
+				}
+			}
+			for _, prefix := range r.PathHashPrefixes {
+				if !delegation.CheckPrefixes(prefix) {
+					return ErrBadTargets{Role: role, Msg: fmt.Sprintf("%s delegates the hash prefix %s, which is outside of the delegated prefixes", r.Name, prefix)}
+				}
+			}
+		}
+	}
+	addDelegations(t, kdb)
+	loaded[role] = t
+	return nil
+}
+
+// loadTargets returns the targets role that will be current once the update
+// is applied, registering its delegations in kdb. Updated roles must have
+// been validated already. It returns nil if the role doesn't exist.
+func loadTargets(gun, role string, store storage.MetaStore, kdb *keys.KeyDB, loaded map[string]*data.SignedTargets) (*data.SignedTargets, error) {
+	if t, ok := loaded[role]; ok {
+		return t, nil
+	}
+	s, err := loadSigned(gun, role, store)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	t, err := data.TargetsFromSigned(s)
+	if err != nil {
+		return nil, ErrBadTargets{Role: role, Msg: fmt.Sprintf("stored metadata is invalid: %v", err)}
+	}
+	addDelegations(t, kdb)
+	loaded[role] = t
+	return t, nil
+}
+
+func validateSnapshot(gun string, roles map[string]storage.MetaUpdate, store storage.MetaStore, kdb *keys.KeyDB) error {
+	snapshotRole := data.ValidRoles["snapshot"]
+	update, ok := roles[snapshotRole]
+	if !ok {
+		if len(roles) > 0 {
+			return ErrBadHierarchy{Msg: "a snapshot must be provided along with the root and targets"}
+		}
+		return nil
+	}
+
+	s := &data.Signed{}
+	if err := json.Unmarshal(update.Data, s); err != nil {
+		return ErrBadSnapshot{Msg: err.Error()}
+	}
+	stored, err := loadSigned(gun, snapshotRole, store)
+	if err != nil {
+		return err
+	}
+	minVersion := 1
+	if stored != nil {
+		minVersion = signedVersion(stored) + 1
+	}
+	if err := signed.Verify(s, snapshotRole, minVersion, kdb); err != nil {
+		if _, ok := err.(signed.ErrLowVersion); ok {
+			return ErrConflict{Role: snapshotRole, Msg: err.Error()}
+		}
+		return ErrBadSnapshot{Msg: err.Error()}
+	}
+	snapshot, err := data.SnapshotFromSigned(s)
+	if err != nil {
+		return ErrBadSnapshot{Msg: err.Error()}
+	}
+
+	for role := range roles {
+		if role == snapshotRole {
+			continue
+		}
+		if _, ok := snapshot.Signed.Meta[role]; !ok {
+			return ErrBadSnapshot{Msg: fmt.Sprintf("%s is being updated but isn't referenced by the snapshot", role)}
+		}
+	}
+	for role, meta := range snapshot.Signed.Meta {
+		var current []byte
+		if u, ok := roles[role]; ok {
+			current = u.Data
+		} else {
+			current, err = store.GetCurrent(gun, role)
+			if _, ok := err.(*storage.ErrNotFound); ok {
+				return ErrBadSnapshot{Msg: fmt.Sprintf("%s is referenced by the snapshot but doesn't exist", role)}
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := checkFileMeta(current, meta); err != nil {
+			return ErrBadSnapshot{Msg: fmt.Sprintf("%s doesn't match the snapshot: %v", role, err)}
+		}
+	}
+	return nil
+}
+
+// checkFileMeta checks that b has the length and the hashes recorded in meta
+func checkFileMeta(b []byte, meta data.FileMeta) error {
+	if int64(len(b)) != meta.Length {
+		return fmt.Errorf("expected length %d, got %d", meta.Length, len(b))
+	}
+	actual, err := data.NewFileMeta(bytes.NewReader(b), "sha256")
+	if err != nil {
+		return err
+	}
+	expected, ok := meta.Hashes["sha256"]
+	if !ok {
+		return fmt.Errorf("no sha256 hash recorded")
+	}
+	if !bytes.Equal(expected, actual.Hashes["sha256"]) {
+		return fmt.Errorf("sha256 hashes don't match")
+	}
+	return nil
+}
+
+// loadSigned returns the stored metadata for role, or nil if there is none
+func loadSigned(gun, role string, store storage.MetaStore) (*data.Signed, error) {
+	b, err := store.GetCurrent(gun, role)
+	if _, ok := err.(*storage.ErrNotFound); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &data.Signed{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, ErrValidation{Msg: fmt.Sprintf("stored %s is invalid: %v", role, err)}
+	}
+	return s, nil
+}
+
+// loadRoot adds the keys and base roles defined by the root to kdb
+func loadRoot(s *data.Signed, kdb *keys.KeyDB) error {
+	root, err := data.RootFromSigned(s)
+	if err != nil {
+		return err
+	}
+	for _, k := range root.Signed.Keys {
+		kdb.AddKey(k)
+	}
+	for _, name := range data.ValidRoles {
+		r, ok := root.Signed.Roles[name]
+		if !ok {
+			return fmt.Errorf("the %s role is missing", name)
+		}
+		role, err := data.NewRole(name, r.Threshold, r.KeyIDs, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := kdb.AddRole(role); err != nil {
+			return fmt.Errorf("the %s role is invalid: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// addDelegations adds the keys and roles delegated by t to kdb
+func addDelegations(t *data.SignedTargets, kdb *keys.KeyDB) {
+	for _, k := range t.Signed.Delegations.Keys {
+		kdb.AddKey(k)
+	}
+	for _, r := range t.Signed.Delegations.Roles {
+		kdb.AddRole(r)
+	}
+}
+
+func signedVersion(s *data.Signed) int {
+	meta := struct {
+		Version int `json:"version"`
+	}{}
+	json.Unmarshal(s.Signed, &meta)
+	return meta.Version
+}
+
+func pathHex(path string) string {
+	digest := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(digest[:])
+}
+
+// byDepth sorts role names so parents come before the roles they delegate to
+type byDepth []string
+
+func (r byDepth) Len() int      { return len(r) }
+func (r byDepth) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byDepth) Less(i, j int) bool {
+	di, dj := strings.Count(r[i], "/"), strings.Count(r[j], "/")
+	if di != dj {
+		return di < dj
+	}
+	return r[i] < r[j]
+}
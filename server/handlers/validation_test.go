@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/endophage/gotuf"
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/keys"
+	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	"github.com/docker/notary/server/storage"
+)
+
+// testRepo creates a new TUF repo with a single ed25519 key for each role
+func testRepo(t *testing.T) (*tuf.TufRepo, *signed.Ed25519) {
+	cs := signed.NewEd25519()
+	kdb := keys.NewDB()
+	for _, role := range data.ValidRoles {
+		key, err := cs.Create(role, data.ED25519Key)
+		assert.NoError(t, err)
+		kdb.AddKey(key)
+		r, err := data.NewRole(role, 1, []string{key.ID()}, nil, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, kdb.AddRole(r))
+	}
+	repo := tuf.NewTufRepo(kdb, cs)
+	assert.NoError(t, repo.InitRepo(false))
+	// InitRepo captures everything in the snapshot, mark the roles as
+	// modified so they're all included in the first update
+	repo.Root.Dirty = true
+	repo.Targets["targets"].Dirty = true
+	return repo, cs
+}
+
+// signUpdates signs all the modified roles in repo, recording them in the
+// snapshot, and returns them as MetaUpdates
+func signUpdates(t *testing.T, repo *tuf.TufRepo) []storage.MetaUpdate {
+	var updates []storage.MetaUpdate
+	if repo.Root.Dirty {
+		s, err := repo.SignRoot(data.DefaultExpires("root"), nil)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.UpdateSnapshot("root", s))
+		repo.Root.Dirty = false
+		updates = append(updates, metaUpdate(t, "root", s))
+	}
+	for role, targets := range repo.Targets {
+		if !targets.Dirty {
+			continue
+		}
+		s, err := repo.SignTargets(role, data.DefaultExpires("targets"), nil)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.UpdateSnapshot(role, s))
+		targets.Dirty = false
+		updates = append(updates, metaUpdate(t, role, s))
+	}
+	s, err := repo.SignSnapshot(data.DefaultExpires("snapshot"), nil)
+	assert.NoError(t, err)
+	return append(updates, metaUpdate(t, "snapshot", s))
+}
+
+func metaUpdate(t *testing.T, role string, s *data.Signed) storage.MetaUpdate {
+	b, err := json.Marshal(s)
+	assert.NoError(t, err)
+	return storage.MetaUpdate{Role: role, Version: signedVersion(s), Data: b}
+}
+
+// validateUpdate stores the updates for testGUN the way the update handlers
+// do, returning the validation error if they're rejected
+func validateUpdate(t *testing.T, updates []storage.MetaUpdate, store storage.MetaStore) error {
+	if err := storeUpdates(context.Background(), store, signed.NewEd25519(), "testGUN", updates); err != nil {
+		return err.Err
+	}
+	return nil
+}
+
+func TestValidateEmptyNew(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	updates := signUpdates(t, repo)
+
+	err := validateUpdate(t, updates, store)
+	assert.NoError(t, err)
+}
+
+func TestValidateNoNewRoot(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	assert.NoError(t, store.UpdateMany("testGUN", signUpdates(t, repo)))
+
+	_, err := repo.AddTargets("targets", data.Files{"latest": data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": []byte{1}}}})
+	assert.NoError(t, err)
+	updates := signUpdates(t, repo)
+	assert.Len(t, updates, 2)
+
+	err = validateUpdate(t, updates, store)
+	assert.NoError(t, err)
+}
+
+func TestValidateNoRoot(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	repo.Root.Dirty = false
+	updates := signUpdates(t, repo)
+
+	err := validateUpdate(t, updates, store)
+	assert.IsType(t, ErrBadHierarchy{}, err)
+}
+
+func TestValidateNoSnapshot(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	updates := signUpdates(t, repo)
+
+	err := validateUpdate(t, updates[:len(updates)-1], store)
+	assert.IsType(t, ErrBadHierarchy{}, err)
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	updates := signUpdates(t, repo)
+	s, err := repo.SignTimestamp(data.DefaultExpires("timestamp"), nil)
+	assert.NoError(t, err)
+	updates = append(updates, metaUpdate(t, "timestamp", s))
+
+	err = validateUpdate(t, updates, store)
+	assert.IsType(t, ErrValidation{}, err)
+}
+
+func TestValidateOldVersion(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	updates := signUpdates(t, repo)
+	assert.NoError(t, store.UpdateMany("testGUN", updates))
+
+	err := validateUpdate(t, updates, store)
+	assert.IsType(t, ErrConflict{}, err)
+	assert.Equal(t, "root", err.(ErrConflict).Role)
+}
+
+func TestValidateSnapshotMismatch(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	updates := signUpdates(t, repo)
+
+	// push a targets other than the one referenced by the snapshot
+	repo.Targets["targets"].Dirty = true
+	s, err := repo.SignTargets("targets", data.DefaultExpires("targets"), nil)
+	assert.NoError(t, err)
+	for i, u := range updates {
+		if u.Role == "targets" {
+			updates[i] = metaUpdate(t, "targets", s)
+		}
+	}
+
+	err = validateUpdate(t, updates, store)
+	assert.IsType(t, ErrBadSnapshot{}, err)
+}
+
+func TestValidateTargetsWrongKey(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, _ := testRepo(t)
+	assert.NoError(t, store.UpdateMany("testGUN", signUpdates(t, repo)))
+
+	repo.Targets["targets"].Dirty = true
+	updates := signUpdates(t, repo)
+
+	// replace the signature on targets with one from an untrusted key
+	cs := signed.NewEd25519()
+	key, err := cs.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	for i, u := range updates {
+		if u.Role == "targets" {
+			s := &data.Signed{}
+			assert.NoError(t, json.Unmarshal(u.Data, s))
+			s.Signatures = nil
+			assert.NoError(t, signed.Sign(cs, s, key))
+			updates[i] = metaUpdate(t, "targets", s)
+		}
+	}
+
+	err = validateUpdate(t, updates, store)
+	assert.IsType(t, ErrBadTargets{}, err)
+}
+
+func TestValidateRootRotation(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, cs := testRepo(t)
+	assert.NoError(t, store.UpdateMany("testGUN", signUpdates(t, repo)))
+
+	oldRootID := repo.Root.Signed.Roles["root"].KeyIDs[0]
+	oldRootKey := repo.Root.Signed.Keys[oldRootID]
+	newRootKey, err := cs.Create("root", data.ED25519Key)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddBaseKeys("root", newRootKey))
+	assert.NoError(t, repo.RemoveBaseKeys("root", oldRootID))
+
+	// only signed by the new root key
+	err = validateUpdate(t, signUpdates(t, repo), store)
+	assert.IsType(t, ErrBadRoot{}, err, "rotation must be signed by the old root key")
+
+	// signed by both the old and new root keys
+	repo.Root.Dirty = true
+	root, err := repo.SignRoot(data.DefaultExpires("root"), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, signed.Sign(cs, root, oldRootKey))
+	assert.NoError(t, repo.UpdateSnapshot("root", root))
+	repo.Root.Dirty = false
+	updates := append(signUpdates(t, repo), metaUpdate(t, "root", root))
+
+	err = validateUpdate(t, updates, store)
+	assert.NoError(t, err)
+}
+
+func TestValidateDelegationPaths(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, cs := testRepo(t)
+	assert.NoError(t, store.UpdateMany("testGUN", signUpdates(t, repo)))
+
+	key, err := cs.Create("targets/releases", data.ED25519Key)
+	assert.NoError(t, err)
+	role, err := data.NewRole("targets/releases", 1, []string{key.ID()}, []string{"release/"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UpdateDelegations(role, []data.Key{key}, ""))
+
+	_, err = repo.AddTargets("targets/releases", data.Files{"release/v1": data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": []byte{1}}}})
+	assert.NoError(t, err)
+	updates := signUpdates(t, repo)
+	err = validateUpdate(t, updates, store)
+	assert.NoError(t, err)
+
+	// force a target outside of the delegated paths
+	repo.Targets["targets/releases"].Signed.Targets["other"] = data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": []byte{1}}}
+	repo.Targets["targets/releases"].Dirty = true
+	err = validateUpdate(t, signUpdates(t, repo), store)
+	assert.IsType(t, ErrBadTargets{}, err)
+}
+
+func TestValidateNestedDelegationPaths(t *testing.T) {
+	store := storage.NewMemStorage()
+	repo, cs := testRepo(t)
+	assert.NoError(t, store.UpdateMany("testGUN", signUpdates(t, repo)))
+
+	key, err := cs.Create("targets/releases", data.ED25519Key)
+	assert.NoError(t, err)
+	role, err := data.NewRole("targets/releases", 1, []string{key.ID()}, []string{"release/"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UpdateDelegations(role, []data.Key{key}, ""))
+	assert.NoError(t, validateUpdate(t, signUpdates(t, repo), store))
+
+	// targets/releases can't delegate paths that weren't delegated to it
+	nested, err := data.NewRole("targets/releases/beta", 1, []string{key.ID()}, []string{"beta/"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UpdateDelegations(nested, []data.Key{key}, ""))
+	err = validateUpdate(t, signUpdates(t, repo), store)
+	assert.IsType(t, ErrBadTargets{}, err)
+	assert.Equal(t, "targets/releases", err.(ErrBadTargets).Role)
+
+	// but it can delegate a subset of them
+	nested.Paths = []string{"release/beta/"}
+	repo.Targets["targets/releases"].Dirty = true
+	repo.Targets["targets/releases/beta"].Dirty = true
+	err = validateUpdate(t, signUpdates(t, repo), store)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,42 @@
+package keys
+
+import (
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+
+	"github.com/docker/notary/server/storage"
+	"github.com/docker/notary/signer"
+)
+
+// GetOrCreateKey returns the key the server holds for a role of the gun. It
+// uses the store to lookup an existing key and the crypto to generate a new
+// one if none is found. It attempts to handle the race condition that may
+// occur if 2 servers try to create the key at the same time by simply
+// querying the store a second time if it receives a conflict when writing.
+func GetOrCreateKey(gun, role string, store storage.MetaStore, crypto signed.CryptoService, fallBackAlgorithm data.KeyAlgorithm) (*data.TUFKey, error) {
+	keyAlgorithm, public, err := store.GetKey(gun, role)
+	if err == nil {
+		return data.NewTUFKey(keyAlgorithm, public, nil), nil
+	}
+
+	if _, ok := err.(*storage.ErrNoKey); ok {
+		key, err := signer.CreateKeyForGUN(crypto, gun, role, fallBackAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		err = store.SetKey(gun, role, key.Algorithm(), key.Public())
+		if err == nil {
+			return &key.TUFKey, nil
+		}
+
+		if _, ok := err.(*storage.ErrKeyExists); ok {
+			keyAlgorithm, public, err = store.GetKey(gun, role)
+			if err != nil {
+				return nil, err
+			}
+			return data.NewTUFKey(keyAlgorithm, public, nil), nil
+		}
+		return nil, err
+	}
+	return nil, err
+}
@@ -0,0 +1,44 @@
+package keys
+
+import (
+	"testing"
+
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/docker/notary/server/storage"
+)
+
+func TestGetOrCreateKey(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := signed.NewEd25519()
+	k, err := GetOrCreateKey("gun", "timestamp", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "Expected nil error")
+	assert.NotNil(t, k, "Key should not be nil")
+
+	k2, err := GetOrCreateKey("gun", "timestamp", store, crypto, data.ED25519Key)
+
+	assert.Nil(t, err, "Expected nil error")
+
+	// trying to get the same key again should return the same value
+	assert.Equal(t, k, k2, "Did not receive same key when attempting to recreate.")
+	assert.NotNil(t, k2, "Key should not be nil")
+
+	// each role has its own key
+	k3, err := GetOrCreateKey("gun", "snapshot", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "Expected nil error")
+	assert.NotEqual(t, k.ID(), k3.ID(), "The snapshot and timestamp keys should differ")
+}
+
+func TestGetOrCreateKeyExists(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := signed.NewEd25519()
+	key, err := crypto.Create("snapshot", data.ED25519Key)
+	assert.Nil(t, err, "Expected nil error")
+	assert.Nil(t, store.SetKey("gun", "snapshot", key.Algorithm(), key.Public()))
+
+	k, err := GetOrCreateKey("gun", "snapshot", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "Expected nil error")
+	assert.Equal(t, key.ID(), k.ID(), "The stored key should be returned")
+}
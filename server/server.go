@@ -72,20 +72,9 @@ func Run(ctx context.Context, addr, tlsCertFile, tlsKeyFile string, trust signed
 			return err
 		}
 	}
-	hand := utils.RootHandlerFactory(ac, ctx, trust)
-
-	r := mux.NewRouter()
-	// TODO (endophage): use correct regexes for image and tag names
-	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/").Handler(hand(handlers.AtomicUpdateHandler, "push", "pull"))
-	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(root|targets|snapshot)}.json").Handler(hand(handlers.GetHandler, "pull"))
-	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/timestamp.json").Handler(hand(handlers.GetTimestampHandler, "pull"))
-	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/timestamp.key").Handler(hand(handlers.GetTimestampKeyHandler, "push", "pull"))
-	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(root|targets|timestamp|snapshot)}.json").Handler(hand(handlers.UpdateHandler, "push", "pull"))
-	r.Methods("DELETE").Path("/v2/{imageName:.*}/_trust/tuf/").Handler(hand(handlers.DeleteHandler, "push", "pull"))
-
 	svr := http.Server{
 		Addr:    addr,
-		Handler: r,
+		Handler: RootHandler(ac, ctx, trust),
 	}
 
 	logrus.Error("[Notary Server] : Starting on ", addr)
@@ -94,3 +83,26 @@ func Run(ctx context.Context, addr, tlsCertFile, tlsKeyFile string, trust signed
 
 	return err
 }
+
+// RootHandler returns the handler that routes all the paths from / for the
+// server.
+func RootHandler(ac auth.AccessController, ctx context.Context, trust signed.CryptoService) http.Handler {
+	hand := utils.RootHandlerFactory(ac, ctx, trust)
+
+	r := mux.NewRouter()
+	// TODO (endophage): use correct regexes for image and tag names
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/").Handler(hand(handlers.AtomicUpdateHandler, "push", "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(?:root|targets(?:/[^/]+)*|timestamp|snapshot)}.{checksum:[a-f0-9]{64}}.json").Handler(hand(handlers.GetHandler, "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(?:root|targets(?:/[^/]+)*|timestamp|snapshot)}.{version:[0-9]+}.json").Handler(hand(handlers.GetHandler, "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(root|targets(?:/[^/]+)*|snapshot)}.json").Handler(hand(handlers.GetHandler, "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/timestamp.json").Handler(hand(handlers.GetTimestampHandler, "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/timestamp.key").Handler(hand(handlers.GetTimestampKeyHandler, "push", "pull"))
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/timestamp.key").Handler(hand(handlers.RotateTimestampKeyHandler, "push", "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/tuf/snapshot.key").Handler(hand(handlers.GetSnapshotKeyHandler, "pull"))
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/snapshot.key").Handler(hand(handlers.CreateSnapshotKeyHandler, "push", "pull"))
+	r.Methods("POST").Path("/v2/{imageName:.*}/_trust/tuf/{tufRole:(root|targets(?:/[^/]+)*|timestamp|snapshot)}.json").Handler(hand(handlers.UpdateHandler, "push", "pull"))
+	r.Methods("DELETE").Path("/v2/{imageName:.*}/_trust/tuf/").Handler(hand(handlers.DeleteHandler, "push", "pull"))
+	r.Methods("GET").Path("/v2/{imageName:.*}/_trust/changefeed").Handler(hand(handlers.ChangefeedHandler, "pull"))
+
+	return r
+}
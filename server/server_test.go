@@ -1,13 +1,21 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	_ "github.com/docker/distribution/registry/auth/silly"
 	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
+
+	"github.com/docker/notary/server/storage"
 )
 
 func TestRunBadAddr(t *testing.T) {
@@ -45,3 +53,42 @@ func TestRunReservedPort(t *testing.T) {
 		t.Fatalf("Received unexpected err: %s", err.Error())
 	}
 }
+
+func TestGetHistoricalVersions(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "targets", Version: 1, Data: []byte("v1")})
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "targets", Version: 2, Data: []byte("v2")})
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "targets/releases", Version: 1, Data: []byte("r1")})
+
+	ctx := context.WithValue(context.Background(), "metaStore", store)
+	ts := httptest.NewServer(RootHandler(nil, ctx, signed.NewEd25519()))
+	defer ts.Close()
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(ts.URL + "/v2/gun/_trust/tuf/" + path)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+	sum := sha256.Sum256([]byte("v1"))
+
+	for path, expected := range map[string]string{
+		"targets.json":            "v2",
+		"targets.1.json":          "v1",
+		"targets.2.json":          "v2",
+		"targets/releases.json":   "r1",
+		"targets/releases.1.json": "r1",
+		"targets." + hex.EncodeToString(sum[:]) + ".json": "v1",
+	} {
+		status, body := get(path)
+		assert.Equal(t, http.StatusOK, status, path)
+		assert.Equal(t, expected, body, path)
+	}
+
+	status, _ := get("targets.3.json")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get("root." + hex.EncodeToString(sum[:]) + ".json")
+	assert.Equal(t, http.StatusNotFound, status)
+}
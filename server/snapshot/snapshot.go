@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+	cjson "github.com/tent/canonical-json-go"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/notary/server/storage"
+)
+
+// GenerateSnapshot creates a new snapshot for the gun, signed with the
+// snapshot key held by the server, that references the metadata in updates
+// along with the current versions of any roles not being updated. It returns
+// a storage.ErrNoKey if the server doesn't hold a snapshot key for the gun,
+// in which case the snapshot must be provided by the publisher. The new
+// snapshot is not saved, it should be validated and stored together with
+// the updates.
+func GenerateSnapshot(gun string, updates []storage.MetaUpdate, store storage.MetaStore, crypto signed.CryptoService) (*storage.MetaUpdate, error) {
+	algorithm, public, err := store.GetKey(gun, "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	key := data.NewPublicKey(algorithm, public)
+
+	sn, err := currentSnapshot(gun, store)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range updates {
+		meta, err := data.NewFileMeta(bytes.NewReader(u.Data), "sha256")
+		if err != nil {
+			return nil, err
+		}
+		sn.Signed.Meta[u.Role] = meta
+	}
+	sn.Signed.Version++
+	sn.Signed.Expires = data.DefaultExpires("snapshot")
+
+	sgndSn, err := cjson.Marshal(sn.Signed)
+	if err != nil {
+		return nil, err
+	}
+	out := &data.Signed{
+		Signatures: []data.Signature{},
+		Signed:     sgndSn,
+	}
+	err = signed.Sign(crypto, out, key)
+	if err != nil {
+		return nil, err
+	}
+	outJSON, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.MetaUpdate{
+		Role:    "snapshot",
+		Version: sn.Signed.Version,
+		Data:    outJSON,
+	}, nil
+}
+
+// currentSnapshot returns the stored snapshot for the gun, or an empty
+// snapshot if none exists yet
+func currentSnapshot(gun string, store storage.MetaStore) (*data.SignedSnapshot, error) {
+	sn := &data.SignedSnapshot{
+		Signed: data.Snapshot{
+			Type: data.TUFTypes["snapshot"],
+			Meta: make(data.Files),
+		},
+	}
+	d, err := store.GetCurrent(gun, "snapshot")
+	if err != nil {
+		if _, ok := err.(*storage.ErrNotFound); ok {
+			return sn, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(d, sn)
+	if err != nil {
+		logrus.Error("Failed to unmarshal existing snapshot")
+		return nil, err
+	}
+	if sn.Signed.Meta == nil {
+		sn.Signed.Meta = make(data.Files)
+	}
+	return sn, nil
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/endophage/gotuf/data"
+	tufkeys "github.com/endophage/gotuf/keys"
+	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/docker/notary/server/keys"
+	"github.com/docker/notary/server/storage"
+)
+
+func TestGenerateSnapshotNoKey(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := signed.NewEd25519()
+
+	_, err := GenerateSnapshot("gun", nil, store, crypto)
+	assert.IsType(t, &storage.ErrNoKey{}, err)
+}
+
+func TestGenerateSnapshot(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := signed.NewEd25519()
+	key, err := keys.GetOrCreateKey("gun", "snapshot", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "GetSnapshotKey errored")
+
+	root := storage.MetaUpdate{Role: "root", Version: 1, Data: []byte("root")}
+	targets := storage.MetaUpdate{Role: "targets", Version: 1, Data: []byte("targets")}
+	update, err := GenerateSnapshot("gun", []storage.MetaUpdate{root, targets}, store, crypto)
+	assert.Nil(t, err, "GenerateSnapshot errored")
+	assert.Equal(t, 1, update.Version)
+	assert.NoError(t, store.UpdateMany("gun", []storage.MetaUpdate{root, targets, *update}))
+
+	// a later update only replaces the roles being updated
+	targets = storage.MetaUpdate{Role: "targets", Version: 2, Data: []byte("new targets")}
+	update, err = GenerateSnapshot("gun", []storage.MetaUpdate{targets}, store, crypto)
+	assert.Nil(t, err, "GenerateSnapshot errored")
+	assert.Equal(t, 2, update.Version)
+
+	s := &data.Signed{}
+	assert.NoError(t, json.Unmarshal(update.Data, s))
+	kdb := tufkeys.NewDB()
+	kdb.AddKey(data.NewPublicKey(key.Algorithm(), key.Public()))
+	role, err := data.NewRole("snapshot", 1, []string{key.ID()}, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, kdb.AddRole(role))
+	assert.NoError(t, signed.Verify(s, "snapshot", 0, kdb))
+
+	sn := &data.Snapshot{}
+	assert.NoError(t, json.Unmarshal(s.Signed, sn))
+	assert.Len(t, sn.Meta, 2)
+	assert.Equal(t, int64(len("root")), sn.Meta["root"].Length)
+	assert.Equal(t, int64(len("new targets")), sn.Meta["targets"].Length)
+}
@@ -2,125 +2,168 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/endophage/gotuf/data"
 	"github.com/go-sql-driver/mysql"
 )
 
-// MySQLStorage implements a versioned store using a relational database.
-// The database table must look like:
-// CREATE TABLE `tuf_files` (
-//   `id` INT AUTO_INCREMENT,
-//   `gun` VARCHAR(255) NOT NULL
-//   `role` VARCHAR(255) NOT NULL
-//   `version` INT
-//   `data` LONGBLOB
-//   PRIMARY KEY (`id`)
-//   UNIQUE INDEX (`gun`, `role`, `version`)
-// ) DEFAULT CHARSET=utf8;
-//
-// CREATE TABLE `timestamp_keys` (
-//   `gun` VARCHAR(255),
-//   `cipher` VARCHAR(30),
-//   `public` BLOB NOT NULL,
-// ) DEFAULT CHARSET=utf8;
-type MySQLStorage struct {
+// SQLStorage implements a versioned store using a relational database,
+// either MySQL or PostgreSQL. The queries are shared, and adapted to the
+// database by its dialect. The tables can be created and upgraded with
+// MigrateSchema, their definitions are the migrations in dialect.go.
+type SQLStorage struct {
 	sql.DB
+	dialect sqlDialect
 }
 
-// NewMySQLStorage is a convenience method to create a MySQLStorage
-func NewMySQLStorage(db *sql.DB) *MySQLStorage {
-	return &MySQLStorage{
-		DB: *db,
+// NewMySQLStorage is a convenience method to create a SQLStorage backed by
+// MySQL
+func NewMySQLStorage(db *sql.DB) *SQLStorage {
+	return newSQLStorage(db, mysqlDialect{})
+}
+
+// NewPostgresStorage is a convenience method to create a SQLStorage backed
+// by PostgreSQL. The driver, github.com/lib/pq, is registered by the binary.
+func NewPostgresStorage(db *sql.DB) *SQLStorage {
+	return newSQLStorage(db, postgresDialect{})
+}
+
+func newSQLStorage(db *sql.DB, dialect sqlDialect) *SQLStorage {
+	return &SQLStorage{
+		DB:      *db,
+		dialect: dialect,
 	}
 }
 
-// UpdateCurrent updates multiple TUF records in a single transaction.
-// Always insert a new row. The unique constraint will ensure there is only ever
-func (db *MySQLStorage) UpdateCurrent(gun string, update MetaUpdate) error {
-	checkStmt := "SELECT count(*) FROM `tuf_files` WHERE `gun`=? AND `role`=? AND `version`>=?;"
-	insertStmt := "INSERT INTO `tuf_files` (`gun`, `role`, `version`, `data`) VALUES (?,?,?,?);"
+// MigrateSchema creates the tables used by the SQLStorage, or upgrades them
+// by applying the migrations that haven't been applied to the database yet.
+// Each migration is recorded in the `schema_migrations` table. On MySQL, the
+// first migration also upgrades the tables of databases created by an older
+// notarymysql/initial.sql, keeping their data.
+func (db *SQLStorage) MigrateSchema() error {
+	createStmt := db.dialect.rebind("CREATE TABLE IF NOT EXISTS `schema_migrations` (`version` INTEGER NOT NULL PRIMARY KEY);")
+	versionStmt := db.dialect.rebind("SELECT COALESCE(MAX(`version`), 0) FROM `schema_migrations`;")
+	recordStmt := db.dialect.rebind("INSERT INTO `schema_migrations` (`version`) VALUES (?);")
 
-	// ensure we're not inserting an immediately old version
-	row := db.QueryRow(checkStmt, gun, update.Role, update.Version)
-	var exists int
-	err := row.Scan(&exists)
-	if err != nil {
+	if _, err := db.Exec(createStmt); err != nil {
 		return err
 	}
-	if exists != 0 {
-		return &ErrOldVersion{}
+	var current int
+	if err := db.QueryRow(versionStmt).Scan(&current); err != nil {
+		return err
 	}
-
-	// attempt to insert. Due to race conditions with the check this could fail.
-	// That's OK, we're doing first write wins. The client will be messaged it
-	// needs to rebase.
-	_, err = db.Exec(insertStmt, gun, update.Role, update.Version, update.Data)
-	if err != nil {
-		if err, ok := err.(*mysql.MySQLError); ok {
-			if err.Number == 1022 { // duplicate key error
-				return &ErrOldVersion{}
+	for i, migration := range db.dialect.migrations() {
+		version := i + 1
+		if version <= current {
+			continue
+		}
+		// MySQL commits DDL statements implicitly, the transaction only
+		// makes the migration atomic on PostgreSQL
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = migration(tx)
+		if err == nil {
+			_, err = tx.Exec(recordStmt, version)
+		}
+		if err != nil {
+			rbErr := tx.Rollback()
+			if rbErr != nil {
+				logrus.Panic("Failed on Tx rollback with error: ", err.Error())
 			}
+			return fmt.Errorf("schema migration %d failed: %v", version, err)
 		}
-		// need to check error type for duplicate key exception
-		// and return ErrOldVersion if duplicate
-		return err
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		logrus.Infof("applied schema migration %d", version)
 	}
 	return nil
 }
 
+// UpdateCurrent updates the meta data for a specific role, in a single
+// transaction
+func (db *SQLStorage) UpdateCurrent(gun string, update MetaUpdate) error {
+	return db.UpdateMany(gun, []MetaUpdate{update})
+}
+
 // UpdateMany atomically updates many TUF records in a single transaction
-func (db *MySQLStorage) UpdateMany(gun string, updates []MetaUpdate) error {
-	checkStmt := "SELECT count(*) FROM `tuf_files` WHERE `gun`=? AND `role`=? AND `version`>=?;"
-	insertStmt := "INSERT INTO `tuf_files` (`gun`, `role`, `version`, `data`) VALUES (?,?,?,?);"
+func (db *SQLStorage) UpdateMany(gun string, updates []MetaUpdate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := db.insertUpdates(tx, gun, updates); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+// PublishUpdates updates multiple TUF records like UpdateMany, and adds an
+// entry to the changefeed for each of them, recording the user that
+// published them, in the same transaction
+func (db *SQLStorage) PublishUpdates(gun, user string, updates []MetaUpdate) error {
+	stmt := db.dialect.rebind("INSERT INTO `changefeed` (`gun`, `role`, `version`, `user`) VALUES (?,?,?,?);")
 
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	if err := db.insertUpdates(tx, gun, updates); err != nil {
+		return rollback(tx, err)
+	}
+	for _, u := range updates {
+		if _, err := tx.Exec(stmt, gun, u.Role, u.Version, user); err != nil {
+			return rollback(tx, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// insertUpdates inserts the TUF records in tx, returning ErrOldVersion if
+// any of them isn't newer than the stored record
+func (db *SQLStorage) insertUpdates(tx *sql.Tx, gun string, updates []MetaUpdate) error {
+	checkStmt := db.dialect.rebind("SELECT count(*) FROM `tuf_files` WHERE `gun`=? AND `role`=? AND `version`>=?;")
+	insertStmt := db.dialect.rebind("INSERT INTO `tuf_files` (`gun`, `role`, `version`, `sha256`, `data`) VALUES (?,?,?,?,?);")
+
 	for _, u := range updates {
 		// ensure we're not inserting an immediately old version
-		row := db.QueryRow(checkStmt, gun, u.Role, u.Version)
+		row := tx.QueryRow(checkStmt, gun, u.Role, u.Version)
 		var exists int
-		err := row.Scan(&exists)
-		if err != nil {
-			rbErr := tx.Rollback()
-			if rbErr != nil {
-				logrus.Panic("Failed on Tx rollback with error: ", err.Error())
-			}
+		if err := row.Scan(&exists); err != nil {
 			return err
 		}
 		if exists != 0 {
-			rbErr := tx.Rollback()
-			if rbErr != nil {
-				logrus.Panic("Failed on Tx rollback with error: ", err.Error())
-			}
 			return &ErrOldVersion{}
 		}
 		// attempt to insert. Due to race conditions with the check this could fail.
 		// That's OK, we're doing first write wins. The client will be messaged it
 		// needs to rebase.
-		_, err = tx.Exec(insertStmt, gun, u.Role, u.Version, u.Data)
+		_, err := tx.Exec(insertStmt, gun, u.Role, u.Version, checksum(u.Data), u.Data)
 		if err != nil {
-			// need to check error type for duplicate key exception
-			// and return ErrOldVersion if duplicate
-			rbErr := tx.Rollback()
-			if rbErr != nil {
-				logrus.Panic("Failed on Tx rollback with error: ", err.Error())
-			}
-			if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1022 { // duplicate key error
+			if db.dialect.isDuplicate(err) {
 				return &ErrOldVersion{}
 			}
 			return err
 		}
 	}
-	return tx.Commit()
+	return nil
+}
+
+// rollback rolls tx back after it failed with err, which is returned
+func rollback(tx *sql.Tx, err error) error {
+	if rbErr := tx.Rollback(); rbErr != nil {
+		logrus.Panic("Failed on Tx rollback with error: ", rbErr.Error())
+	}
+	return err
 }
 
 // GetCurrent gets a specific TUF record
-func (db *MySQLStorage) GetCurrent(gun, tufRole string) (data []byte, err error) {
-	stmt := "SELECT `data` FROM `tuf_files` WHERE `gun`=? AND `role`=? ORDER BY `version` DESC LIMIT 1;"
+func (db *SQLStorage) GetCurrent(gun, tufRole string) (data []byte, err error) {
+	stmt := db.dialect.rebind("SELECT `data` FROM `tuf_files` WHERE `gun`=? AND `role`=? ORDER BY `version` DESC LIMIT 1;")
 	rows, err := db.Query(stmt, gun, tufRole) // this should be a QueryRow()
 	if err != nil {
 		return nil, err
@@ -138,22 +181,51 @@ func (db *MySQLStorage) GetCurrent(gun, tufRole string) (data []byte, err error)
 	return data, nil
 }
 
+// GetVersion gets a specific version of a TUF record
+func (db *SQLStorage) GetVersion(gun, tufRole string, version int) (data []byte, err error) {
+	stmt := db.dialect.rebind("SELECT `data` FROM `tuf_files` WHERE `gun`=? AND `role`=? AND `version`=?;")
+	row := db.QueryRow(stmt, gun, tufRole, version)
+
+	err = row.Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, &ErrNotFound{}
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetChecksum gets the TUF record whose hex encoded sha256 checksum matches
+// the one given
+func (db *SQLStorage) GetChecksum(gun, tufRole, checksum string) (data []byte, err error) {
+	stmt := db.dialect.rebind("SELECT `data` FROM `tuf_files` WHERE `gun`=? AND `role`=? AND `sha256`=? LIMIT 1;")
+	row := db.QueryRow(stmt, gun, tufRole, checksum)
+
+	err = row.Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, &ErrNotFound{}
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // Delete deletes all the records for a specific GUN
-func (db *MySQLStorage) Delete(gun string) error {
-	stmt := "DELETE FROM `tuf_files` WHERE `gun`=?;"
+func (db *SQLStorage) Delete(gun string) error {
+	stmt := db.dialect.rebind("DELETE FROM `tuf_files` WHERE `gun`=?;")
 	_, err := db.Exec(stmt, gun)
 	return err
 }
 
-// GetTimestampKey returns the timestamps Public Key data
-func (db *MySQLStorage) GetTimestampKey(gun string) (algorithm data.KeyAlgorithm, public []byte, err error) {
-	stmt := "SELECT `cipher`, `public` FROM `timestamp_keys` WHERE `gun`=?;"
-	row := db.QueryRow(stmt, gun)
+// GetKey returns the Public Key data for a role
+func (db *SQLStorage) GetKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
+	stmt := db.dialect.rebind("SELECT `cipher`, `public` FROM `tuf_keys` WHERE `gun`=? AND `role`=?;")
+	row := db.QueryRow(stmt, gun, role)
 
 	var cipher string
 	err = row.Scan(&cipher, &public)
 	if err == sql.ErrNoRows {
-		return "", nil, &ErrNoKey{gun: gun}
+		return "", nil, &ErrNoKey{gun: gun, role: role}
 	} else if err != nil {
 		return "", nil, err
 	}
@@ -161,15 +233,99 @@ func (db *MySQLStorage) GetTimestampKey(gun string) (algorithm data.KeyAlgorithm
 	return data.KeyAlgorithm(cipher), public, err
 }
 
-// SetTimestampKey attempts to write a TimeStamp key and returns an error if it already exists
-func (db *MySQLStorage) SetTimestampKey(gun string, algorithm data.KeyAlgorithm, public []byte) error {
-	stmt := "INSERT INTO `timestamp_keys` (`gun`, `cipher`, `public`) VALUES (?,?,?);"
-	_, err := db.Exec(stmt, gun, string(algorithm), public)
+// SetKey attempts to write a key for a role and returns an error if it already exists
+func (db *SQLStorage) SetKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
+	stmt := db.dialect.rebind("INSERT INTO `tuf_keys` (`gun`, `role`, `cipher`, `public`) VALUES (?,?,?,?);")
+	_, err := db.Exec(stmt, gun, role, string(algorithm), public)
 	if err != nil {
-		if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1022 {
-			return &ErrTimestampKeyExists{gun: gun}
+		if db.dialect.isDuplicate(err) {
+			return &ErrKeyExists{gun: gun, role: role}
 		}
 		return err
 	}
 	return nil
 }
+
+// GetPendingKey returns the Public Key data for the key that will replace
+// the key for a role
+func (db *SQLStorage) GetPendingKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
+	stmt := db.dialect.rebind("SELECT `cipher`, `public` FROM `tuf_pending_keys` WHERE `gun`=? AND `role`=?;")
+	row := db.QueryRow(stmt, gun, role)
+
+	var cipher string
+	err = row.Scan(&cipher, &public)
+	if err == sql.ErrNoRows {
+		return "", nil, &ErrNoKey{gun: gun, role: role}
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	return data.KeyAlgorithm(cipher), public, err
+}
+
+// SetPendingKey writes the key that will replace the key for a role,
+// overwriting any previous pending key
+func (db *SQLStorage) SetPendingKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
+	stmt := db.dialect.upsert("tuf_pending_keys", []string{"gun", "role"}, []string{"cipher", "public"})
+	_, err := db.Exec(stmt, gun, role, string(algorithm), public)
+	return err
+}
+
+// ActivatePendingKey replaces the key for a role with the pending key, in a
+// single transaction
+func (db *SQLStorage) ActivatePendingKey(gun, role string) error {
+	selectStmt := db.dialect.rebind("SELECT `cipher`, `public` FROM `tuf_pending_keys` WHERE `gun`=? AND `role`=?;")
+	updateStmt := db.dialect.rebind("UPDATE `tuf_keys` SET `cipher`=?, `public`=? WHERE `gun`=? AND `role`=?;")
+	deleteStmt := db.dialect.rebind("DELETE FROM `tuf_pending_keys` WHERE `gun`=? AND `role`=?;")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var (
+		cipher string
+		public []byte
+	)
+	err = tx.QueryRow(selectStmt, gun, role).Scan(&cipher, &public)
+	if err == nil {
+		_, err = tx.Exec(updateStmt, cipher, public, gun, role)
+	}
+	if err == nil {
+		_, err = tx.Exec(deleteStmt, gun, role)
+	}
+	if err != nil {
+		rbErr := tx.Rollback()
+		if rbErr != nil {
+			logrus.Panic("Failed on Tx rollback with error: ", err.Error())
+		}
+		if err == sql.ErrNoRows {
+			return &ErrNoKey{gun: gun, role: role}
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetChanges returns up to pageSize changes published to a gun, starting
+// after the change with ID changeID
+func (db *SQLStorage) GetChanges(gun string, changeID, pageSize int) ([]Change, error) {
+	stmt := db.dialect.rebind("SELECT `id`, `created_at`, `role`, `version`, `user` FROM `changefeed` WHERE `gun`=? AND `id`>? ORDER BY `id` LIMIT ?;")
+	rows, err := db.Query(stmt, gun, changeID, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]Change, 0, pageSize)
+	for rows.Next() {
+		var createdAt mysql.NullTime
+		c := Change{GUN: gun}
+		err = rows.Scan(&c.ID, &createdAt, &c.Role, &c.Version, &c.User)
+		if err != nil {
+			return nil, err
+		}
+		c.CreatedAt = createdAt.Time
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
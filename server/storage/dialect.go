@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// sqlDialect adapts the queries of the SQLStorage to a database. Queries are
+// written for MySQL, quoting identifiers with backticks and using ? for
+// parameters, and rebound for the other databases.
+type sqlDialect interface {
+	// rebind rewrites a query written for MySQL in the database's syntax
+	rebind(query string) string
+	// upsert returns a statement inserting a row in table, or updating the
+	// values of the row with the same keys if there is one
+	upsert(table string, keys, values []string) string
+	// isDuplicate reports whether err is a unique constraint violation
+	isDuplicate(err error) bool
+	// migrations returns the schema migrations, in order
+	migrations() []migration
+}
+
+// migration upgrades the schema of a database, in a transaction
+type migration func(tx *sql.Tx) error
+
+// execMigration returns a migration executing the statements in order
+func execMigration(stmts []string) migration {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// mysqlDialect is the dialect of MySQL and compatible databases
+type mysqlDialect struct{}
+
+func (mysqlDialect) rebind(query string) string {
+	return query
+}
+
+func (mysqlDialect) upsert(table string, keys, values []string) string {
+	updates := make([]string, 0, len(values))
+	for _, v := range values {
+		updates = append(updates, fmt.Sprintf("`%s`=VALUES(`%s`)", v, v))
+	}
+	return insertQuery(table, keys, values) + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ") + ";"
+}
+
+func (mysqlDialect) isDuplicate(err error) bool {
+	// 1022 and 1062 are the duplicate key and duplicate entry errors
+	if err, ok := err.(*mysql.MySQLError); ok {
+		return err.Number == 1022 || err.Number == 1062
+	}
+	return false
+}
+
+// mysqlTables are the tables created by notarymysql/initial.sql
+var mysqlTables = []string{
+	"CREATE TABLE IF NOT EXISTS `tuf_files` (" +
+		"`id` int(11) NOT NULL AUTO_INCREMENT, " +
+		"`gun` varchar(255) NOT NULL, " +
+		"`role` varchar(255) NOT NULL, " +
+		"`version` int(11) NOT NULL, " +
+		"`sha256` char(64) NOT NULL, " +
+		"`data` longblob NOT NULL, " +
+		"PRIMARY KEY (`id`), " +
+		"UNIQUE KEY `gun` (`gun`,`role`,`version`), " +
+		"INDEX `gun_role_sha256` (`gun`,`role`,`sha256`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;",
+	"CREATE TABLE IF NOT EXISTS `tuf_keys` (" +
+		"`id` int(11) NOT NULL AUTO_INCREMENT, " +
+		"`gun` varchar(255) NOT NULL, " +
+		"`role` varchar(255) NOT NULL, " +
+		"`cipher` varchar(30) NOT NULL, " +
+		"`public` blob NOT NULL, " +
+		"PRIMARY KEY (`id`), " +
+		"UNIQUE KEY `gun_role` (`gun`,`role`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;",
+	"CREATE TABLE IF NOT EXISTS `tuf_pending_keys` (" +
+		"`id` int(11) NOT NULL AUTO_INCREMENT, " +
+		"`gun` varchar(255) NOT NULL, " +
+		"`role` varchar(255) NOT NULL, " +
+		"`cipher` varchar(30) NOT NULL, " +
+		"`public` blob NOT NULL, " +
+		"PRIMARY KEY (`id`), " +
+		"UNIQUE KEY `gun_role` (`gun`,`role`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;",
+	"CREATE TABLE IF NOT EXISTS `changefeed` (" +
+		"`id` int(11) NOT NULL AUTO_INCREMENT, " +
+		"`created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+		"`gun` varchar(255) NOT NULL, " +
+		"`role` varchar(255) NOT NULL, " +
+		"`version` int(11) NOT NULL, " +
+		"`user` varchar(255) NOT NULL, " +
+		"PRIMARY KEY (`id`), " +
+		"INDEX `gun_id` (`gun`,`id`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;",
+}
+
+func (mysqlDialect) migrations() []migration {
+	return []migration{
+		mysqlInitialSchema,
+	}
+}
+
+// mysqlInitialSchema creates the tables in mysqlTables that don't exist.
+// Tables left by an older initial.sql are upgraded to the same schema, each
+// step checking whether it is needed: MySQL commits DDL statements
+// implicitly, so an interrupted migration is resumed by running it again.
+func mysqlInitialSchema(tx *sql.Tx) error {
+	if err := execMigration(mysqlTables)(tx); err != nil {
+		return err
+	}
+	hasChecksum, err := mysqlColumnExists(tx, "tuf_files", "sha256")
+	if err != nil {
+		return err
+	}
+	if !hasChecksum {
+		_, err := tx.Exec("ALTER TABLE `tuf_files` " +
+			"ADD COLUMN `sha256` char(64) NOT NULL DEFAULT '' AFTER `version`, " +
+			"ADD INDEX `gun_role_sha256` (`gun`,`role`,`sha256`);")
+		if err != nil {
+			return err
+		}
+	}
+	if err := backfillChecksums(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("ALTER TABLE `tuf_files` ALTER COLUMN `sha256` DROP DEFAULT;"); err != nil {
+		return err
+	}
+
+	// the timestamp keys used to be stored in their own table. Its cipher
+	// column was an integer in initial.sql, so MySQL stored the algorithm
+	// names as 0. Those keys were all created as ed25519 keys.
+	hasTimestampKeys, err := mysqlTableExists(tx, "timestamp_keys")
+	if err != nil || !hasTimestampKeys {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO `tuf_keys` (`gun`, `role`, `cipher`, `public`) " +
+		"SELECT `t`.`gun`, 'timestamp', IF(`t`.`cipher`='0', 'ed25519', `t`.`cipher`), `t`.`public` FROM `timestamp_keys` AS `t` " +
+		"LEFT JOIN `tuf_keys` AS `k` ON `k`.`gun`=`t`.`gun` AND `k`.`role`='timestamp' " +
+		"WHERE `k`.`id` IS NULL;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE `timestamp_keys`;")
+	return err
+}
+
+// backfillChecksumsBatch is the number of rows backfillChecksums reads at once
+const backfillChecksumsBatch = 100
+
+// backfillChecksums sets the checksums of the rows of tuf_files stored
+// before the table had a sha256 column
+func backfillChecksums(tx *sql.Tx) error {
+	for {
+		rows, err := tx.Query("SELECT `id`, `data` FROM `tuf_files` WHERE `sha256`='' LIMIT ?;", backfillChecksumsBatch)
+		if err != nil {
+			return err
+		}
+		sums := make(map[int]string)
+		for rows.Next() {
+			var (
+				id   int
+				data []byte
+			)
+			if err := rows.Scan(&id, &data); err != nil {
+				rows.Close()
+				return err
+			}
+			sums[id] = checksum(data)
+		}
+		// the rows must be closed before the connection of the transaction
+		// can run the updates
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(sums) == 0 {
+			return nil
+		}
+		for id, sum := range sums {
+			if _, err := tx.Exec("UPDATE `tuf_files` SET `sha256`=? WHERE `id`=?;", sum, id); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// mysqlTableExists reports whether the current database has a table
+func mysqlTableExists(tx *sql.Tx, table string) (bool, error) {
+	var n int
+	err := tx.QueryRow(
+		"SELECT count(*) FROM information_schema.tables WHERE `table_schema`=DATABASE() AND `table_name`=?;",
+		table,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// mysqlColumnExists reports whether a table of the current database has a
+// column
+func mysqlColumnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var n int
+	err := tx.QueryRow(
+		"SELECT count(*) FROM information_schema.columns WHERE `table_schema`=DATABASE() AND `table_name`=? AND `column_name`=?;",
+		table, column,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// postgresDialect is the dialect of PostgreSQL
+type postgresDialect struct{}
+
+// rebind quotes identifiers with double quotes, as "user" is a reserved
+// word, and numbers the parameters
+func (postgresDialect) rebind(query string) string {
+	var (
+		b bytes.Buffer
+		n int
+	)
+	for _, c := range query {
+		switch c {
+		case '`':
+			b.WriteRune('"')
+		case '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (d postgresDialect) upsert(table string, keys, values []string) string {
+	updates := make([]string, 0, len(values))
+	for _, v := range values {
+		updates = append(updates, fmt.Sprintf("`%s`=EXCLUDED.`%s`", v, v))
+	}
+	quoted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		quoted = append(quoted, "`"+k+"`")
+	}
+	stmt := insertQuery(table, keys, values) +
+		" ON CONFLICT (" + strings.Join(quoted, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", ") + ";"
+	return d.rebind(stmt)
+}
+
+// isDuplicate checks for the unique_violation SQLSTATE. The error fields of
+// github.com/lib/pq are read through their Get method, so the driver only
+// needs to be linked into binaries using PostgreSQL.
+func (postgresDialect) isDuplicate(err error) bool {
+	if err, ok := err.(interface {
+		Get(field byte) string
+	}); ok {
+		return err.Get('C') == "23505"
+	}
+	return false
+}
+
+// postgresTables are the statements creating the tables on PostgreSQL
+var postgresTables = []string{
+	`CREATE TABLE "tuf_files" (` +
+		`"id" SERIAL PRIMARY KEY, ` +
+		`"gun" VARCHAR(255) NOT NULL, ` +
+		`"role" VARCHAR(255) NOT NULL, ` +
+		`"version" INTEGER NOT NULL, ` +
+		`"sha256" CHAR(64) NOT NULL, ` +
+		`"data" BYTEA NOT NULL, ` +
+		`UNIQUE ("gun", "role", "version")` +
+		`);`,
+	`CREATE INDEX "tuf_files_gun_role_sha256" ON "tuf_files" ("gun", "role", "sha256");`,
+	`CREATE TABLE "tuf_keys" (` +
+		`"id" SERIAL PRIMARY KEY, ` +
+		`"gun" VARCHAR(255) NOT NULL, ` +
+		`"role" VARCHAR(255) NOT NULL, ` +
+		`"cipher" VARCHAR(30) NOT NULL, ` +
+		`"public" BYTEA NOT NULL, ` +
+		`UNIQUE ("gun", "role")` +
+		`);`,
+	`CREATE TABLE "tuf_pending_keys" (` +
+		`"id" SERIAL PRIMARY KEY, ` +
+		`"gun" VARCHAR(255) NOT NULL, ` +
+		`"role" VARCHAR(255) NOT NULL, ` +
+		`"cipher" VARCHAR(30) NOT NULL, ` +
+		`"public" BYTEA NOT NULL, ` +
+		`UNIQUE ("gun", "role")` +
+		`);`,
+	`CREATE TABLE "changefeed" (` +
+		`"id" SERIAL PRIMARY KEY, ` +
+		`"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, ` +
+		`"gun" VARCHAR(255) NOT NULL, ` +
+		`"role" VARCHAR(255) NOT NULL, ` +
+		`"version" INTEGER NOT NULL, ` +
+		`"user" VARCHAR(255) NOT NULL` +
+		`);`,
+	`CREATE INDEX "changefeed_gun_id" ON "changefeed" ("gun", "id");`,
+}
+
+func (postgresDialect) migrations() []migration {
+	return []migration{
+		execMigration(postgresTables),
+	}
+}
+
+// insertQuery returns an INSERT statement for the key and value columns of
+// table, in the MySQL syntax and without the terminating semicolon
+func insertQuery(table string, keys, values []string) string {
+	var quoted, params []string
+	for _, c := range append(append([]string{}, keys...), values...) {
+		quoted = append(quoted, "`"+c+"`")
+		params = append(params, "?")
+	}
+	return fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)", table, strings.Join(quoted, ", "), strings.Join(params, ","))
+}
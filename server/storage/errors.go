@@ -20,22 +20,24 @@ func (err ErrNotFound) Error() string {
 	return fmt.Sprintf("No record found")
 }
 
-// ErrTimestampKeyExists is returned when a timestamp key already exists
-type ErrTimestampKeyExists struct {
-	gun string
+// ErrKeyExists is returned when a key already exists for a role
+type ErrKeyExists struct {
+	gun  string
+	role string
 }
 
-// ErrTimestampKeyExists is returned when a timestamp key already exists
-func (err ErrTimestampKeyExists) Error() string {
-	return fmt.Sprintf("Error, timestamp key already exists for %s", err.gun)
+// ErrKeyExists is returned when a key already exists for a role
+func (err ErrKeyExists) Error() string {
+	return fmt.Sprintf("Error, %s key already exists for %s", err.role, err.gun)
 }
 
-// ErrNoKey is returned when no timestamp key is found
+// ErrNoKey is returned when no key is found for a role
 type ErrNoKey struct {
-	gun string
+	gun  string
+	role string
 }
 
-// ErrNoKey is returned when no timestamp key is found
+// ErrNoKey is returned when no key is found for a role
 func (err ErrNoKey) Error() string {
-	return fmt.Sprintf("Error, no timestamp key found for %s", err.gun)
+	return fmt.Sprintf("Error, no %s key found for %s", err.role, err.gun)
 }
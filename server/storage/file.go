@@ -0,0 +1,532 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/endophage/gotuf/data"
+)
+
+// FileStorage is a durable MetaStore kept in a journal file, for running a
+// notary-server without a database. The whole store is held in memory, and
+// each transaction is appended to the journal as a single line, synced to
+// disk before the transaction is applied. Opening the store replays the
+// journal; a transaction cut short by a crash was never acknowledged, so it
+// is discarded. Once the journal has doubled in size since it was last
+// compacted, it is replaced by a journal holding only the current state. The
+// journal is locked while the store is open, as only one server may use it at
+// a time.
+type FileStorage struct {
+	lock    sync.RWMutex
+	path    string
+	journal *os.File
+	// size is the length of the journal up to the last complete transaction
+	size int64
+	// compactAt is the size from which the journal is compacted
+	compactAt int64
+	state     fileState
+	// err is set when a failed write couldn't be undone, in which case the
+	// journal can't be appended to anymore
+	err error
+}
+
+// fileVersion is a version of a TUF record stored in a FileStorage
+type fileVersion struct {
+	Version  int    `json:"version"`
+	Checksum string `json:"sha256"`
+	Data     []byte `json:"data"`
+}
+
+// fileUpdate is a new version of the TUF record for a role
+type fileUpdate struct {
+	Role string `json:"role"`
+	fileVersion
+}
+
+// fileKey is a public key stored in a FileStorage
+type fileKey struct {
+	Algorithm data.KeyAlgorithm `json:"cipher"`
+	Public    []byte            `json:"public"`
+}
+
+// fileRecord is a transaction in the journal of a FileStorage, holding the
+// changes made to a GUN. Keys are indexed by role.
+type fileRecord struct {
+	GUN      string             `json:"gun"`
+	Delete   bool               `json:"delete,omitempty"`
+	Updates  []fileUpdate       `json:"updates,omitempty"`
+	Keys     map[string]fileKey `json:"keys,omitempty"`
+	Pending  map[string]fileKey `json:"pending_keys,omitempty"`
+	Activate []string           `json:"activate_keys,omitempty"`
+	Changes  []Change           `json:"changefeed,omitempty"`
+}
+
+// fileCompactMinSize is the size under which a journal isn't compacted
+const fileCompactMinSize = 1 << 20
+
+// fileState is the content of a FileStorage, built by applying the records
+// of the journal. Records and keys are indexed by GUN, then role.
+type fileState struct {
+	files   map[string]map[string][]fileVersion
+	keys    map[string]map[string]fileKey
+	pending map[string]map[string]fileKey
+	changes []Change
+}
+
+// NewFileStorage opens the store kept in the journal file at path, which is
+// created if it doesn't exist. An error is returned if another process has
+// the store open.
+func NewFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	journal, err := openJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &FileStorage{
+		path:    path,
+		journal: journal,
+		state: fileState{
+			files:   make(map[string]map[string][]fileVersion),
+			keys:    make(map[string]map[string]fileKey),
+			pending: make(map[string]map[string]fileKey),
+		},
+	}
+	// the journal may have just been created, make sure its directory entry
+	// survives a crash
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		st.Close()
+		return nil, err
+	}
+	if err := st.replay(); err != nil {
+		st.Close()
+		return nil, err
+	}
+	st.compactAt = compactSize(st.size)
+	return st, nil
+}
+
+// openJournal opens the journal at path for appending, creating it if it
+// doesn't exist, and locks it
+func openJournal(path string) (*os.File, error) {
+	journal, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(journal.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("%s is in use by another process: %v", path, err)
+	}
+	return journal, nil
+}
+
+// compactSize returns the size from which a journal compacted to size is
+// compacted again
+func compactSize(size int64) int64 {
+	if size < fileCompactMinSize/2 {
+		return fileCompactMinSize
+	}
+	return 2 * size
+}
+
+// Close releases the store, so it can be opened by another process
+func (st *FileStorage) Close() error {
+	defer st.journal.Close()
+	return syscall.Flock(int(st.journal.Fd()), syscall.LOCK_UN)
+}
+
+// UpdateCurrent updates the meta data for a specific role
+func (st *FileStorage) UpdateCurrent(gun string, update MetaUpdate) error {
+	return st.UpdateMany(gun, []MetaUpdate{update})
+}
+
+// UpdateMany updates multiple TUF records in a single transaction. Nothing
+// is stored if any of the updates isn't newer than the stored record.
+func (st *FileStorage) UpdateMany(gun string, updates []MetaUpdate) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	rec, err := st.updateRecord(gun, updates)
+	if err != nil {
+		return err
+	}
+	return st.commit(rec)
+}
+
+// PublishUpdates updates multiple TUF records like UpdateMany, and adds an
+// entry to the changefeed for each of them, recording the user that
+// published them, in the same transaction
+func (st *FileStorage) PublishUpdates(gun, user string, updates []MetaUpdate) error {
+	now := time.Now().UTC()
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	rec, err := st.updateRecord(gun, updates)
+	if err != nil {
+		return err
+	}
+	for i, u := range updates {
+		rec.Changes = append(rec.Changes, Change{
+			ID:        len(st.state.changes) + i + 1,
+			GUN:       gun,
+			Role:      u.Role,
+			Version:   u.Version,
+			User:      user,
+			CreatedAt: now,
+		})
+	}
+	return st.commit(rec)
+}
+
+// updateRecord returns the record storing the updates, or ErrOldVersion if
+// any of them isn't newer than the stored record. It must be called with the
+// lock held.
+func (st *FileStorage) updateRecord(gun string, updates []MetaUpdate) (*fileRecord, error) {
+	rec := &fileRecord{GUN: gun}
+	roles := st.state.files[gun]
+	for _, u := range updates {
+		for _, v := range roles[u.Role] {
+			if v.Version >= u.Version {
+				return nil, &ErrOldVersion{}
+			}
+		}
+		rec.Updates = append(rec.Updates, fileUpdate{
+			Role: u.Role,
+			fileVersion: fileVersion{
+				Version:  u.Version,
+				Checksum: checksum(u.Data),
+				Data:     u.Data,
+			},
+		})
+	}
+	return rec, nil
+}
+
+// GetCurrent returns the metadata for a given role, under a GUN
+func (st *FileStorage) GetCurrent(gun, role string) (data []byte, err error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	versions := st.state.files[gun][role]
+	if len(versions) == 0 {
+		return nil, &ErrNotFound{}
+	}
+	return versions[len(versions)-1].Data, nil
+}
+
+// GetVersion returns the metadata for a given version of a role, under a GUN
+func (st *FileStorage) GetVersion(gun, role string, version int) (data []byte, err error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	for _, v := range st.state.files[gun][role] {
+		if v.Version == version {
+			return v.Data, nil
+		}
+	}
+	return nil, &ErrNotFound{}
+}
+
+// GetChecksum returns the metadata for a role, under a GUN, whose hex encoded
+// sha256 checksum matches the one given
+func (st *FileStorage) GetChecksum(gun, role, checksum string) (data []byte, err error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	for _, v := range st.state.files[gun][role] {
+		if v.Checksum == checksum {
+			return v.Data, nil
+		}
+	}
+	return nil, &ErrNotFound{}
+}
+
+// Delete deletes all the metadata for a given GUN. The deleted metadata is
+// dropped from the journal when it is next compacted.
+func (st *FileStorage) Delete(gun string) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.commit(&fileRecord{GUN: gun, Delete: true})
+}
+
+// GetKey returns the public key material of the key for a role of a given gun
+func (st *FileStorage) GetKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	k, ok := st.state.keys[gun][role]
+	if !ok {
+		return "", nil, &ErrNoKey{gun: gun, role: role}
+	}
+	return k.Algorithm, k.Public, nil
+}
+
+// SetKey sets the key for a role under a gun, returning an error if it
+// already exists
+func (st *FileStorage) SetKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if _, ok := st.state.keys[gun][role]; ok {
+		return &ErrKeyExists{gun: gun, role: role}
+	}
+	return st.commit(&fileRecord{
+		GUN:  gun,
+		Keys: map[string]fileKey{role: {Algorithm: algorithm, Public: public}},
+	})
+}
+
+// GetPendingKey returns the public key material of the key that will
+// replace the key for a role of a given gun
+func (st *FileStorage) GetPendingKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	k, ok := st.state.pending[gun][role]
+	if !ok {
+		return "", nil, &ErrNoKey{gun: gun, role: role}
+	}
+	return k.Algorithm, k.Public, nil
+}
+
+// SetPendingKey sets the key that will replace the key for a role under a
+// gun, overwriting any previous pending key
+func (st *FileStorage) SetPendingKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.commit(&fileRecord{
+		GUN:     gun,
+		Pending: map[string]fileKey{role: {Algorithm: algorithm, Public: public}},
+	})
+}
+
+// ActivatePendingKey replaces the key for a role under a gun with the
+// pending key, in a single transaction
+func (st *FileStorage) ActivatePendingKey(gun, role string) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if _, ok := st.state.pending[gun][role]; !ok {
+		return &ErrNoKey{gun: gun, role: role}
+	}
+	return st.commit(&fileRecord{GUN: gun, Activate: []string{role}})
+}
+
+// GetChanges returns up to pageSize changes published to a gun, starting
+// after the change with ID changeID
+func (st *FileStorage) GetChanges(gun string, changeID, pageSize int) ([]Change, error) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	// changes are stored in ID order, skip the ones up to changeID
+	all := st.state.changes
+	start := sort.Search(len(all), func(i int) bool { return all[i].ID > changeID })
+	changes := make([]Change, 0, pageSize)
+	for _, c := range all[start:] {
+		if len(changes) >= pageSize {
+			break
+		}
+		if c.GUN == gun {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// commit appends rec to the journal, syncs it, and applies it to the state.
+// It must be called with the lock held. If the record can't be written, the
+// journal is truncated back to its last complete transaction and the state
+// is left untouched.
+func (st *FileStorage) commit(rec *fileRecord) error {
+	if st.err != nil {
+		return st.err
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	_, err = st.journal.Write(raw)
+	if err == nil {
+		err = st.journal.Sync()
+	}
+	if err != nil {
+		if truncErr := st.journal.Truncate(st.size); truncErr != nil {
+			st.err = fmt.Errorf("%s could not be restored after a failed write: %v", st.path, truncErr)
+		}
+		return err
+	}
+	st.size += int64(len(raw))
+	st.state.apply(rec)
+	if st.size >= st.compactAt {
+		// the transaction is durable already, a journal that can't be
+		// compacted is only left to grow
+		if err := st.compact(); err != nil {
+			logrus.Warnf("[Notary Server] could not compact %s: %v", st.path, err)
+		}
+		st.compactAt = compactSize(st.size)
+	}
+	return nil
+}
+
+// compact replaces the journal with one holding only the current state. The
+// new journal is written to a temporary file, synced, and renamed over the
+// journal, so a crash leaves either journal in place. It must be called with
+// the lock held.
+func (st *FileStorage) compact() error {
+	tmpPath := st.path + ".compact"
+	os.Remove(tmpPath)
+	tmp, err := openJournal(tmpPath)
+	if err != nil {
+		return err
+	}
+	size, err := st.state.writeTo(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, st.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// the compacted journal is locked, and replaces the old one from now on
+	st.Close()
+	st.journal = tmp
+	st.size = size
+	return syncDir(filepath.Dir(st.path))
+}
+
+// replay applies the records of the journal to the state. An incomplete last
+// record, left by a crash while it was written, is removed from the journal.
+func (st *FileStorage) replay() error {
+	r := bufio.NewReader(st.journal)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil
+			}
+			logrus.Warnf("[Notary Server] discarding an incomplete transaction at the end of %s", st.path)
+			return st.journal.Truncate(st.size)
+		}
+		if err != nil {
+			return err
+		}
+		rec := &fileRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return fmt.Errorf("could not parse %s at offset %d: %v", st.path, st.size, err)
+		}
+		st.state.apply(rec)
+		st.size += int64(len(line))
+	}
+}
+
+// writeTo writes records recreating the state to w, returning the number of
+// bytes written. Every version of the metadata is kept, as old versions can
+// still be requested, but deleted GUNs and replaced keys are dropped.
+func (s *fileState) writeTo(w io.Writer) (int64, error) {
+	guns := make(map[string]bool)
+	for gun := range s.files {
+		guns[gun] = true
+	}
+	for gun := range s.keys {
+		guns[gun] = true
+	}
+	for gun := range s.pending {
+		guns[gun] = true
+	}
+	sorted := make([]string, 0, len(guns))
+	for gun := range guns {
+		sorted = append(sorted, gun)
+	}
+	sort.Strings(sorted)
+
+	records := make([]*fileRecord, 0, len(sorted)+1)
+	for _, gun := range sorted {
+		rec := &fileRecord{GUN: gun, Keys: s.keys[gun], Pending: s.pending[gun]}
+		roles := make([]string, 0, len(s.files[gun]))
+		for role := range s.files[gun] {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		for _, role := range roles {
+			for _, v := range s.files[gun][role] {
+				rec.Updates = append(rec.Updates, fileUpdate{Role: role, fileVersion: v})
+			}
+		}
+		if len(rec.Updates) == 0 && len(rec.Keys) == 0 && len(rec.Pending) == 0 {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if len(s.changes) > 0 {
+		// the changefeed spans all the GUNs, and is kept in ID order
+		records = append(records, &fileRecord{Changes: s.changes})
+	}
+
+	bw := bufio.NewWriter(w)
+	var size int64
+	for _, rec := range records {
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return size, err
+		}
+		raw = append(raw, '\n')
+		if _, err := bw.Write(raw); err != nil {
+			return size, err
+		}
+		size += int64(len(raw))
+	}
+	return size, bw.Flush()
+}
+
+// apply makes the changes of a record to the state
+func (s *fileState) apply(rec *fileRecord) {
+	if rec.Delete {
+		delete(s.files, rec.GUN)
+	}
+	if len(rec.Updates) > 0 {
+		roles := s.files[rec.GUN]
+		if roles == nil {
+			roles = make(map[string][]fileVersion)
+			s.files[rec.GUN] = roles
+		}
+		for _, u := range rec.Updates {
+			roles[u.Role] = append(roles[u.Role], u.fileVersion)
+		}
+	}
+	for role, k := range rec.Keys {
+		setFileKey(s.keys, rec.GUN, role, k)
+	}
+	for role, k := range rec.Pending {
+		setFileKey(s.pending, rec.GUN, role, k)
+	}
+	for _, role := range rec.Activate {
+		if k, ok := s.pending[rec.GUN][role]; ok {
+			setFileKey(s.keys, rec.GUN, role, k)
+			delete(s.pending[rec.GUN], role)
+		}
+	}
+	s.changes = append(s.changes, rec.Changes...)
+}
+
+func setFileKey(keys map[string]map[string]fileKey, gun, role string, k fileKey) {
+	if keys[gun] == nil {
+		keys[gun] = make(map[string]fileKey)
+	}
+	keys[gun][role] = k
+}
+
+// syncDir syncs the directory at path, so the entries created in it are
+// durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
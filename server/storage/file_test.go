@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/endophage/gotuf/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestFileStorage opens a FileStorage in a new temporary directory, which
+// is returned to be removed by the caller
+func newTestFileStorage(t *testing.T) (*FileStorage, string) {
+	dir, err := ioutil.TempDir("", "notary-server-test-")
+	assert.Nil(t, err, "Could not create a temporary directory")
+	s, err := NewFileStorage(filepath.Join(dir, "tuf.journal"))
+	assert.Nil(t, err, "Could not open the store")
+	return s, dir
+}
+
+func TestFileStorage(t *testing.T) {
+	testMetaStore(t, func() (MetaStore, func()) {
+		s, dir := newTestFileStorage(t)
+		return s, func() {
+			s.Close()
+			os.RemoveAll(dir)
+		}
+	})
+}
+
+func TestFileReopen(t *testing.T) {
+	s, dir := newTestFileStorage(t)
+	defer os.RemoveAll(dir)
+
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	s.SetKey("testGUN", "timestamp", data.ECDSAKey, []byte("2"))
+	s.PublishUpdates("testGUN", "alice", []MetaUpdate{{"targets", 1, []byte("3")}})
+	s.SetPendingKey("testGUN", "timestamp", data.ED25519Key, []byte("4"))
+	s.ActivatePendingKey("testGUN", "timestamp")
+	s.UpdateCurrent("other", MetaUpdate{"root", 1, []byte("5")})
+	s.Delete("other")
+
+	// the store can only be opened once
+	_, err := NewFileStorage(s.path)
+	assert.NotNil(t, err, "Expected an error opening a store in use")
+
+	assert.Nil(t, s.Close(), "Expected nil error from Close")
+	s, err = NewFileStorage(s.path)
+	assert.Nil(t, err, "Could not reopen the store")
+	defer s.Close()
+
+	d, err := s.GetCurrent("testGUN", "root")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("1"), d, "Returned data was not correct")
+	_, k, err := s.GetKey("testGUN", "timestamp")
+	assert.Nil(t, err, "Expected nil error from GetKey")
+	assert.Equal(t, []byte("4"), k, "Key data was wrong")
+	_, _, err = s.GetPendingKey("testGUN", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected ErrNoKey from GetPendingKey")
+	_, err = s.GetCurrent("other", "root")
+	assert.IsType(t, &ErrNotFound{}, err, "Deleted metadata was restored")
+	changes, err := s.GetChanges("testGUN", 0, 10)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	assert.Len(t, changes, 1, "Changes were not stored")
+
+	// new changes continue the IDs of the stored ones
+	s.PublishUpdates("testGUN", "bob", []MetaUpdate{{"targets", 2, []byte("6")}})
+	changes, err = s.GetChanges("testGUN", 1, 10)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	assert.Len(t, changes, 1, "Expected the changes after the first one")
+}
+
+// TestFileAppendsTransactions checks updates are appended to the journal
+// rather than rewriting it
+func TestFileAppendsTransactions(t *testing.T) {
+	s, dir := newTestFileStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	before, err := ioutil.ReadFile(s.path)
+	assert.Nil(t, err, "Could not read the journal")
+	s.PublishUpdates("testGUN", "alice", []MetaUpdate{{"targets", 1, []byte("2")}})
+	after, err := ioutil.ReadFile(s.path)
+	assert.Nil(t, err, "Could not read the journal")
+
+	assert.Equal(t, before, after[:len(before)], "The journal was rewritten")
+	// the update and its change are a single transaction
+	assert.Equal(t, 1, bytes.Count(after[len(before):], []byte("\n")), "Expected a single record")
+}
+
+// TestFileCompact checks a compacted journal holds the same state without
+// the deleted metadata, and is compacted once it doubles in size
+func TestFileCompact(t *testing.T) {
+	s, dir := newTestFileStorage(t)
+	defer os.RemoveAll(dir)
+
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	s.PublishUpdates("testGUN", "alice", []MetaUpdate{{"targets", 1, []byte("2")}})
+	s.PublishUpdates("testGUN", "bob", []MetaUpdate{{"targets", 2, []byte("3")}})
+	s.SetKey("testGUN", "timestamp", data.ECDSAKey, []byte("4"))
+	s.SetPendingKey("testGUN", "timestamp", data.ED25519Key, []byte("5"))
+	s.PublishUpdates("other", "carol", []MetaUpdate{{"root", 1, []byte("deleted")}})
+	s.Delete("other")
+	before, err := ioutil.ReadFile(s.path)
+	assert.Nil(t, err, "Could not read the journal")
+
+	assert.Nil(t, s.compact(), "Expected nil error from compact")
+	after, err := ioutil.ReadFile(s.path)
+	assert.Nil(t, err, "Could not read the journal")
+	assert.True(t, len(after) < len(before), "The journal was not compacted")
+	assert.Equal(t, int64(len(after)), s.size, "Size doesn't match the compacted journal")
+	deleted := []byte(checksum([]byte("deleted")))
+	assert.True(t, bytes.Contains(before, deleted), "Deleted metadata was not journaled")
+	assert.False(t, bytes.Contains(after, deleted), "Deleted metadata was kept")
+	_, err = os.Stat(s.path + ".compact")
+	assert.True(t, os.IsNotExist(err), "The temporary journal was left behind")
+
+	// the compacted journal is still locked, and restores the same state
+	_, err = NewFileStorage(s.path)
+	assert.NotNil(t, err, "Expected an error opening a store in use")
+	assert.Nil(t, s.Close(), "Expected nil error from Close")
+	s, err = NewFileStorage(s.path)
+	assert.Nil(t, err, "Could not reopen the store")
+	defer s.Close()
+
+	d, err := s.GetVersion("testGUN", "targets", 1)
+	assert.Nil(t, err, "Expected nil error from GetVersion")
+	assert.Equal(t, []byte("2"), d, "Old versions were not kept")
+	d, err = s.GetCurrent("testGUN", "targets")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("3"), d, "Returned data was not correct")
+	_, k, err := s.GetPendingKey("testGUN", "timestamp")
+	assert.Nil(t, err, "Expected nil error from GetPendingKey")
+	assert.Equal(t, []byte("5"), k, "Key data was wrong")
+	_, err = s.GetCurrent("other", "root")
+	assert.IsType(t, &ErrNotFound{}, err, "Deleted metadata was restored")
+	changes, err := s.GetChanges("other", 0, 10)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	assert.Len(t, changes, 1, "The changefeed was not kept")
+	assert.Equal(t, 3, changes[0].ID, "Change IDs were not kept")
+
+	// reaching the compaction size rewrites the journal after the commit
+	s.compactAt = s.size + 1
+	s.SetKey("other", "timestamp", data.ECDSAKey, []byte("6"))
+	journal, err := ioutil.ReadFile(s.path)
+	assert.Nil(t, err, "Could not read the journal")
+	assert.Equal(t, 3, bytes.Count(journal, []byte("\n")), "Expected a record per GUN and the changefeed")
+	assert.Equal(t, compactSize(s.size), s.compactAt, "The next compaction size was not set")
+}
+
+// TestFileDiscardsIncompleteTransaction checks a transaction cut short by a
+// crash is dropped when the store is opened, and doesn't prevent appending
+// new ones
+func TestFileDiscardsIncompleteTransaction(t *testing.T) {
+	s, dir := newTestFileStorage(t)
+	defer os.RemoveAll(dir)
+
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	assert.Nil(t, s.Close(), "Expected nil error from Close")
+	journal, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	assert.Nil(t, err, "Could not open the journal")
+	_, err = journal.Write([]byte(`{"gun":"testGUN","updates":[{"role":"root","vers`))
+	assert.Nil(t, err, "Could not write to the journal")
+	journal.Close()
+
+	s, err = NewFileStorage(s.path)
+	assert.Nil(t, err, "Could not reopen the store")
+	err = s.UpdateCurrent("testGUN", MetaUpdate{"root", 2, []byte("2")})
+	assert.Nil(t, err, "Expected nil error from UpdateCurrent")
+	assert.Nil(t, s.Close(), "Expected nil error from Close")
+
+	s, err = NewFileStorage(s.path)
+	assert.Nil(t, err, "Could not reopen the store")
+	defer s.Close()
+	d, err := s.GetCurrent("testGUN", "root")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("2"), d, "Returned data was not correct")
+}
+
+// TestFileCorruptJournal checks a store whose journal holds an invalid
+// complete record isn't opened
+func TestFileCorruptJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary-server-test-")
+	assert.Nil(t, err, "Could not create a temporary directory")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tuf.journal")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("{\"gun\":\n"), 0600))
+
+	_, err = NewFileStorage(path)
+	assert.NotNil(t, err, "Expected an error opening a corrupt journal")
+}
@@ -7,7 +7,14 @@ type MetaStore interface {
 	UpdateCurrent(gun string, update MetaUpdate) error
 	UpdateMany(gun string, updates []MetaUpdate) error
 	GetCurrent(gun, tufRole string) (data []byte, err error)
+	GetVersion(gun, tufRole string, version int) (data []byte, err error)
+	GetChecksum(gun, tufRole, checksum string) (data []byte, err error)
 	Delete(gun string) error
-	GetTimestampKey(gun string) (algorithm data.KeyAlgorithm, public []byte, err error)
-	SetTimestampKey(gun string, algorithm data.KeyAlgorithm, public []byte) error
+	GetKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error)
+	SetKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error
+	GetPendingKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error)
+	SetPendingKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error
+	ActivatePendingKey(gun, role string) error
+	PublishUpdates(gun, user string, updates []MetaUpdate) error
+	GetChanges(gun string, changeID, pageSize int) ([]Change, error)
 }
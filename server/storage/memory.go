@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/endophage/gotuf/data"
 )
@@ -14,8 +15,9 @@ type key struct {
 }
 
 type ver struct {
-	version int
-	data    []byte
+	version  int
+	checksum string
+	data     []byte
 }
 
 // MemStorage is really just designed for dev and testing. It is very
@@ -23,37 +25,69 @@ type ver struct {
 type MemStorage struct {
 	lock    sync.Mutex
 	tufMeta map[string][]*ver
-	tsKeys  map[string]*key
+	keys    map[string]*key
+	pending map[string]*key
+	changes []Change
 }
 
 // NewMemStorage instantiates a memStorage instance
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
 		tufMeta: make(map[string][]*ver),
-		tsKeys:  make(map[string]*key),
+		keys:    make(map[string]*key),
+		pending: make(map[string]*key),
 	}
 }
 
 // UpdateCurrent updates the meta data for a specific role
 func (st *MemStorage) UpdateCurrent(gun string, update MetaUpdate) error {
-	id := entryKey(gun, update.Role)
+	return st.UpdateMany(gun, []MetaUpdate{update})
+}
+
+// UpdateMany updates multiple TUF records. Nothing is stored if any of the
+// updates isn't newer than the stored record.
+func (st *MemStorage) UpdateMany(gun string, updates []MetaUpdate) error {
 	st.lock.Lock()
 	defer st.lock.Unlock()
-	if space, ok := st.tufMeta[id]; ok {
-		for _, v := range space {
-			if v.version >= update.Version {
-				return &ErrOldVersion{}
-			}
-		}
+	return st.updateMany(gun, updates)
+}
+
+// PublishUpdates updates multiple TUF records like UpdateMany, and adds an
+// entry to the changefeed for each of them, recording the user that
+// published them
+func (st *MemStorage) PublishUpdates(gun, user string, updates []MetaUpdate) error {
+	now := time.Now().UTC()
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if err := st.updateMany(gun, updates); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		st.changes = append(st.changes, Change{
+			ID:        len(st.changes) + 1,
+			GUN:       gun,
+			Role:      u.Role,
+			Version:   u.Version,
+			User:      user,
+			CreatedAt: now,
+		})
 	}
-	st.tufMeta[id] = append(st.tufMeta[id], &ver{version: update.Version, data: update.Data})
 	return nil
 }
 
-// UpdateMany updates multiple TUF records
-func (st *MemStorage) UpdateMany(gun string, updates []MetaUpdate) error {
+// updateMany stores the updates if they're all newer than the stored
+// records. It must be called with the lock held.
+func (st *MemStorage) updateMany(gun string, updates []MetaUpdate) error {
 	for _, u := range updates {
-		st.UpdateCurrent(gun, u)
+		for _, v := range st.tufMeta[entryKey(gun, u.Role)] {
+			if v.version >= u.Version {
+				return &ErrOldVersion{}
+			}
+		}
+	}
+	for _, u := range updates {
+		id := entryKey(gun, u.Role)
+		st.tufMeta[id] = append(st.tufMeta[id], &ver{version: u.Version, checksum: checksum(u.Data), data: u.Data})
 	}
 	return nil
 }
@@ -70,42 +104,125 @@ func (st *MemStorage) GetCurrent(gun, role string) (data []byte, err error) {
 	return space[len(st.tufMeta[id])-1].data, nil
 }
 
+// GetVersion returns the metadata for a given version of a role, under a GUN
+func (st *MemStorage) GetVersion(gun, role string, version int) (data []byte, err error) {
+	id := entryKey(gun, role)
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for _, v := range st.tufMeta[id] {
+		if v.version == version {
+			return v.data, nil
+		}
+	}
+	return nil, &ErrNotFound{}
+}
+
+// GetChecksum returns the metadata for a role, under a GUN, whose hex encoded
+// sha256 checksum matches the one given
+func (st *MemStorage) GetChecksum(gun, role, checksum string) (data []byte, err error) {
+	id := entryKey(gun, role)
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for _, v := range st.tufMeta[id] {
+		if v.checksum == checksum {
+			return v.data, nil
+		}
+	}
+	return nil, &ErrNotFound{}
+}
+
 // Delete delets all the metadata for a given GUN
 func (st *MemStorage) Delete(gun string) error {
 	st.lock.Lock()
 	defer st.lock.Unlock()
 	for k := range st.tufMeta {
-		if strings.HasPrefix(k, gun) {
+		if strings.HasPrefix(k, gun+".") {
 			delete(st.tufMeta, k)
 		}
 	}
 	return nil
 }
 
-// GetTimestampKey returns the public key material of the timestamp key of a given gun
-func (st *MemStorage) GetTimestampKey(gun string) (algorithm data.KeyAlgorithm, public []byte, err error) {
+// GetKey returns the public key material of the key for a role of a given gun
+func (st *MemStorage) GetKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
 	// no need for lock. It's ok to return nil if an update
 	// wasn't observed
-	k, ok := st.tsKeys[gun]
+	k, ok := st.keys[entryKey(gun, role)]
 	if !ok {
-		return "", nil, &ErrNoKey{gun: gun}
+		return "", nil, &ErrNoKey{gun: gun, role: role}
 	}
 
 	return k.algorithm, k.public, nil
 }
 
-// SetTimestampKey sets a Timestamp key under a gun
-func (st *MemStorage) SetTimestampKey(gun string, algorithm data.KeyAlgorithm, public []byte) error {
+// SetKey sets the key for a role under a gun
+func (st *MemStorage) SetKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
 	k := &key{algorithm: algorithm, public: public}
+	id := entryKey(gun, role)
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if _, ok := st.keys[id]; ok {
+		return &ErrKeyExists{gun: gun, role: role}
+	}
+	st.keys[id] = k
+	return nil
+}
+
+// GetPendingKey returns the public key material of the key that will
+// replace the key for a role of a given gun
+func (st *MemStorage) GetPendingKey(gun, role string) (algorithm data.KeyAlgorithm, public []byte, err error) {
 	st.lock.Lock()
 	defer st.lock.Unlock()
-	if _, ok := st.tsKeys[gun]; ok {
-		return &ErrTimestampKeyExists{gun: gun}
+	k, ok := st.pending[entryKey(gun, role)]
+	if !ok {
+		return "", nil, &ErrNoKey{gun: gun, role: role}
 	}
-	st.tsKeys[gun] = k
+
+	return k.algorithm, k.public, nil
+}
+
+// SetPendingKey sets the key that will replace the key for a role under a
+// gun, overwriting any previous pending key
+func (st *MemStorage) SetPendingKey(gun, role string, algorithm data.KeyAlgorithm, public []byte) error {
+	k := &key{algorithm: algorithm, public: public}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.pending[entryKey(gun, role)] = k
 	return nil
 }
 
+// ActivatePendingKey replaces the key for a role under a gun with the
+// pending key
+func (st *MemStorage) ActivatePendingKey(gun, role string) error {
+	id := entryKey(gun, role)
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	k, ok := st.pending[id]
+	if !ok {
+		return &ErrNoKey{gun: gun, role: role}
+	}
+	st.keys[id] = k
+	delete(st.pending, id)
+	return nil
+}
+
+// GetChanges returns up to pageSize changes published to a gun, starting
+// after the change with ID changeID
+func (st *MemStorage) GetChanges(gun string, changeID, pageSize int) ([]Change, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	changes := make([]Change, 0, pageSize)
+	for _, c := range st.changes {
+		if len(changes) >= pageSize {
+			break
+		}
+		if c.ID > changeID && c.GUN == gun {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
 func entryKey(gun, role string) string {
 	return fmt.Sprintf("%s.%s", gun, role)
 }
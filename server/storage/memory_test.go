@@ -31,6 +31,32 @@ func TestGetCurrent(t *testing.T) {
 	assert.Equal(t, []byte("test"), d, "Data was incorrect")
 }
 
+func TestGetVersion(t *testing.T) {
+	s := NewMemStorage()
+	s.UpdateCurrent("gun", MetaUpdate{"role", 1, []byte("test")})
+	s.UpdateCurrent("gun", MetaUpdate{"role", 2, []byte("test2")})
+
+	d, err := s.GetVersion("gun", "role", 1)
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, []byte("test"), d, "Data was incorrect")
+
+	_, err = s.GetVersion("gun", "role", 3)
+	assert.IsType(t, &ErrNotFound{}, err, "Expected error to be ErrNotFound")
+}
+
+func TestGetChecksum(t *testing.T) {
+	s := NewMemStorage()
+	s.UpdateCurrent("gun", MetaUpdate{"role", 1, []byte("test")})
+	s.UpdateCurrent("gun", MetaUpdate{"role", 2, []byte("test2")})
+
+	d, err := s.GetChecksum("gun", "role", checksum([]byte("test")))
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, []byte("test"), d, "Data was incorrect")
+
+	_, err = s.GetChecksum("gun", "other", checksum([]byte("test")))
+	assert.IsType(t, &ErrNotFound{}, err, "Expected error to be ErrNotFound")
+}
+
 func TestDelete(t *testing.T) {
 	s := NewMemStorage()
 	s.UpdateCurrent("gun", MetaUpdate{"role", 1, []byte("test")})
@@ -41,29 +67,72 @@ func TestDelete(t *testing.T) {
 	assert.False(t, ok, "Found gun in store, should have been deleted")
 }
 
-func TestGetTimestampKey(t *testing.T) {
+func TestGetKey(t *testing.T) {
 	s := NewMemStorage()
 
-	//_, _, err := s.GetTimestampKey("gun")
-	//assert.IsType(t, &ErrNoKey{}, err, "Expected err to be ErrNoKey")
+	_, _, err := s.GetKey("gun", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected err to be ErrNoKey")
 
-	s.SetTimestampKey("gun", data.RSAKey, []byte("test"))
+	s.SetKey("gun", "timestamp", data.RSAKey, []byte("test"))
 
-	c, k, err := s.GetTimestampKey("gun")
+	c, k, err := s.GetKey("gun", "timestamp")
 	assert.Nil(t, err, "Expected error to be nil")
 	assert.Equal(t, data.RSAKey, c, "Expected algorithm rsa, received %s", c)
 	assert.Equal(t, []byte("test"), k, "Key data was wrong")
 }
 
-func TestSetTimestampKey(t *testing.T) {
+func TestSetKey(t *testing.T) {
 	s := NewMemStorage()
-	s.SetTimestampKey("gun", data.RSAKey, []byte("test"))
+	s.SetKey("gun", "timestamp", data.RSAKey, []byte("test"))
 
-	err := s.SetTimestampKey("gun", data.RSAKey, []byte("test2"))
-	assert.IsType(t, &ErrTimestampKeyExists{}, err, "Expected err to be ErrTimestampKeyExists")
+	err := s.SetKey("gun", "timestamp", data.RSAKey, []byte("test2"))
+	assert.IsType(t, &ErrKeyExists{}, err, "Expected err to be ErrKeyExists")
 
-	k := s.tsKeys["gun"]
+	// keys for other roles are independent
+	err = s.SetKey("gun", "snapshot", data.RSAKey, []byte("test3"))
+	assert.Nil(t, err, "Expected error to be nil")
+
+	k := s.keys[entryKey("gun", "timestamp")]
 	assert.Equal(t, data.RSAKey, k.algorithm, "Expected algorithm to be rsa, received %s", k.algorithm)
 	assert.Equal(t, []byte("test"), k.public, "Public key did not match expected")
 
 }
+
+func TestPendingKey(t *testing.T) {
+	s := NewMemStorage()
+	s.SetKey("gun", "timestamp", data.RSAKey, []byte("test"))
+
+	_, _, err := s.GetPendingKey("gun", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected err to be ErrNoKey")
+	err = s.ActivatePendingKey("gun", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected err to be ErrNoKey")
+
+	// a pending key can be replaced before it's activated
+	s.SetPendingKey("gun", "timestamp", data.RSAKey, []byte("test2"))
+	s.SetPendingKey("gun", "timestamp", data.ECDSAKey, []byte("test3"))
+	c, k, err := s.GetPendingKey("gun", "timestamp")
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, data.ECDSAKey, c, "Expected algorithm ecdsa, received %s", c)
+	assert.Equal(t, []byte("test3"), k, "Key data was wrong")
+
+	// the current key is unchanged until the pending key is activated
+	_, k, err = s.GetKey("gun", "timestamp")
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, []byte("test"), k, "Key data was wrong")
+
+	err = s.ActivatePendingKey("gun", "timestamp")
+	assert.Nil(t, err, "Expected error to be nil")
+	c, k, err = s.GetKey("gun", "timestamp")
+	assert.Nil(t, err, "Expected error to be nil")
+	assert.Equal(t, data.ECDSAKey, c, "Expected algorithm ecdsa, received %s", c)
+	assert.Equal(t, []byte("test3"), k, "Key data was wrong")
+
+	_, _, err = s.GetPendingKey("gun", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected err to be ErrNoKey")
+}
+
+func TestMemStorage(t *testing.T) {
+	testMetaStore(t, func() (MetaStore, func()) {
+		return NewMemStorage(), func() {}
+	})
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/endophage/gotuf/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMetaStore checks the behaviour shared by all the MetaStore
+// implementations. Each check is run against a new empty store returned by
+// newStore, along with a function releasing it.
+func testMetaStore(t *testing.T, newStore func() (MetaStore, func())) {
+	checks := []func(*testing.T, MetaStore){
+		testMetaStoreUpdateCurrent,
+		testMetaStoreUpdateMany,
+		testMetaStoreGetVersionAndChecksum,
+		testMetaStoreDelete,
+		testMetaStoreKeys,
+		testMetaStorePublishUpdates,
+	}
+	for _, check := range checks {
+		s, release := newStore()
+		check(t, s)
+		release()
+	}
+}
+
+func testMetaStoreUpdateCurrent(t *testing.T, s MetaStore) {
+	_, err := s.GetCurrent("testGUN", "root")
+	assert.IsType(t, &ErrNotFound{}, err, "Expected ErrNotFound from GetCurrent")
+
+	err = s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	assert.Nil(t, err, "UpdateCurrent errored unexpectedly: %v", err)
+	err = s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("2")})
+	assert.IsType(t, &ErrOldVersion{}, err, "Expected ErrOldVersion error type, got: %v", err)
+	err = s.UpdateCurrent("testGUN", MetaUpdate{"root", 2, []byte("3")})
+	assert.Nil(t, err, "UpdateCurrent errored unexpectedly: %v", err)
+
+	d, err := s.GetCurrent("testGUN", "root")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("3"), d, "Returned data was not correct")
+}
+
+func testMetaStoreUpdateMany(t *testing.T, s MetaStore) {
+	err := s.UpdateMany("testGUN", []MetaUpdate{
+		{"root", 1, []byte("1")},
+		{"targets", 1, []byte("2")},
+	})
+	assert.Nil(t, err, "UpdateMany errored unexpectedly: %v", err)
+
+	// nothing is stored when one of the updates is old
+	err = s.UpdateMany("testGUN", []MetaUpdate{
+		{"targets", 2, []byte("3")},
+		{"root", 1, []byte("4")},
+	})
+	assert.IsType(t, &ErrOldVersion{}, err, "UpdateMany returned wrong error type")
+	d, err := s.GetCurrent("testGUN", "targets")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("2"), d, "Failed update was partially stored")
+}
+
+func testMetaStoreGetVersionAndChecksum(t *testing.T, s MetaStore) {
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 2, []byte("2")})
+
+	d, err := s.GetVersion("testGUN", "root", 1)
+	assert.Nil(t, err, "Expected nil error from GetVersion")
+	assert.Equal(t, []byte("1"), d, "Returned data was not correct")
+	_, err = s.GetVersion("testGUN", "root", 3)
+	assert.IsType(t, &ErrNotFound{}, err, "Expected ErrNotFound from GetVersion")
+
+	d, err = s.GetChecksum("testGUN", "root", checksum([]byte("2")))
+	assert.Nil(t, err, "Expected nil error from GetChecksum")
+	assert.Equal(t, []byte("2"), d, "Returned data was not correct")
+	_, err = s.GetChecksum("testGUN", "targets", checksum([]byte("2")))
+	assert.IsType(t, &ErrNotFound{}, err, "Expected ErrNotFound from GetChecksum")
+}
+
+func testMetaStoreDelete(t *testing.T, s MetaStore) {
+	s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("1")})
+	s.UpdateCurrent("testGUN2", MetaUpdate{"root", 1, []byte("1")})
+	err := s.Delete("testGUN")
+	assert.Nil(t, err, "Expected nil error from Delete")
+
+	_, err = s.GetCurrent("testGUN", "root")
+	assert.IsType(t, &ErrNotFound{}, err, "Expected ErrNotFound from GetCurrent")
+	_, err = s.GetCurrent("testGUN2", "root")
+	assert.Nil(t, err, "Other GUNs should not be deleted")
+
+	// the GUN can be published again from scratch
+	err = s.UpdateCurrent("testGUN", MetaUpdate{"root", 1, []byte("2")})
+	assert.Nil(t, err, "Expected nil error from UpdateCurrent")
+}
+
+func testMetaStoreKeys(t *testing.T, s MetaStore) {
+	_, _, err := s.GetKey("testGUN", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected ErrNoKey from GetKey")
+
+	err = s.SetKey("testGUN", "timestamp", data.RSAKey, []byte("1"))
+	assert.Nil(t, err, "Expected nil error from SetKey")
+	err = s.SetKey("testGUN", "timestamp", data.RSAKey, []byte("2"))
+	assert.IsType(t, &ErrKeyExists{}, err, "Expected ErrKeyExists from SetKey")
+
+	err = s.ActivatePendingKey("testGUN", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected ErrNoKey from ActivatePendingKey")
+
+	s.SetPendingKey("testGUN", "timestamp", data.RSAKey, []byte("2"))
+	s.SetPendingKey("testGUN", "timestamp", data.ECDSAKey, []byte("3"))
+	_, k, err := s.GetKey("testGUN", "timestamp")
+	assert.Nil(t, err, "Expected nil error from GetKey")
+	assert.Equal(t, []byte("1"), k, "Key changed before the pending key was activated")
+
+	err = s.ActivatePendingKey("testGUN", "timestamp")
+	assert.Nil(t, err, "Expected nil error from ActivatePendingKey")
+	c, k, err := s.GetKey("testGUN", "timestamp")
+	assert.Nil(t, err, "Expected nil error from GetKey")
+	assert.Equal(t, data.ECDSAKey, c, "Expected algorithm ecdsa, received %s", c)
+	assert.Equal(t, []byte("3"), k, "Key data was wrong")
+	_, _, err = s.GetPendingKey("testGUN", "timestamp")
+	assert.IsType(t, &ErrNoKey{}, err, "Expected ErrNoKey from GetPendingKey")
+}
+
+func testMetaStorePublishUpdates(t *testing.T, s MetaStore) {
+	err := s.PublishUpdates("testGUN", "alice", []MetaUpdate{{"root", 1, []byte("1")}, {"targets", 1, []byte("2")}})
+	assert.Nil(t, err, "Expected nil error from PublishUpdates")
+	err = s.PublishUpdates("other", "bob", []MetaUpdate{{"targets", 1, []byte("3")}})
+	assert.Nil(t, err, "Expected nil error from PublishUpdates")
+	err = s.PublishUpdates("testGUN", "bob", []MetaUpdate{{"targets", 2, []byte("4")}})
+	assert.Nil(t, err, "Expected nil error from PublishUpdates")
+
+	d, err := s.GetCurrent("testGUN", "targets")
+	assert.Nil(t, err, "Expected nil error from GetCurrent")
+	assert.Equal(t, []byte("4"), d, "Published data was not stored")
+
+	// rejected updates aren't recorded
+	err = s.PublishUpdates("testGUN", "bob", []MetaUpdate{{"targets", 3, []byte("5")}, {"root", 1, []byte("6")}})
+	assert.IsType(t, &ErrOldVersion{}, err, "Expected ErrOldVersion from PublishUpdates")
+
+	changes, err := s.GetChanges("testGUN", 0, 10)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	if !assert.Len(t, changes, 3, "Expected the changes published to the GUN") {
+		return
+	}
+	assert.Equal(t, "alice", changes[0].User, "User was incorrect")
+	assert.Equal(t, "root", changes[0].Role, "Role was incorrect")
+	assert.Equal(t, "bob", changes[2].User, "User was incorrect")
+	assert.Equal(t, 2, changes[2].Version, "Version was incorrect")
+	assert.True(t, changes[1].ID < changes[2].ID, "Changes were not in ID order")
+
+	changes, err = s.GetChanges("testGUN", changes[1].ID, 10)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	assert.Len(t, changes, 1, "Expected the changes after the given ID")
+
+	changes, err = s.GetChanges("testGUN", 0, 1)
+	assert.Nil(t, err, "Expected nil error from GetChanges")
+	assert.Len(t, changes, 1, "Page size was not respected")
+}
@@ -1,8 +1,32 @@
 package storage
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
 // MetaUpdate packages up the fields required to update a TUF record
 type MetaUpdate struct {
 	Role    string
 	Version int
 	Data    []byte
 }
+
+// Change is an entry in the changefeed, recording a single role update
+// that was published to a GUN and who published it
+type Change struct {
+	ID        int       `json:"id"`
+	GUN       string    `json:"gun"`
+	Role      string    `json:"role"`
+	Version   int       `json:"version"`
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// checksum returns the hex encoded sha256 of the data, which is used to
+// lookup a specific version of a TUF record by its content
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
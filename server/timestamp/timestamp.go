@@ -1,6 +1,8 @@
 package timestamp
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"time"
 
@@ -10,45 +12,39 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary/server/storage"
+	"github.com/docker/notary/signer"
 )
 
-// GetOrCreateTimestampKey returns the timestamp key for the gun. It uses the store to
-// lookup an existing timestamp key and the crypto to generate a new one if none is
-// found. It attempts to handle the race condition that may occur if 2 servers try to
-// create the key at the same time by simply querying the store a second time if it
-// receives a conflict when writing.
-func GetOrCreateTimestampKey(gun string, store storage.MetaStore, crypto signed.CryptoService, fallBackAlgorithm data.KeyAlgorithm) (*data.TUFKey, error) {
-	keyAlgorithm, public, err := store.GetTimestampKey(gun)
-	if err == nil {
-		return data.NewTUFKey(keyAlgorithm, public, nil), nil
+// RotateTimestampKey creates a new timestamp key for the gun and records it as
+// pending. Timestamps continue to be signed with the current key until a root
+// listing the new key has been published.
+func RotateTimestampKey(gun string, store storage.MetaStore, crypto signed.CryptoService, algorithm data.KeyAlgorithm) (*data.TUFKey, error) {
+	// only an existing key can be rotated
+	_, _, err := store.GetKey(gun, "timestamp")
+	if err != nil {
+		return nil, err
 	}
-
-	if _, ok := err.(*storage.ErrNoKey); ok {
-		key, err := crypto.Create("timestamp", fallBackAlgorithm)
-		if err != nil {
-			return nil, err
-		}
-		err = store.SetTimestampKey(gun, key.Algorithm(), key.Public())
-		if err == nil {
-			return &key.TUFKey, nil
-		}
-
-		if _, ok := err.(*storage.ErrTimestampKeyExists); ok {
-			keyAlgorithm, public, err = store.GetTimestampKey(gun)
-			if err != nil {
-				return nil, err
-			}
-			return data.NewTUFKey(keyAlgorithm, public, nil), nil
-		}
+	key, err := signer.CreateKeyForGUN(crypto, gun, "timestamp", algorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = store.SetPendingKey(gun, "timestamp", key.Algorithm(), key.Public())
+	if err != nil {
 		return nil, err
 	}
-	return nil, err
+	return &key.TUFKey, nil
 }
 
 // GetOrCreateTimestamp returns the current timestamp for the gun. This may mean
 // a new timestamp is generated either because none exists, or because the current
-// one has expired. Once generated, the timestamp is saved in the store.
+// one has expired, doesn't reference the current snapshot, or was signed with a
+// timestamp key that has since been rotated. Once generated, the timestamp is
+// saved in the store.
 func GetOrCreateTimestamp(gun string, store storage.MetaStore, cryptoService signed.CryptoService) ([]byte, error) {
+	err := activatePendingKey(gun, store)
+	if err != nil {
+		return nil, err
+	}
 	d, err := store.GetCurrent(gun, "timestamp")
 	if err != nil {
 		if _, ok := err.(*storage.ErrNotFound); !ok {
@@ -65,7 +61,15 @@ func GetOrCreateTimestamp(gun string, store storage.MetaStore, cryptoService sig
 			logrus.Error("Failed to unmarshal existing timestamp")
 			return nil, err
 		}
-		if !timestampExpired(ts) {
+		current, err := snapshotCurrent(gun, ts, store)
+		if err != nil {
+			return nil, err
+		}
+		signedByCurrent, err := keyCurrent(gun, ts, store)
+		if err != nil {
+			return nil, err
+		}
+		if !timestampExpired(ts) && current && signedByCurrent {
 			return d, nil
 		}
 	}
@@ -91,12 +95,80 @@ func timestampExpired(ts *data.SignedTimestamp) bool {
 	return time.Now().After(ts.Signed.Expires)
 }
 
+// snapshotCurrent checks whether the timestamp references the snapshot
+// currently stored for the gun.
+func snapshotCurrent(gun string, ts *data.SignedTimestamp, store storage.MetaStore) (bool, error) {
+	snapshot, err := store.GetCurrent(gun, "snapshot")
+	if err != nil {
+		return false, err
+	}
+	meta, ok := ts.Signed.Meta["snapshot"]
+	if !ok {
+		return false, nil
+	}
+	checksum := sha256.Sum256(snapshot)
+	return bytes.Equal(meta.Hashes["sha256"], checksum[:]), nil
+}
+
+// keyCurrent checks whether the timestamp is signed with the current
+// timestamp key for the gun.
+func keyCurrent(gun string, ts *data.SignedTimestamp, store storage.MetaStore) (bool, error) {
+	algorithm, public, err := store.GetKey(gun, "timestamp")
+	if err != nil {
+		return false, err
+	}
+	keyID := data.NewPublicKey(algorithm, public).ID()
+	for _, sig := range ts.Signatures {
+		if sig.KeyID == keyID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// activatePendingKey switches timestamp signing to the pending timestamp key
+// for the gun, if there is one and the current root lists it.
+func activatePendingKey(gun string, store storage.MetaStore) error {
+	algorithm, public, err := store.GetPendingKey(gun, "timestamp")
+	if err != nil {
+		if _, ok := err.(*storage.ErrNoKey); ok {
+			return nil
+		}
+		return err
+	}
+	d, err := store.GetCurrent(gun, "root")
+	if err != nil {
+		if _, ok := err.(*storage.ErrNotFound); ok {
+			return nil
+		}
+		return err
+	}
+	root := &data.SignedRoot{}
+	err = json.Unmarshal(d, root)
+	if err != nil {
+		logrus.Error("Failed to unmarshal existing root")
+		return err
+	}
+	role, ok := root.Signed.Roles["timestamp"]
+	if !ok {
+		return nil
+	}
+	keyID := data.NewPublicKey(algorithm, public).ID()
+	for _, id := range role.KeyIDs {
+		if id == keyID {
+			logrus.Debugf("switching to the new timestamp key %s for %s", keyID, gun)
+			return store.ActivatePendingKey(gun, "timestamp")
+		}
+	}
+	return nil
+}
+
 // createTimestamp creates a new timestamp. If a prev timestamp is provided, it
 // is assumed this is the immediately previous one, and the new one will have a
 // version number one higher than prev. The store is used to lookup the current
 // snapshot, this function does not save the newly generated timestamp.
 func createTimestamp(gun string, prev *data.SignedTimestamp, store storage.MetaStore, cryptoService signed.CryptoService) (*data.Signed, int, error) {
-	algorithm, public, err := store.GetTimestampKey(gun)
+	algorithm, public, err := store.GetKey(gun, "timestamp")
 	if err != nil {
 		// owner of gun must have generated a timestamp key otherwise
 		// we won't proceed with generating everything.
@@ -117,6 +189,13 @@ func createTimestamp(gun string, prev *data.SignedTimestamp, store storage.MetaS
 	if err != nil {
 		return nil, 0, err
 	}
+	// describe the snapshot exactly as it is stored and served, rather than
+	// as re-encoded by NewTimestamp
+	meta, err := data.NewFileMeta(bytes.NewReader(snapshot), "sha256")
+	if err != nil {
+		return nil, 0, err
+	}
+	ts.Signed.Meta["snapshot"] = meta
 	if prev != nil {
 		ts.Signed.Version = prev.Signed.Version + 1
 	}
@@ -9,6 +9,7 @@ import (
 	"github.com/endophage/gotuf/signed"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/docker/notary/server/keys"
 	"github.com/docker/notary/server/storage"
 )
 
@@ -29,23 +30,23 @@ func TestTimestampExpired(t *testing.T) {
 	assert.False(t, timestampExpired(ts), "Timestamp should NOT have expired")
 }
 
-func TestGetTimestampKey(t *testing.T) {
+func TestGetTimestamp(t *testing.T) {
 	store := storage.NewMemStorage()
 	crypto := signed.NewEd25519()
-	k, err := GetOrCreateTimestampKey("gun", store, crypto, data.ED25519Key)
-	assert.Nil(t, err, "Expected nil error")
-	assert.NotNil(t, k, "Key should not be nil")
 
-	k2, err := GetOrCreateTimestampKey("gun", store, crypto, data.ED25519Key)
+	snapshot := &data.SignedSnapshot{}
+	snapJSON, _ := json.Marshal(snapshot)
 
-	assert.Nil(t, err, "Expected nil error")
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "snapshot", Version: 0, Data: snapJSON})
+	// create a key to be used by GetTimestamp
+	_, err := keys.GetOrCreateKey("gun", "timestamp", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "GetTimestampKey errored")
 
-	// trying to get the same key again should return the same value
-	assert.Equal(t, k, k2, "Did not receive same key when attempting to recreate.")
-	assert.NotNil(t, k2, "Key should not be nil")
+	_, err = GetOrCreateTimestamp("gun", store, crypto)
+	assert.Nil(t, err, "GetTimestamp errored")
 }
 
-func TestGetTimestamp(t *testing.T) {
+func TestGetTimestampNewSnapshot(t *testing.T) {
 	store := storage.NewMemStorage()
 	crypto := signed.NewEd25519()
 
@@ -54,9 +55,72 @@ func TestGetTimestamp(t *testing.T) {
 
 	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "snapshot", Version: 0, Data: snapJSON})
 	// create a key to be used by GetTimestamp
-	_, err := GetOrCreateTimestampKey("gun", store, crypto, data.ED25519Key)
+	_, err := keys.GetOrCreateKey("gun", "timestamp", store, crypto, data.ED25519Key)
 	assert.Nil(t, err, "GetTimestampKey errored")
 
-	_, err = GetOrCreateTimestamp("gun", store, crypto)
+	ts1, err := GetOrCreateTimestamp("gun", store, crypto)
+	assert.Nil(t, err, "GetTimestamp errored")
+
+	// the same timestamp is served until the snapshot changes
+	ts2, err := GetOrCreateTimestamp("gun", store, crypto)
 	assert.Nil(t, err, "GetTimestamp errored")
+	assert.Equal(t, ts1, ts2, "Timestamp was regenerated for the same snapshot")
+
+	snapshot.Signed.Version = 1
+	snapJSON, _ = json.Marshal(snapshot)
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "snapshot", Version: 1, Data: snapJSON})
+
+	ts3, err := GetOrCreateTimestamp("gun", store, crypto)
+	assert.Nil(t, err, "GetTimestamp errored")
+	assert.NotEqual(t, ts1, ts3, "Timestamp was not regenerated for the new snapshot")
+
+	sgnd := &data.SignedTimestamp{}
+	assert.Nil(t, json.Unmarshal(ts3, sgnd))
+	assert.Equal(t, 2, sgnd.Signed.Version, "Timestamp version was not incremented")
+}
+
+func TestRotateTimestampKey(t *testing.T) {
+	store := storage.NewMemStorage()
+	crypto := signed.NewEd25519()
+
+	_, err := RotateTimestampKey("gun", store, crypto, data.ED25519Key)
+	assert.IsType(t, &storage.ErrNoKey{}, err, "Rotated a key that doesn't exist")
+
+	snapshot := &data.SignedSnapshot{}
+	snapJSON, _ := json.Marshal(snapshot)
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "snapshot", Version: 0, Data: snapJSON})
+	oldKey, err := keys.GetOrCreateKey("gun", "timestamp", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "GetTimestampKey errored")
+
+	newKey, err := RotateTimestampKey("gun", store, crypto, data.ED25519Key)
+	assert.Nil(t, err, "RotateTimestampKey errored")
+	newKeyID := data.NewPublicKey(newKey.Algorithm(), newKey.Public()).ID()
+
+	// the current key is used until a root listing the new key is published
+	ts := &data.SignedTimestamp{}
+	d, err := GetOrCreateTimestamp("gun", store, crypto)
+	assert.Nil(t, err, "GetTimestamp errored")
+	assert.Nil(t, json.Unmarshal(d, ts))
+	assert.Equal(t, data.NewPublicKey(oldKey.Algorithm(), oldKey.Public()).ID(), ts.Signatures[0].KeyID)
+
+	root := &data.SignedRoot{
+		Signed: data.Root{
+			Roles: map[string]*data.RootRole{
+				"timestamp": {KeyIDs: []string{newKeyID}, Threshold: 1},
+			},
+		},
+	}
+	rootJSON, _ := json.Marshal(root)
+	store.UpdateCurrent("gun", storage.MetaUpdate{Role: "root", Version: 1, Data: rootJSON})
+
+	d, err = GetOrCreateTimestamp("gun", store, crypto)
+	assert.Nil(t, err, "GetTimestamp errored")
+	assert.Nil(t, json.Unmarshal(d, ts))
+	assert.Equal(t, newKeyID, ts.Signatures[0].KeyID, "Timestamp was not signed with the new key")
+
+	algorithm, public, err := store.GetKey("gun", "timestamp")
+	assert.Nil(t, err)
+	assert.Equal(t, newKeyID, data.NewPublicKey(algorithm, public).ID(), "New key was not activated")
+	_, _, err = store.GetPendingKey("gun", "timestamp")
+	assert.IsType(t, &storage.ErrNoKey{}, err, "Pending key was not cleared")
 }
@@ -14,12 +14,18 @@ import (
 
 // Handlers sets up all the handers for the routes, injecting a specific SigningService object for them to use
 func Handlers(sigServices signer.SigningServiceIndex) *mux.Router {
+	return AuthorizedHandlers(sigServices, nil)
+}
+
+// AuthorizedHandlers sets up the same routes as Handlers, only serving the
+// clients the authorizer allows to call the matching RPCs
+func AuthorizedHandlers(sigServices signer.SigningServiceIndex, authz *ClientAuthorizer) *mux.Router {
 	r := mux.NewRouter()
 
-	r.Methods("GET").Path("/{ID}").Handler(KeyInfo(sigServices))
-	r.Methods("POST").Path("/new/{Algorithm}").Handler(CreateKey(sigServices))
-	r.Methods("POST").Path("/delete").Handler(DeleteKey(sigServices))
-	r.Methods("POST").Path("/sign").Handler(Sign(sigServices))
+	r.Methods("GET").Path("/{ID}").Handler(authz.Handler("GetKeyInfo", KeyInfo(sigServices)))
+	r.Methods("POST").Path("/new/{Algorithm}").Handler(authz.Handler("CreateKey", CreateKey(sigServices)))
+	r.Methods("POST").Path("/delete").Handler(authz.Handler("DeleteKey", DeleteKey(sigServices)))
+	r.Methods("POST").Path("/sign").Handler(authz.Handler("Sign", Sign(sigServices)))
 	return r
 }
 
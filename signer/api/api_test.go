@@ -32,8 +32,17 @@ var (
 	signBaseURL        string
 )
 
+// SetupHSMEnv logs into the first slot of the SoftHSM library, with the PIN
+// 1234. The tests using it are skipped if the library isn't installed; its
+// path can be set with the SOFTHSM2_LIB environment variable. The token must
+// be initialized, i.e. with:
+//
+//	softhsm2-util --init-token --slot 0 --label test_token --pin 1234 --so-pin 1234
 func SetupHSMEnv(t *testing.T) (*pkcs11.Ctx, pkcs11.SessionHandle) {
 	var libPath = "/usr/local/lib/softhsm/libsofthsm2.so"
+	if env := os.Getenv("SOFTHSM2_LIB"); env != "" {
+		libPath = env
+	}
 	if _, err := os.Stat(libPath); err != nil {
 		t.Skipf("Skipping test. Library path: %s does not exist", libPath)
 	}
@@ -168,7 +177,8 @@ func TestHSMCreateKeyHandler(t *testing.T) {
 
 	// We associate both key types with this signing service to bypass the
 	// ID -> keyType logic in the tests
-	sigService := api.NewRSASigningService(ctx, session)
+	sigService, err := api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
 	setup(signer.SigningServiceIndex{data.ED25519Key: sigService, data.RSAKey: sigService})
 
 	createKeyURL := fmt.Sprintf("%s/%s", createKeyBaseURL, data.RSAKey)
@@ -222,7 +232,8 @@ func TestHSMSignHandler(t *testing.T) {
 
 	// We associate both key types with this signing service to bypass the
 	// ID -> keyType logic in the tests
-	sigService := api.NewRSASigningService(ctx, session)
+	sigService, err := api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
 	setup(signer.SigningServiceIndex{data.ED25519Key: sigService, data.RSAKey: sigService})
 
 	key, _ := sigService.CreateKey()
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// handshakeTimeout bounds the TLS handshake of the gRPC connections, so
+// clients can't hold connections open without authenticating
+const handshakeTimeout = 10 * time.Second
+
+// rpcNames are the RPCs an allow-list may restrict, which are also used for
+// the HTTP endpoints doing the same operations
+var rpcNames = map[string]bool{
+	"CreateKey":  true,
+	"DeleteKey":  true,
+	"GetKeyInfo": true,
+	"ListKeys":   true,
+	"Sign":       true,
+}
+
+// ErrClientNotAllowed is returned when a client certificate isn't allowed to
+// call an RPC
+var ErrClientNotAllowed = errors.New("notary-signer: client is not allowed to call this RPC")
+
+// ClientAuthorizer restricts the RPCs clients may call, given the identities
+// in their TLS certificates: the subject common name and the DNS names. RPCs
+// missing from the allow-list may be called by any client with a certificate
+// the signer trusts. A nil ClientAuthorizer allows everything.
+type ClientAuthorizer struct {
+	allowList map[string]map[string]bool
+}
+
+// NewClientAuthorizer returns a ClientAuthorizer for an allow-list mapping
+// RPC names, i.e. "Sign", to the identities allowed to call them
+func NewClientAuthorizer(allowList map[string][]string) (*ClientAuthorizer, error) {
+	a := &ClientAuthorizer{allowList: make(map[string]map[string]bool)}
+	for rpc, identities := range allowList {
+		if !rpcNames[rpc] {
+			return nil, fmt.Errorf("notary-signer: unknown RPC %s in the allow-list", rpc)
+		}
+		a.allowList[rpc] = make(map[string]bool)
+		for _, id := range identities {
+			a.allowList[rpc][id] = true
+		}
+	}
+	return a, nil
+}
+
+// Authorize returns ErrClientNotAllowed if the client with the certificate
+// may not call the RPC
+func (a *ClientAuthorizer) Authorize(cert *x509.Certificate, rpc string) error {
+	if a == nil {
+		return nil
+	}
+	allowed, ok := a.allowList[rpc]
+	if !ok {
+		return nil
+	}
+	if cert == nil {
+		return ErrClientNotAllowed
+	}
+	if allowed[cert.Subject.CommonName] {
+		return nil
+	}
+	for _, name := range cert.DNSNames {
+		if allowed[name] {
+			return nil
+		}
+	}
+	return ErrClientNotAllowed
+}
+
+// Handler returns a handler serving the requests of the clients allowed to
+// call the RPC with h, and refusing the others with a 403
+func (a *ClientAuthorizer) Handler(rpc string, h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cert *x509.Certificate
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			cert = r.TLS.PeerCertificates[0]
+		}
+		if err := a.Authorize(cert, rpc); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeAuthorized serves the KeyManagement and Signer gRPC services on the
+// connections accepted by lis, which must be a TLS listener requiring client
+// certificates. The RPCs are only run for the clients the authorizer allows.
+// As grpc doesn't give the handlers access to the connection of a request,
+// each connection is served by its own grpc.Server, which knows its client.
+// A grpc.Server costs a few goroutines and buffers per connection on top of
+// the connection's own, which is cheap for the long-lived connections of
+// notary-servers, but the number of connections served at once is bounded
+// by maxConns: once reached, new connections wait to be accepted until
+// another one is closed.
+func ServeAuthorized(lis net.Listener, kms pb.KeyManagementServer, ss pb.SignerServer, authz *ClientAuthorizer, maxConns int) error {
+	if maxConns < 1 {
+		return fmt.Errorf("notary-signer: invalid maximum number of connections: %d", maxConns)
+	}
+	slots := make(chan struct{}, maxConns)
+	for {
+		slots <- struct{}{}
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer func() { <-slots }()
+			serveAuthorizedConn(conn, kms, ss, authz)
+		}()
+	}
+}
+
+func serveAuthorizedConn(conn net.Conn, kms pb.KeyManagementServer, ss pb.SignerServer, authz *ClientAuthorizer) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		log.Printf("[Notary-signer RPC Server] : Refusing a connection from %s without TLS", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("[Notary-signer RPC Server] : TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	var cert *x509.Certificate
+	if state := tlsConn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		cert = state.PeerCertificates[0]
+	}
+
+	server := grpc.NewServer()
+	pb.RegisterKeyManagementServer(server, &authorizedKeyManagementServer{kms: kms, authz: authz, cert: cert})
+	pb.RegisterSignerServer(server, &authorizedSignerServer{ss: ss, authz: authz, cert: cert})
+	server.Serve(newConnListener(conn))
+}
+
+// authorizedKeyManagementServer runs the RPCs of a client on a
+// KeyManagementServer if the authorizer allows it
+type authorizedKeyManagementServer struct {
+	kms   pb.KeyManagementServer
+	authz *ClientAuthorizer
+	cert  *x509.Certificate
+}
+
+func (s *authorizedKeyManagementServer) CreateKey(ctx context.Context, req *pb.CreateKeyRequest) (*pb.PublicKey, error) {
+	if err := authorizeRPC(s.authz, s.cert, "CreateKey"); err != nil {
+		return nil, err
+	}
+	return s.kms.CreateKey(ctx, req)
+}
+
+func (s *authorizedKeyManagementServer) DeleteKey(ctx context.Context, keyID *pb.KeyID) (*pb.Void, error) {
+	if err := authorizeRPC(s.authz, s.cert, "DeleteKey"); err != nil {
+		return nil, err
+	}
+	return s.kms.DeleteKey(ctx, keyID)
+}
+
+func (s *authorizedKeyManagementServer) GetKeyInfo(ctx context.Context, keyID *pb.KeyID) (*pb.PublicKey, error) {
+	if err := authorizeRPC(s.authz, s.cert, "GetKeyInfo"); err != nil {
+		return nil, err
+	}
+	return s.kms.GetKeyInfo(ctx, keyID)
+}
+
+func (s *authorizedKeyManagementServer) ListKeys(ctx context.Context, req *pb.ListKeysRequest) (*pb.KeyList, error) {
+	if err := authorizeRPC(s.authz, s.cert, "ListKeys"); err != nil {
+		return nil, err
+	}
+	return s.kms.ListKeys(ctx, req)
+}
+
+// authorizedSignerServer runs the RPCs of a client on a SignerServer if the
+// authorizer allows it
+type authorizedSignerServer struct {
+	ss    pb.SignerServer
+	authz *ClientAuthorizer
+	cert  *x509.Certificate
+}
+
+func (s *authorizedSignerServer) Sign(ctx context.Context, sr *pb.SignatureRequest) (*pb.Signature, error) {
+	if err := authorizeRPC(s.authz, s.cert, "Sign"); err != nil {
+		return nil, err
+	}
+	return s.ss.Sign(ctx, sr)
+}
+
+func authorizeRPC(authz *ClientAuthorizer, cert *x509.Certificate, rpc string) error {
+	if err := authz.Authorize(cert, rpc); err != nil {
+		name := ""
+		if cert != nil {
+			name = cert.Subject.CommonName
+		}
+		log.Printf("[Notary-signer %s] : Refused client %q", rpc, name)
+		return grpc.Errorf(codes.PermissionDenied, "client %q is not allowed to call %s", name, rpc)
+	}
+	return nil
+}
+
+// connListener is a net.Listener accepting a single connection. Accept then
+// blocks until the connection is closed, so grpc.Server.Serve returns once
+// the connection is done.
+type connListener struct {
+	conn   net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{addr: conn.LocalAddr(), closed: make(chan struct{})}
+	l.conn = &notifyingConn{Conn: conn, l: l}
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	if conn := l.conn; conn != nil {
+		l.conn = nil
+		return conn, nil
+	}
+	<-l.closed
+	return nil, errors.New("notary-signer: connection closed")
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// notifyingConn closes its connListener when it is closed
+type notifyingConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *notifyingConn) Close() error {
+	c.l.Close()
+	return c.Conn.Close()
+}
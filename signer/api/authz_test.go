@@ -0,0 +1,207 @@
+package api_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/notary/signer"
+	"github.com/docker/notary/signer/api"
+	"github.com/docker/notary/signer/keys"
+	"github.com/endophage/gotuf/data"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// fixturesTime returns a time when the certificates in the fixtures are valid
+func fixturesTime() time.Time {
+	return time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func loadTestCert(t *testing.T, name string) *x509.Certificate {
+	cert, err := tls.LoadX509KeyPair("../../fixtures/"+name+".crt", "../../fixtures/"+name+".key")
+	assert.Nil(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	return parsed
+}
+
+func testCAPool(t *testing.T) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, name := range []string{"root-ca", "intermediate-ca"} {
+		raw, err := ioutil.ReadFile("../../fixtures/" + name + ".crt")
+		assert.Nil(t, err)
+		assert.True(t, pool.AppendCertsFromPEM(raw))
+	}
+	return pool
+}
+
+func TestNewClientAuthorizerRejectsUnknownRPC(t *testing.T) {
+	_, err := api.NewClientAuthorizer(map[string][]string{"Sing": {"notary-server"}})
+	assert.NotNil(t, err)
+}
+
+func TestClientAuthorizerAuthorize(t *testing.T) {
+	server := loadTestCert(t, "notary-server")
+	other := loadTestCert(t, "notary-signer")
+
+	authz, err := api.NewClientAuthorizer(map[string][]string{
+		"Sign":      {"notary-server"},
+		"DeleteKey": {"notaryserver"},
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, authz.Authorize(server, "Sign"))
+	assert.Equal(t, api.ErrClientNotAllowed, authz.Authorize(other, "Sign"))
+	assert.Equal(t, api.ErrClientNotAllowed, authz.Authorize(nil, "Sign"))
+	// DNS names of the certificate are identities too
+	assert.Nil(t, authz.Authorize(server, "DeleteKey"))
+	// RPCs missing from the allow-list are open to all clients
+	assert.Nil(t, authz.Authorize(other, "CreateKey"))
+
+	var none *api.ClientAuthorizer
+	assert.Nil(t, none.Authorize(other, "Sign"))
+}
+
+func TestAuthorizedHandlersRefuseClients(t *testing.T) {
+	authz, err := api.NewClientAuthorizer(map[string][]string{"GetKeyInfo": {"notary-server"}})
+	assert.Nil(t, err)
+	sigServices := signer.SigningServiceIndex{data.ED25519Key: api.NewEdDSASigningService(keys.NewKeyDB())}
+	handler := api.AuthorizedHandlers(sigServices, authz)
+
+	request := func(cert *x509.Certificate) int {
+		r, err := http.NewRequest("GET", "/nonexistent", nil)
+		assert.Nil(t, err)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(loadTestCert(t, "notary-signer")))
+	assert.Equal(t, http.StatusNotFound, request(loadTestCert(t, "notary-server")))
+}
+
+// setupAuthorizedServer serves the gRPC services on a TLS listener requiring
+// client certificates, and returns its address
+func setupAuthorizedServer(t *testing.T, authz *api.ClientAuthorizer, maxConns int) string {
+	sigServices := signer.SigningServiceIndex{data.ED25519Key: api.NewEdDSASigningService(keys.NewKeyDB())}
+	kms := &api.KeyManagementServer{SigServices: sigServices}
+	ss := &api.SignerServer{SigServices: sigServices}
+
+	cert, err := tls.LoadX509KeyPair("../../fixtures/notary-signer.crt", "../../fixtures/notary-signer.key")
+	assert.Nil(t, err)
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    testCAPool(t),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		Time:         fixturesTime,
+	})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go api.ServeAuthorized(creds.NewListener(lis), kms, ss, authz, maxConns)
+	return lis.Addr().String()
+}
+
+// dialAuthorizedServer connects to the server at addr, authenticating with
+// the certificate of the fixture named client if it isn't empty
+func dialAuthorizedServer(t *testing.T, addr, client string) (*grpc.ClientConn, error) {
+	config := &tls.Config{ServerName: "notary-signer", RootCAs: testCAPool(t), Time: fixturesTime}
+	if client != "" {
+		cert, err := tls.LoadX509KeyPair("../../fixtures/"+client+".crt", "../../fixtures/"+client+".key")
+		assert.Nil(t, err)
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)), grpc.WithTimeout(time.Second))
+}
+
+func TestServeAuthorized(t *testing.T) {
+	authz, err := api.NewClientAuthorizer(map[string][]string{"CreateKey": {"notary-server"}})
+	assert.Nil(t, err)
+	addr := setupAuthorizedServer(t, authz, 10)
+
+	conn, err := dialAuthorizedServer(t, addr, "notary-server")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	publicKey, err := pb.NewKeyManagementClient(conn).CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
+	assert.Nil(t, err)
+	assert.NotNil(t, publicKey)
+
+	conn, err = dialAuthorizedServer(t, addr, "notary-signer")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	client := pb.NewKeyManagementClient(conn)
+	_, err = client.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
+	assert.Equal(t, codes.PermissionDenied, grpc.Code(err))
+	// other RPCs are still allowed
+	info, err := client.GetKeyInfo(context.Background(), publicKey.KeyInfo.KeyID)
+	assert.Nil(t, err)
+	assert.Equal(t, publicKey.PublicKey, info.PublicKey)
+}
+
+func TestServeAuthorizedRequiresClientCert(t *testing.T) {
+	addr := setupAuthorizedServer(t, nil, 10)
+
+	// grpc keeps retrying failed connections, so the handshake is checked
+	// with a plain TLS connection
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName: "notary-signer",
+		RootCAs:    testCAPool(t),
+		Time:       fixturesTime,
+		NextProtos: []string{"h2"},
+	})
+	if err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err), "Expected the signer to refuse the connection")
+}
+
+func TestServeAuthorizedBoundsConnections(t *testing.T) {
+	addr := setupAuthorizedServer(t, nil, 1)
+
+	conn, err := dialAuthorizedServer(t, addr, "notary-server")
+	if !assert.Nil(t, err) {
+		return
+	}
+	// the second connection isn't accepted while the first one is open
+	_, err = dialAuthorizedServer(t, addr, "notary-server")
+	assert.NotNil(t, err, "Expected the signer to hold the connection back")
+
+	conn.Close()
+	conn, err = dialAuthorizedServer(t, addr, "notary-server")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = pb.NewKeyManagementClient(conn).CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
+	assert.Nil(t, err)
+}
+
+func TestServeAuthorizedRejectsInvalidMaxConns(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+	assert.NotNil(t, api.ServeAuthorized(lis, nil, nil, nil, 0))
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
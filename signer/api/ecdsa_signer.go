@@ -0,0 +1,31 @@
+package api
+
+import (
+	"crypto/sha256"
+
+	"github.com/docker/notary/cryptoservice"
+	"github.com/endophage/gotuf/data"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// ECDSASigner implements the Signer interface for ECDSA keys
+type ECDSASigner struct {
+	privateKey data.Key
+}
+
+// Sign returns a signature for a given blob, hashed with SHA256
+func (s *ECDSASigner) Sign(request *pb.SignatureRequest) (*pb.Signature, error) {
+	hashed := sha256.Sum256(request.Content)
+	sig, err := cryptoservice.ECDSASign(s.privateKey, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.Signature{KeyInfo: &pb.KeyInfo{KeyID: &pb.KeyID{ID: s.privateKey.ID()}, Algorithm: &pb.Algorithm{Algorithm: data.ECDSAKey.String()}}, Content: sig}, nil
+}
+
+// NewECDSASigner returns an ECDSASigner, given a private key
+func NewECDSASigner(key data.Key) *ECDSASigner {
+	return &ECDSASigner{privateKey: key}
+}
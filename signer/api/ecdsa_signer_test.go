@@ -0,0 +1,42 @@
+package api_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/docker/notary/signer/api"
+	"github.com/docker/notary/trustmanager"
+	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/docker/notary/proto"
+)
+
+func TestECDSASign(t *testing.T) {
+	key, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.Nil(t, err)
+
+	blob := []byte("test message")
+	signer := api.NewECDSASigner(key)
+	sig, err := signer.Sign(&pb.SignatureRequest{KeyID: &pb.KeyID{ID: key.ID()}, Content: blob})
+	assert.Nil(t, err)
+	assert.Equal(t, key.ID(), sig.KeyInfo.KeyID.ID)
+	assert.Equal(t, data.ECDSAKey.String(), sig.KeyInfo.Algorithm.Algorithm)
+	assert.Len(t, sig.Content, 64)
+
+	// the signature must be accepted by the verifier used for the TUF metadata
+	verifier := signed.Verifiers[data.SigAlgorithm(sig.KeyInfo.Algorithm.Algorithm)]
+	assert.NotNil(t, verifier)
+	pub := data.NewPublicKey(key.Algorithm(), key.Public())
+	assert.Nil(t, verifier.Verify(pub, sig.Content, blob))
+	assert.NotNil(t, verifier.Verify(pub, sig.Content, []byte("other message")))
+}
+
+func TestECDSASignWrongKeyType(t *testing.T) {
+	key := data.NewPrivateKey(data.ED25519Key, []byte("public"), []byte("private"))
+	signer := api.NewECDSASigner(key)
+
+	_, err := signer.Sign(&pb.SignatureRequest{KeyID: &pb.KeyID{ID: key.ID()}, Content: []byte("test message")})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"crypto/rand"
+
+	"github.com/docker/notary/signer"
+	"github.com/docker/notary/signer/keys"
+	"github.com/docker/notary/trustmanager"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// ECDSASigningService is an implementation of SigningService for P-256 ECDSA
+// keys held in software
+type ECDSASigningService struct {
+	KeyDB signer.KeyDatabase
+}
+
+// CreateKey creates a key and returns its public components
+func (s ECDSASigningService) CreateKey() (*pb.PublicKey, error) {
+	k, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.KeyDB.AddKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey := &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: &pb.KeyID{ID: k.ID()}, Algorithm: &pb.Algorithm{Algorithm: k.Algorithm().String()}}, PublicKey: k.Public()}
+
+	return pubKey, nil
+}
+
+// DeleteKey removes a key from the key database
+func (s ECDSASigningService) DeleteKey(keyID *pb.KeyID) (*pb.Void, error) {
+	return s.KeyDB.DeleteKey(keyID)
+}
+
+// KeyInfo returns the public components of a particular key
+func (s ECDSASigningService) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
+	return s.KeyDB.KeyInfo(keyID)
+}
+
+// ListKeys returns the IDs of all the keys in the key database
+func (s ECDSASigningService) ListKeys() []string {
+	return s.KeyDB.ListKeys()
+}
+
+// Signer returns a Signer for a specific KeyID
+func (s ECDSASigningService) Signer(keyID *pb.KeyID) (signer.Signer, error) {
+	key, err := s.KeyDB.GetKey(keyID)
+	if err != nil {
+		return nil, keys.ErrInvalidKeyID
+	}
+	return &ECDSASigner{privateKey: key}, nil
+}
+
+// NewECDSASigningService returns an ECDSASigningService storing its keys in
+// keyDB
+func NewECDSASigningService(keyDB signer.KeyDatabase) *ECDSASigningService {
+	return &ECDSASigningService{
+		KeyDB: keyDB,
+	}
+}
@@ -0,0 +1,48 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/docker/notary/signer/api"
+	"github.com/docker/notary/signer/keys"
+	"github.com/endophage/gotuf/data"
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/docker/notary/proto"
+)
+
+func TestECDSACreateKey(t *testing.T) {
+	keyDB := keys.NewKeyDB()
+	sigService := api.NewECDSASigningService(keyDB)
+
+	pubKey, err := sigService.CreateKey()
+	assert.Nil(t, err)
+	assert.Equal(t, data.ECDSAKey.String(), pubKey.KeyInfo.Algorithm.Algorithm)
+
+	// the key ID matches the one computed from the public key by clients
+	pub := data.NewPublicKey(data.ECDSAKey, pubKey.PublicKey)
+	assert.Equal(t, pub.ID(), pubKey.KeyInfo.KeyID.ID)
+
+	info, err := sigService.KeyInfo(pubKey.KeyInfo.KeyID)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKey.PublicKey, info.PublicKey)
+}
+
+func TestECDSASigner(t *testing.T) {
+	fakeKeyID := "830158bb5a4af00a3f689a8f29120f0fa7f8ae57cf00ce1fede8ae8652b5181a"
+	m := FakeKeyDB{}
+	sigService := api.NewECDSASigningService(&m)
+
+	m.On("GetKey", fakeKeyID).Return(&data.PrivateKey{}, nil).Once()
+	_, err := sigService.Signer(&pb.KeyID{ID: fakeKeyID})
+
+	m.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+func TestECDSASignerInvalidKeyID(t *testing.T) {
+	sigService := api.NewECDSASigningService(keys.NewKeyDB())
+
+	_, err := sigService.Signer(&pb.KeyID{ID: "nonexistent"})
+	assert.Equal(t, keys.ErrInvalidKeyID, err)
+}
@@ -44,6 +44,11 @@ func (s EdDSASigningService) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
 	return s.KeyDB.KeyInfo(keyID)
 }
 
+// ListKeys returns the IDs of all the keys in the key database
+func (s EdDSASigningService) ListKeys() []string {
+	return s.KeyDB.ListKeys()
+}
+
 // Signer returns a Signer for a specific KeyID
 func (s EdDSASigningService) Signer(keyID *pb.KeyID) (signer.Signer, error) {
 	key, err := s.KeyDB.GetKey(keyID)
@@ -36,6 +36,11 @@ func (m *FakeKeyDB) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
 	return args.Get(0).(*pb.PublicKey), args.Error(1)
 }
 
+func (m *FakeKeyDB) ListKeys() []string {
+	args := m.Mock.Called()
+	return args.Get(0).([]string)
+}
+
 func (m *FakeKeyDB) GetKey(keyID *pb.KeyID) (data.Key, error) {
 	args := m.Mock.Called(keyID.ID)
 	return args.Get(0).(data.Key), args.Error(1)
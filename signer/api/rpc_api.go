@@ -3,6 +3,8 @@ package api
 import (
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/docker/notary/signer"
 	"github.com/docker/notary/signer/keys"
@@ -15,28 +17,52 @@ import (
 	pb "github.com/docker/notary/proto"
 )
 
+const (
+	// defaultListKeysPageSize is the number of keys returned by ListKeys when
+	// the request doesn't give a page size
+	defaultListKeysPageSize = 100
+	// maxListKeysPageSize is the maximum number of keys returned by ListKeys
+	maxListKeysPageSize = 1000
+)
+
 //KeyManagementServer implements the KeyManagementServer grpc interface
 type KeyManagementServer struct {
 	SigServices signer.SigningServiceIndex
+	// KeyMetadata stores the metadata of the keys, which isn't recorded if
+	// it is nil
+	KeyMetadata signer.KeyMetadataStore
 }
 
 //SignerServer implements the SignerServer grpc interface
 type SignerServer struct {
 	SigServices signer.SigningServiceIndex
+	// KeyMetadata records when the keys are used, if it isn't nil
+	KeyMetadata signer.KeyMetadataStore
 }
 
 //CreateKey returns a PublicKey created using KeyManagementServer's SigningService
-func (s *KeyManagementServer) CreateKey(ctx context.Context, algorithm *pb.Algorithm) (*pb.PublicKey, error) {
-	service := s.SigServices[data.KeyAlgorithm(algorithm.Algorithm)]
+func (s *KeyManagementServer) CreateKey(ctx context.Context, req *pb.CreateKeyRequest) (*pb.PublicKey, error) {
+	service := s.SigServices[data.KeyAlgorithm(req.Algorithm)]
 
 	if service == nil {
-		return nil, fmt.Errorf("algorithm %s not supported for create key", algorithm.Algorithm)
+		return nil, fmt.Errorf("algorithm %s not supported for create key", req.Algorithm)
 	}
 
 	key, err := service.CreateKey()
 	if err != nil {
 		return nil, grpc.Errorf(codes.Internal, "Key creation failed")
 	}
+
+	if s.KeyMetadata != nil {
+		metadata := &pb.KeyMetadata{Gun: req.Gun, Role: req.Role, CreatedAt: time.Now().Unix()}
+		if err := s.KeyMetadata.SetMetadata(key.KeyInfo.KeyID.ID, metadata); err != nil {
+			// don't keep a key that can't be accounted for
+			log.Printf("[Notary-signer CreateKey] : Failed to record the metadata of KeyID %s: %v", key.KeyInfo.KeyID.ID, err)
+			service.DeleteKey(key.KeyInfo.KeyID)
+			return nil, grpc.Errorf(codes.Internal, "Key creation failed")
+		}
+		key.Metadata = metadata
+	}
 	log.Println("[Notary-signer CreateKey] : Created KeyID ", key.KeyInfo.KeyID.ID)
 	return key, nil
 }
@@ -60,6 +86,12 @@ func (s *KeyManagementServer) DeleteKey(ctx context.Context, keyID *pb.KeyID) (*
 		}
 	}
 
+	if s.KeyMetadata != nil {
+		if err := s.KeyMetadata.DeleteMetadata(keyID.ID); err != nil {
+			log.Printf("[Notary-signer DeleteKey] : Failed to delete the metadata of KeyID %s: %v", keyID.ID, err)
+		}
+	}
+
 	return &pb.Void{}, nil
 }
 
@@ -75,10 +107,68 @@ func (s *KeyManagementServer) GetKeyInfo(ctx context.Context, keyID *pb.KeyID) (
 	if err != nil {
 		return nil, grpc.Errorf(codes.NotFound, "Invalid keyID: key %s not found", keyID.ID)
 	}
+	if s.KeyMetadata != nil {
+		key.Metadata = s.KeyMetadata.GetMetadata(keyID.ID)
+	}
 	log.Println("[Notary-signer GetKeyInfo] : Returning PublicKey for KeyID ", keyID.ID)
 	return key, nil
 }
 
+//ListKeys returns a page of the keys of all the signing services matching the filters of the request, sorted by KeyID
+func (s *KeyManagementServer) ListKeys(ctx context.Context, req *pb.ListKeysRequest) (*pb.KeyList, error) {
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultListKeysPageSize
+	} else if pageSize > maxListKeysPageSize {
+		pageSize = maxListKeysPageSize
+	}
+
+	// a signing service may be registered for several algorithms
+	services := make(map[string]signer.SigningService)
+	for _, service := range s.SigServices {
+		for _, id := range service.ListKeys() {
+			services[id] = service
+		}
+	}
+	ids := make([]string, 0, len(services))
+	for id := range services {
+		// the page token is the ID of the last key of the previous page
+		if id > req.PageToken {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	list := &pb.KeyList{}
+	for _, id := range ids {
+		key, err := services[id].KeyInfo(&pb.KeyID{ID: id})
+		if err != nil {
+			// the key was deleted since it was listed
+			continue
+		}
+		if req.Algorithm != "" && key.KeyInfo.Algorithm.Algorithm != req.Algorithm {
+			continue
+		}
+		if s.KeyMetadata != nil {
+			key.Metadata = s.KeyMetadata.GetMetadata(id)
+		}
+		metadata := key.GetMetadata()
+		if metadata == nil {
+			metadata = &pb.KeyMetadata{}
+		}
+		if (req.Gun != "" && metadata.Gun != req.Gun) || (req.Role != "" && metadata.Role != req.Role) {
+			continue
+		}
+		if len(list.Keys) == pageSize {
+			list.NextPageToken = list.Keys[pageSize-1].KeyInfo.KeyID.ID
+			break
+		}
+		list.Keys = append(list.Keys, key)
+	}
+	log.Printf("[Notary-signer ListKeys] : Returning %d keys", len(list.Keys))
+	return list, nil
+}
+
 //Sign signs a message and returns the signature using a private key associate with the KeyID from the SignatureRequest
 func (s *SignerServer) Sign(ctx context.Context, sr *pb.SignatureRequest) (*pb.Signature, error) {
 	_, service, err := FindKeyByID(s.SigServices, sr.KeyID)
@@ -100,5 +190,11 @@ func (s *SignerServer) Sign(ctx context.Context, sr *pb.SignatureRequest) (*pb.S
 		return nil, grpc.Errorf(codes.Internal, "Signing failed for keyID %s on hash %s", sr.KeyID.ID, sr.Content)
 	}
 
+	if s.KeyMetadata != nil {
+		if err := s.KeyMetadata.KeyUsed(sr.KeyID.ID, time.Now()); err != nil {
+			log.Printf("[Notary-signer Sign] : Failed to record the use of KeyID %s: %v", sr.KeyID.ID, err)
+		}
+	}
+
 	return signature, nil
 }
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"testing"
 
 	"github.com/docker/notary/signer"
 	"github.com/docker/notary/signer/api"
 	"github.com/docker/notary/signer/keys"
 	"github.com/endophage/gotuf/data"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -30,8 +32,9 @@ func init() {
 	sigServices := signer.SigningServiceIndex{data.ED25519Key: sigService, data.RSAKey: sigService}
 	void = &pb.Void{}
 	//server setup
-	kms := &api.KeyManagementServer{SigServices: sigServices}
-	ss := &api.SignerServer{SigServices: sigServices}
+	keyMetadata, _ := keys.NewMetadataDB("")
+	kms := &api.KeyManagementServer{SigServices: sigServices, KeyMetadata: keyMetadata}
+	ss := &api.SignerServer{SigServices: sigServices, KeyMetadata: keyMetadata}
 	grpcServer = grpc.NewServer()
 	pb.RegisterKeyManagementServer(grpcServer, kms)
 	pb.RegisterSignerServer(grpcServer, ss)
@@ -62,7 +65,7 @@ func TestDeleteKeyHandlerReturnsNotFoundWithNonexistentKey(t *testing.T) {
 }
 
 func TestCreateKeyHandlerCreatesKey(t *testing.T) {
-	publicKey, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	assert.NotNil(t, publicKey)
 	assert.NotEmpty(t, publicKey.PublicKey)
 	assert.NotEmpty(t, publicKey.KeyInfo)
@@ -71,14 +74,14 @@ func TestCreateKeyHandlerCreatesKey(t *testing.T) {
 }
 
 func TestDeleteKeyHandlerDeletesCreatedKey(t *testing.T) {
-	publicKey, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	ret, err := kmClient.DeleteKey(context.Background(), publicKey.KeyInfo.KeyID)
 	assert.Nil(t, err)
 	assert.Equal(t, ret, void)
 }
 
 func TestKeyInfoReturnsCreatedKeys(t *testing.T) {
-	publicKey, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	fmt.Println("Pubkey ID: " + publicKey.GetKeyInfo().KeyID.ID)
 	returnedPublicKey, err := kmClient.GetKeyInfo(context.Background(), publicKey.KeyInfo.KeyID)
 	fmt.Println("returnedPublicKey ID: " + returnedPublicKey.GetKeyInfo().KeyID.ID)
@@ -89,9 +92,9 @@ func TestKeyInfoReturnsCreatedKeys(t *testing.T) {
 }
 
 func TestCreateKeyCreatesNewKeys(t *testing.T) {
-	publicKey1, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey1, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	assert.Nil(t, err)
-	publicKey2, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey2, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	assert.Nil(t, err)
 	assert.NotEqual(t, publicKey1, publicKey2)
 	assert.NotEqual(t, publicKey1.KeyInfo, publicKey2.KeyInfo)
@@ -111,7 +114,7 @@ func TestGetKeyInfoReturnsNotFoundOnNonexistKeys(t *testing.T) {
 func TestCreatedKeysCanBeUsedToSign(t *testing.T) {
 	message := []byte{0, 0, 0, 0}
 
-	publicKey, err := kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	publicKey, err := kmClient.CreateKey(context.Background(), &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String()})
 	assert.Nil(t, err)
 	assert.NotNil(t, publicKey)
 
@@ -135,3 +138,70 @@ func TestSignReturnsNotFoundOnNonexistKeys(t *testing.T) {
 	assert.Equal(t, grpc.Code(err), codes.NotFound)
 	assert.Nil(t, ret)
 }
+
+func TestCreateKeyAcceptsAlgorithm(t *testing.T) {
+	// older clients send an Algorithm to CreateKey
+	raw, err := proto.Marshal(&pb.Algorithm{Algorithm: data.ED25519Key.String()})
+	assert.Nil(t, err)
+	req := &pb.CreateKeyRequest{}
+	assert.Nil(t, proto.Unmarshal(raw, req))
+	assert.Equal(t, data.ED25519Key.String(), req.Algorithm)
+}
+
+func TestKeyInfoReturnsMetadata(t *testing.T) {
+	req := &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String(), Gun: "docker.com/notary/metadata", Role: "timestamp"}
+	publicKey, err := kmClient.CreateKey(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, req.Gun, publicKey.Metadata.Gun)
+
+	returnedPublicKey, err := kmClient.GetKeyInfo(context.Background(), publicKey.KeyInfo.KeyID)
+	assert.Nil(t, err)
+	assert.Equal(t, req.Gun, returnedPublicKey.Metadata.Gun)
+	assert.Equal(t, req.Role, returnedPublicKey.Metadata.Role)
+	assert.NotEqual(t, int64(0), returnedPublicKey.Metadata.CreatedAt)
+	assert.Equal(t, int64(0), returnedPublicKey.Metadata.LastUsedAt)
+
+	sr := &pb.SignatureRequest{Content: []byte{0, 0, 0, 0}, KeyID: publicKey.KeyInfo.KeyID}
+	_, err = sClient.Sign(context.Background(), sr)
+	assert.Nil(t, err)
+
+	returnedPublicKey, err = kmClient.GetKeyInfo(context.Background(), publicKey.KeyInfo.KeyID)
+	assert.Nil(t, err)
+	assert.NotEqual(t, int64(0), returnedPublicKey.Metadata.LastUsedAt, "The use of the key was not recorded")
+}
+
+func TestListKeys(t *testing.T) {
+	gun := "docker.com/notary/list"
+	var ids []string
+	for _, role := range []string{"timestamp", "snapshot", "timestamp"} {
+		req := &pb.CreateKeyRequest{Algorithm: data.ED25519Key.String(), Gun: gun, Role: role}
+		publicKey, err := kmClient.CreateKey(context.Background(), req)
+		assert.Nil(t, err)
+		ids = append(ids, publicKey.KeyInfo.KeyID.ID)
+	}
+	sort.Strings(ids)
+
+	// the keys of the GUN are listed in pages, sorted by ID
+	list, err := kmClient.ListKeys(context.Background(), &pb.ListKeysRequest{Gun: gun, PageSize: 2})
+	assert.Nil(t, err)
+	assert.Len(t, list.Keys, 2)
+	assert.Equal(t, ids[0], list.Keys[0].KeyInfo.KeyID.ID)
+	assert.Equal(t, ids[1], list.Keys[1].KeyInfo.KeyID.ID)
+	assert.Equal(t, gun, list.Keys[0].Metadata.Gun)
+	assert.Equal(t, ids[1], list.NextPageToken)
+
+	list, err = kmClient.ListKeys(context.Background(), &pb.ListKeysRequest{Gun: gun, PageSize: 2, PageToken: list.NextPageToken})
+	assert.Nil(t, err)
+	assert.Len(t, list.Keys, 1)
+	assert.Equal(t, ids[2], list.Keys[0].KeyInfo.KeyID.ID)
+	assert.Empty(t, list.NextPageToken)
+
+	list, err = kmClient.ListKeys(context.Background(), &pb.ListKeysRequest{Gun: gun, Role: "snapshot"})
+	assert.Nil(t, err)
+	assert.Len(t, list.Keys, 1)
+	assert.Equal(t, "snapshot", list.Keys[0].Metadata.Role)
+
+	list, err = kmClient.ListKeys(context.Background(), &pb.ListKeysRequest{Gun: gun, Algorithm: data.RSAKey.String()})
+	assert.Nil(t, err)
+	assert.Empty(t, list.Keys)
+}
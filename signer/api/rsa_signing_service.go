@@ -9,6 +9,8 @@ import (
 	"errors"
 	"log"
 	"math/big"
+	"sort"
+	"sync"
 
 	"github.com/docker/notary/signer"
 	"github.com/docker/notary/signer/keys"
@@ -17,38 +19,55 @@ import (
 	pb "github.com/docker/notary/proto"
 )
 
-// RSASigningService is an implementation of SigningService
+// RSASigningService is an implementation of SigningService for RSA keys held
+// in an HSM. The key pairs are token objects, so they persist in the HSM: the
+// keys already in the token are found when the service is created, and their
+// TUF key IDs are computed from their public keys.
 type RSASigningService struct {
-	keys    map[string]*keys.HSMRSAKey
+	lock    sync.RWMutex
+	keys    map[string]*hsmRSAKey
 	context *pkcs11.Ctx
 	session pkcs11.SessionHandle
 }
 
-// CreateKey creates a key and returns its public components
-func (s RSASigningService) CreateKey() (*pb.PublicKey, error) {
+// hsmRSAKey is an RSA key pair in the HSM
+type hsmRSAKey struct {
+	*keys.HSMRSAKey
+	// ckaID is the CKA_ID shared by the public and private key objects
+	ckaID []byte
+	// ckaLabel is the CKA_LABEL of the private key object, which is the
+	// TUF key ID for keys created by this service
+	ckaLabel string
+}
 
-	// For now generate random labels for keys
-	// (diogo): add link between keyID and label in database so we can support multiple keys
-	randomLabel := make([]byte, 32)
-	_, err := rand.Read(randomLabel)
+// CreateKey creates a key and returns its public components
+func (s *RSASigningService) CreateKey() (*pb.PublicKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The public and private key objects are linked by a random CKA_ID. The
+	// TUF key ID is only known once the key is generated, and is then set as
+	// the CKA_LABEL of both objects.
+	ckaID := make([]byte, 32)
+	_, err := rand.Read(ckaID)
 	if err != nil {
-		return nil, errors.New("Could not generate a random key label.")
+		return nil, errors.New("Could not generate a random key ID.")
 	}
 
 	// Set the public key template
 	// CKA_TOKEN: Guarantees key persistence in hardware
-	// CKA_LABEL: Identifies this specific key inside of the HSM
+	// CKA_ID: Identifies this specific key pair inside of the HSM
 	publicKeyTemplate := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
 		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{3}),
 		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, string(randomLabel)),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, ckaID),
 	}
 	privateKeyTemplate := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
 		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
 		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, string(randomLabel)),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, ckaID),
 	}
 
 	// Generate a new RSA private/public keypair inside of the HSM
@@ -59,6 +78,157 @@ func (s RSASigningService) CreateKey() (*pb.PublicKey, error) {
 		return nil, errors.New("Could not generate a new key inside of the HSM.")
 	}
 
+	pubBytes, err := s.publicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// (diogo): Ideally I would like to return base64 PEM encoded public keys to the client
+	k := &hsmRSAKey{HSMRSAKey: keys.NewHSMRSAKey(pubBytes, priv), ckaID: ckaID}
+
+	keyID := k.ID()
+
+	// The label only makes the keys easier to find with the HSM's tools, keys
+	// are looked up by their public key when the service starts
+	label := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyID)}
+	if err := s.context.SetAttributeValue(s.session, pub, label); err != nil {
+		log.Printf("Failed to label the public key %s: %s", keyID, err)
+	}
+	if err := s.context.SetAttributeValue(s.session, priv, label); err != nil {
+		log.Printf("Failed to label the private key %s: %s", keyID, err)
+	} else {
+		k.ckaLabel = keyID
+	}
+
+	s.keys[keyID] = k
+
+	pubKey := &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: &pb.KeyID{ID: keyID}, Algorithm: &pb.Algorithm{Algorithm: k.Algorithm().String()}}, PublicKey: k.Public()}
+
+	return pubKey, nil
+}
+
+// DeleteKey removes a key from the key database, destroying its objects in
+// the HSM
+func (s *RSASigningService) DeleteKey(keyID *pb.KeyID) (*pb.Void, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k, ok := s.keys[keyID.ID]
+	if !ok {
+		return nil, keys.ErrInvalidKeyID
+	}
+
+	pubs, err := s.findObjects(publicKeyTemplate(k.ckaID, k.ckaLabel))
+	if err != nil {
+		return nil, err
+	}
+	for _, pub := range pubs {
+		if err := s.context.DestroyObject(s.session, pub); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.context.DestroyObject(s.session, k.PKCS11ObjectHandle()); err != nil {
+		return nil, err
+	}
+
+	delete(s.keys, keyID.ID)
+	return nil, nil
+}
+
+// KeyInfo returns the public components of a particular key
+func (s *RSASigningService) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	k, ok := s.keys[keyID.ID]
+	if !ok {
+		return nil, keys.ErrInvalidKeyID
+	}
+
+	pubKey := &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: keyID, Algorithm: &pb.Algorithm{Algorithm: k.Algorithm().String()}}, PublicKey: k.Public()}
+
+	return pubKey, nil
+}
+
+// Signer returns a Signer for a specific KeyID
+func (s *RSASigningService) Signer(keyID *pb.KeyID) (signer.Signer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	key, ok := s.keys[keyID.ID]
+	if !ok {
+		return nil, keys.ErrInvalidKeyID
+	}
+	// TODO(diogo): Investigate if caching is worth it. Is this object expensive to create?
+	return &RSASigner{privateKey: key.HSMRSAKey, context: s.context, session: s.session}, nil
+}
+
+// ListKeys returns the IDs of all the keys in the HSM, sorted
+func (s *RSASigningService) ListKeys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// loadKeys finds the RSA private keys in the token, along with their public
+// keys, and indexes them by TUF key ID
+func (s *RSASigningService) loadKeys() error {
+	privs, err := s.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, priv := range privs {
+		attr, err := s.context.GetAttributeValue(s.session, priv, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		})
+		if err != nil {
+			return err
+		}
+		var (
+			ckaID    []byte
+			ckaLabel string
+		)
+		for _, a := range attr {
+			switch a.Type {
+			case pkcs11.CKA_ID:
+				ckaID = a.Value
+			case pkcs11.CKA_LABEL:
+				ckaLabel = string(a.Value)
+			}
+		}
+
+		pubs, err := s.findObjects(publicKeyTemplate(ckaID, ckaLabel))
+		if err != nil {
+			return err
+		}
+		if len(pubs) != 1 {
+			log.Printf("Skipping HSM private key with label %q: found %d matching public keys", ckaLabel, len(pubs))
+			continue
+		}
+		pubBytes, err := s.publicKey(pubs[0])
+		if err != nil {
+			return err
+		}
+
+		k := &hsmRSAKey{HSMRSAKey: keys.NewHSMRSAKey(pubBytes, priv), ckaID: ckaID, ckaLabel: ckaLabel}
+		s.keys[k.ID()] = k
+	}
+	return nil
+}
+
+// publicKey returns the DER encoded public key of an RSA public key object
+func (s *RSASigningService) publicKey(pub pkcs11.ObjectHandle) ([]byte, error) {
 	// (diogo): This template is used for the GetAttribute
 	template := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
@@ -93,49 +263,41 @@ func (s RSASigningService) CreateKey() (*pb.PublicKey, error) {
 	if err != nil {
 		return nil, errors.New("Failed to Marshal public key.")
 	}
-
-	// (diogo): Ideally I would like to return base64 PEM encoded public keys to the client
-	k := keys.NewHSMRSAKey(pubBytes, priv)
-
-	keyID := k.ID()
-
-	s.keys[keyID] = k
-
-	pubKey := &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: &pb.KeyID{ID: keyID}, Algorithm: &pb.Algorithm{Algorithm: k.Algorithm().String()}}, PublicKey: k.Public()}
-
-	return pubKey, nil
+	return pubBytes, nil
 }
 
-// DeleteKey removes a key from the key database
-func (s RSASigningService) DeleteKey(keyID *pb.KeyID) (*pb.Void, error) {
-	if _, ok := s.keys[keyID.ID]; !ok {
-		return nil, keys.ErrInvalidKeyID
+// findObjects returns the handles of all the objects matching template
+func (s *RSASigningService) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := s.context.FindObjectsInit(s.session, template); err != nil {
+		return nil, err
 	}
+	defer s.context.FindObjectsFinal(s.session)
 
-	delete(s.keys, keyID.ID)
-	return nil, nil
-}
-
-// KeyInfo returns the public components of a particular key
-func (s RSASigningService) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
-	k, ok := s.keys[keyID.ID]
-	if !ok {
-		return nil, keys.ErrInvalidKeyID
+	var handles []pkcs11.ObjectHandle
+	for {
+		found, _, err := s.context.FindObjects(s.session, 100)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return handles, nil
+		}
+		handles = append(handles, found...)
 	}
-
-	pubKey := &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: keyID, Algorithm: &pb.Algorithm{Algorithm: k.Algorithm().String()}}, PublicKey: k.Public()}
-
-	return pubKey, nil
 }
 
-// Signer returns a Signer for a specific KeyID
-func (s RSASigningService) Signer(keyID *pb.KeyID) (signer.Signer, error) {
-	key, ok := s.keys[keyID.ID]
-	if !ok {
-		return nil, keys.ErrInvalidKeyID
+// publicKeyTemplate returns the template matching the public key paired with
+// a private key. Keys created before the key pairs were given a CKA_ID only
+// share a random CKA_LABEL.
+func publicKeyTemplate(ckaID []byte, ckaLabel string) []*pkcs11.Attribute {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
 	}
-	// TODO(diogo): Investigate if caching is worth it. Is this object expensive to create?
-	return &RSASigner{privateKey: key, context: s.context, session: s.session}, nil
+	if len(ckaID) > 0 {
+		return append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, ckaID))
+	}
+	return append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, ckaLabel))
 }
 
 // RSASigner implements the Signer interface for RSA keys
@@ -192,13 +354,18 @@ func (s *RSASigner) Sign(request *pb.SignatureRequest) (*pb.Signature, error) {
 	return returnSig, nil
 }
 
-// NewRSASigningService returns an instance of KeyDB
-func NewRSASigningService(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) *RSASigningService {
-	return &RSASigningService{
-		keys:    make(map[string]*keys.HSMRSAKey),
+// NewRSASigningService returns an RSASigningService using the HSM session,
+// loaded with the RSA keys already in the token
+func NewRSASigningService(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) (*RSASigningService, error) {
+	s := &RSASigningService{
+		keys:    make(map[string]*hsmRSAKey),
 		context: ctx,
 		session: session,
 	}
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // readInt converts a []byte into an int. It is used to convert the RSA Public key exponent into an int to create a crypto.PublicKey
@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+
+	"github.com/docker/notary/signer/api"
+	"github.com/docker/notary/signer/keys"
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// These tests run against SoftHSM, see SetupHSMEnv
+
+// teardownHSMEnv logs out and releases the library, as when the signer stops
+func teardownHSMEnv(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) {
+	ctx.Logout(session)
+	ctx.CloseSession(session)
+	ctx.Finalize()
+	ctx.Destroy()
+}
+
+// findByLabel returns the objects in the token labelled with label
+func findByLabel(t *testing.T, ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) []pkcs11.ObjectHandle {
+	err := ctx.FindObjectsInit(session, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, label)})
+	assert.Nil(t, err)
+	defer ctx.FindObjectsFinal(session)
+	objects, _, err := ctx.FindObjects(session, 10)
+	assert.Nil(t, err)
+	return objects
+}
+
+func TestHSMKeysSurviveRestart(t *testing.T) {
+	ctx, session := SetupHSMEnv(t)
+	sigService, err := api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
+
+	key, err := sigService.CreateKey()
+	assert.Nil(t, err)
+	keyID := key.KeyInfo.KeyID
+
+	// the key pair is labelled with the TUF key ID
+	assert.Len(t, findByLabel(t, ctx, session, keyID.ID), 2)
+
+	teardownHSMEnv(ctx, session)
+
+	ctx, session = SetupHSMEnv(t)
+	defer teardownHSMEnv(ctx, session)
+	sigService, err = api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
+	defer sigService.DeleteKey(keyID)
+
+	info, err := sigService.KeyInfo(keyID)
+	assert.Nil(t, err, "The key was not found after restarting")
+	assert.Equal(t, key.PublicKey, info.PublicKey)
+
+	signer, err := sigService.Signer(keyID)
+	assert.Nil(t, err)
+	blob := []byte("test message")
+	sig, err := signer.Sign(&pb.SignatureRequest{KeyID: keyID, Content: blob})
+	assert.Nil(t, err)
+
+	pub, err := x509.ParsePKIXPublicKey(info.PublicKey)
+	assert.Nil(t, err)
+	digest := sha256.Sum256(blob)
+	err = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig.Content)
+	assert.Nil(t, err, "The signature did not verify")
+}
+
+func TestHSMDeleteKey(t *testing.T) {
+	ctx, session := SetupHSMEnv(t)
+	sigService, err := api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
+
+	key, err := sigService.CreateKey()
+	assert.Nil(t, err)
+	keyID := key.KeyInfo.KeyID
+
+	_, err = sigService.DeleteKey(keyID)
+	assert.Nil(t, err)
+	_, err = sigService.KeyInfo(keyID)
+	assert.Equal(t, keys.ErrInvalidKeyID, err)
+
+	// the key pair is destroyed in the token
+	assert.Len(t, findByLabel(t, ctx, session, keyID.ID), 0)
+
+	teardownHSMEnv(ctx, session)
+
+	ctx, session = SetupHSMEnv(t)
+	defer teardownHSMEnv(ctx, session)
+	sigService, err = api.NewRSASigningService(ctx, session)
+	assert.Nil(t, err)
+
+	_, err = sigService.KeyInfo(keyID)
+	assert.Equal(t, keys.ErrInvalidKeyID, err, "The deleted key was found after restarting")
+	_, err = sigService.DeleteKey(keyID)
+	assert.Equal(t, keys.ErrInvalidKeyID, err)
+}
@@ -0,0 +1,311 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	pb "github.com/docker/notary/proto"
+	"github.com/endophage/gotuf/data"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// KEKSize is the size of the key-encryption keys of a FileKeyDB
+	KEKSize = 32
+
+	keyDBInfoFile = "keydb.json"
+	keyFileExt    = ".key"
+
+	kdfIterations = 100000
+	kdfSaltSize   = 16
+
+	// checkAD authenticates the check value of a FileKeyDB, which is used
+	// to detect a wrong key-encryption key
+	checkAD = "notary-signer key database"
+)
+
+// ErrWrongKEK is returned when opening a FileKeyDB with a key-encryption key
+// other than the one its keys are encrypted with
+var ErrWrongKEK = errors.New("notary-signer: wrong key-encryption key for the key database")
+
+// FileKeyDB is a key database persisted in a directory, so the keys created
+// by the signer survive restarts. Each private key is stored in its own file,
+// encrypted with AES-256-GCM under a key-encryption key (KEK), and
+// authenticated along with its key ID so key files can't be swapped. The keys
+// are decrypted once when the database is opened, and kept in memory.
+type FileKeyDB struct {
+	lock sync.RWMutex
+	dir  string
+	aead cipher.AEAD
+	keys map[string]data.Key
+}
+
+// keyDBInfo is stored in the directory of a FileKeyDB
+type keyDBInfo struct {
+	// Salt and Iterations are set when the KEK is derived from a passphrase
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	// Check is an empty value sealed with the KEK
+	Check []byte `json:"check,omitempty"`
+}
+
+// encryptedKey is a key stored by a FileKeyDB
+type encryptedKey struct {
+	Algorithm data.KeyAlgorithm `json:"algorithm"`
+	Public    []byte            `json:"public"`
+	// Private is the nonce followed by the encrypted private key
+	Private []byte `json:"private"`
+}
+
+// NewFileKeyDB opens the key database in dir, creating it if it doesn't
+// exist, and decrypts its keys with kek. ErrWrongKEK is returned if the keys
+// were encrypted with another KEK.
+func NewFileKeyDB(dir string, kek []byte) (*FileKeyDB, error) {
+	if len(kek) != KEKSize {
+		return nil, errors.New("notary-signer: the key-encryption key must be 32 bytes")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	db := &FileKeyDB{
+		dir:  dir,
+		aead: aead,
+		keys: make(map[string]data.Key),
+	}
+
+	info, err := readKeyDBInfo(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Check == nil {
+		if info.Check, err = db.seal(nil, checkAD); err != nil {
+			return nil, err
+		}
+		if err := writeJSONFile(filepath.Join(dir, keyDBInfoFile), info); err != nil {
+			return nil, err
+		}
+	} else if _, err := db.open(info.Check, checkAD); err != nil {
+		return nil, ErrWrongKEK
+	}
+
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// DeriveKEK derives the key-encryption key of the key database in dir from a
+// passphrase, with PBKDF2-HMAC-SHA256. The salt is generated and stored in
+// dir the first time.
+func DeriveKEK(dir, passphrase string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	info, err := readKeyDBInfo(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Salt == nil {
+		if info.Check != nil {
+			return nil, errors.New("notary-signer: the key database is encrypted with a key-encryption key, not a passphrase")
+		}
+		info.Salt = make([]byte, kdfSaltSize)
+		if _, err := io.ReadFull(rand.Reader, info.Salt); err != nil {
+			return nil, err
+		}
+		info.Iterations = kdfIterations
+		if err := writeJSONFile(filepath.Join(dir, keyDBInfoFile), info); err != nil {
+			return nil, err
+		}
+	}
+	return pbkdf2.Key([]byte(passphrase), info.Salt, info.Iterations, KEKSize, sha256.New), nil
+}
+
+// CreateKey is needed to implement KeyManager. Returns an empty key.
+func (db *FileKeyDB) CreateKey() (*pb.PublicKey, error) {
+	k := &pb.PublicKey{}
+
+	return k, nil
+}
+
+// AddKey encrypts a new key and adds it to the database
+func (db *FileKeyDB) AddKey(key data.Key) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, ok := db.keys[key.ID()]; ok {
+		return ErrExists
+	}
+	private, err := db.seal(key.Private(), key.ID())
+	if err != nil {
+		return err
+	}
+	stored := &encryptedKey{
+		Algorithm: key.Algorithm(),
+		Public:    key.Public(),
+		Private:   private,
+	}
+	if err := writeJSONFile(db.keyPath(key.ID()), stored); err != nil {
+		return err
+	}
+	db.keys[key.ID()] = key
+	return nil
+}
+
+// GetKey returns the private bits of a key
+func (db *FileKeyDB) GetKey(keyID *pb.KeyID) (data.Key, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if key, ok := db.keys[keyID.ID]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidKeyID
+}
+
+// DeleteKey deletes the keyID from the database
+func (db *FileKeyDB) DeleteKey(keyID *pb.KeyID) (*pb.Void, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, ok := db.keys[keyID.ID]; !ok {
+		return nil, ErrInvalidKeyID
+	}
+	if err := os.Remove(db.keyPath(keyID.ID)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	delete(db.keys, keyID.ID)
+	return nil, nil
+}
+
+// KeyInfo returns the public bits of a key, given a specific keyID
+func (db *FileKeyDB) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
+	key, err := db.GetKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: keyID, Algorithm: &pb.Algorithm{Algorithm: key.Algorithm().String()}}, PublicKey: key.Public()}, nil
+}
+
+// ListKeys returns the IDs of all the keys in the database, sorted
+func (db *FileKeyDB) ListKeys() []string {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	ids := make([]string, 0, len(db.keys))
+	for id := range db.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// load decrypts all the keys stored in the directory
+func (db *FileKeyDB) load() error {
+	files, err := ioutil.ReadDir(db.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), keyFileExt) {
+			continue
+		}
+		id := strings.TrimSuffix(f.Name(), keyFileExt)
+		raw, err := ioutil.ReadFile(filepath.Join(db.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		stored := &encryptedKey{}
+		if err := json.Unmarshal(raw, stored); err != nil {
+			return err
+		}
+		private, err := db.open(stored.Private, id)
+		if err != nil {
+			return errors.New("notary-signer: could not decrypt key " + id)
+		}
+		key := data.NewPrivateKey(stored.Algorithm, stored.Public, private)
+		if key.ID() != id {
+			return errors.New("notary-signer: key " + id + " is stored under the wrong ID")
+		}
+		db.keys[id] = key
+	}
+	return nil
+}
+
+func (db *FileKeyDB) keyPath(keyID string) string {
+	return filepath.Join(db.dir, filepath.Base(keyID)+keyFileExt)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext
+func (db *FileKeyDB) seal(plaintext []byte, ad string) ([]byte, error) {
+	nonce := make([]byte, db.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return db.aead.Seal(nonce, nonce, plaintext, []byte(ad)), nil
+}
+
+// open decrypts and authenticates a value encrypted by seal
+func (db *FileKeyDB) open(sealed []byte, ad string) ([]byte, error) {
+	size := db.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("notary-signer: encrypted value is too short")
+	}
+	return db.aead.Open(nil, sealed[:size], sealed[size:], []byte(ad))
+}
+
+func readKeyDBInfo(dir string) (*keyDBInfo, error) {
+	info := &keyDBInfo{}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, keyDBInfoFile))
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// writeJSONFile atomically replaces the file at path with v encoded as JSON
+func writeJSONFile(path string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
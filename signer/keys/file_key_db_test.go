@@ -0,0 +1,92 @@
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agl/ed25519"
+	pb "github.com/docker/notary/proto"
+	"github.com/endophage/gotuf/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKey(t *testing.T) data.Key {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return data.NewPrivateKey(data.ED25519Key, pub[:], priv[:])
+}
+
+func TestFileKeyDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary-signer-test-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	kek := make([]byte, KEKSize)
+	_, err = rand.Read(kek)
+	assert.Nil(t, err)
+
+	db, err := NewFileKeyDB(dir, kek)
+	assert.Nil(t, err)
+	key := newTestKey(t)
+	other := newTestKey(t)
+	assert.Nil(t, db.AddKey(key))
+	assert.Nil(t, db.AddKey(other))
+	assert.Equal(t, ErrExists, db.AddKey(key))
+
+	// the private key isn't stored in the clear
+	raw, err := ioutil.ReadFile(filepath.Join(dir, key.ID()+keyFileExt))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(raw), hex.EncodeToString(key.Private()))
+
+	_, err = db.DeleteKey(&pb.KeyID{ID: other.ID()})
+	assert.Nil(t, err)
+	_, err = db.DeleteKey(&pb.KeyID{ID: other.ID()})
+	assert.Equal(t, ErrInvalidKeyID, err)
+
+	// the keys survive reopening the database
+	db, err = NewFileKeyDB(dir, kek)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{key.ID()}, db.ListKeys())
+	stored, err := db.GetKey(&pb.KeyID{ID: key.ID()})
+	assert.Nil(t, err)
+	assert.Equal(t, key.Private(), stored.Private())
+	info, err := db.KeyInfo(&pb.KeyID{ID: key.ID()})
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), info.PublicKey)
+	_, err = db.GetKey(&pb.KeyID{ID: other.ID()})
+	assert.Equal(t, ErrInvalidKeyID, err)
+
+	// a different KEK is rejected
+	wrong := make([]byte, KEKSize)
+	_, err = NewFileKeyDB(dir, wrong)
+	assert.Equal(t, ErrWrongKEK, err)
+}
+
+func TestFileKeyDBPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary-signer-test-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	kek, err := DeriveKEK(dir, "passphrase")
+	assert.Nil(t, err)
+	db, err := NewFileKeyDB(dir, kek)
+	assert.Nil(t, err)
+	key := newTestKey(t)
+	assert.Nil(t, db.AddKey(key))
+
+	// the salt is kept, so the passphrase derives the same KEK
+	kek, err = DeriveKEK(dir, "passphrase")
+	assert.Nil(t, err)
+	db, err = NewFileKeyDB(dir, kek)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{key.ID()}, db.ListKeys())
+
+	kek, err = DeriveKEK(dir, "wrong passphrase")
+	assert.Nil(t, err)
+	_, err = NewFileKeyDB(dir, kek)
+	assert.Equal(t, ErrWrongKEK, err)
+}
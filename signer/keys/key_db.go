@@ -1,6 +1,8 @@
 package keys
 
 import (
+	"sort"
+
 	pb "github.com/docker/notary/proto"
 	"github.com/endophage/gotuf/data"
 )
@@ -54,6 +56,16 @@ func (db *KeyDB) KeyInfo(keyID *pb.KeyID) (*pb.PublicKey, error) {
 	return &pb.PublicKey{KeyInfo: &pb.KeyInfo{KeyID: keyID, Algorithm: &pb.Algorithm{Algorithm: key.Algorithm().String()}}, PublicKey: key.Public()}, nil
 }
 
+// ListKeys returns the IDs of all the keys in the database, sorted
+func (db *KeyDB) ListKeys() []string {
+	ids := make([]string, 0, len(db.keys))
+	for id := range db.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // NewKeyDB returns an instance of KeyDB
 func NewKeyDB() *KeyDB {
 	return &KeyDB{
@@ -0,0 +1,118 @@
+package keys
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "github.com/docker/notary/proto"
+)
+
+// lastUsedResolution is the precision of the last-used times of the keys, so
+// a key signing often doesn't cause a write each time
+const lastUsedResolution = time.Minute
+
+// MetadataDB stores the metadata of keys, indexed by key ID. It is kept in
+// memory, and persisted in a JSON file if it has a path.
+type MetadataDB struct {
+	lock     sync.RWMutex
+	path     string
+	metadata map[string]pb.KeyMetadata
+}
+
+// NewMetadataDB returns a MetadataDB persisted in the file at path, which is
+// read if it exists. The metadata is only kept in memory if path is empty.
+func NewMetadataDB(path string) (*MetadataDB, error) {
+	db := &MetadataDB{
+		path:     path,
+		metadata: make(map[string]pb.KeyMetadata),
+	}
+	if path == "" {
+		return db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &db.metadata); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// GetMetadata returns the metadata of a key, which is empty if none was
+// recorded
+func (db *MetadataDB) GetMetadata(keyID string) *pb.KeyMetadata {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	m := db.metadata[keyID]
+	return &m
+}
+
+// SetMetadata records the metadata of a key, replacing any previous metadata
+func (db *MetadataDB) SetMetadata(keyID string, metadata *pb.KeyMetadata) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.update(keyID, *metadata)
+}
+
+// KeyUsed records that a key was used to sign at time t. The time is only
+// updated if the key wasn't used in the last minute.
+func (db *MetadataDB) KeyUsed(keyID string, t time.Time) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	m := db.metadata[keyID]
+	if t.Sub(time.Unix(m.LastUsedAt, 0)) < lastUsedResolution {
+		return nil
+	}
+	m.LastUsedAt = t.Unix()
+	return db.update(keyID, m)
+}
+
+// DeleteMetadata removes the metadata of a key
+func (db *MetadataDB) DeleteMetadata(keyID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	old, ok := db.metadata[keyID]
+	if !ok {
+		return nil
+	}
+	delete(db.metadata, keyID)
+	if err := db.write(); err != nil {
+		db.metadata[keyID] = old
+		return err
+	}
+	return nil
+}
+
+// update sets the metadata of a key and persists it, leaving the previous
+// metadata in place if the write fails. The lock must be held.
+func (db *MetadataDB) update(keyID string, m pb.KeyMetadata) error {
+	old, existed := db.metadata[keyID]
+	db.metadata[keyID] = m
+	if err := db.write(); err != nil {
+		if existed {
+			db.metadata[keyID] = old
+		} else {
+			delete(db.metadata, keyID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *MetadataDB) write() error {
+	if db.path == "" {
+		return nil
+	}
+	return writeJSONFile(db.path, db.metadata)
+}
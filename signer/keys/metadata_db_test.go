@@ -0,0 +1,43 @@
+package keys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/docker/notary/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary-signer-test-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata.json")
+
+	db, err := NewMetadataDB(path)
+	assert.Nil(t, err)
+	assert.Equal(t, &pb.KeyMetadata{}, db.GetMetadata("unknown"))
+
+	created := time.Now()
+	err = db.SetMetadata("key1", &pb.KeyMetadata{Gun: "gun", Role: "timestamp", CreatedAt: created.Unix()})
+	assert.Nil(t, err)
+	assert.Nil(t, db.SetMetadata("key2", &pb.KeyMetadata{Gun: "gun", Role: "snapshot"}))
+	assert.Nil(t, db.KeyUsed("key1", created))
+	// uses within a minute aren't recorded
+	assert.Nil(t, db.KeyUsed("key1", created.Add(30*time.Second)))
+	assert.Equal(t, created.Unix(), db.GetMetadata("key1").LastUsedAt)
+	assert.Nil(t, db.DeleteMetadata("key2"))
+
+	// the metadata is persisted
+	db, err = NewMetadataDB(path)
+	assert.Nil(t, err)
+	m := db.GetMetadata("key1")
+	assert.Equal(t, "gun", m.Gun)
+	assert.Equal(t, "timestamp", m.Role)
+	assert.Equal(t, created.Unix(), m.CreatedAt)
+	assert.Equal(t, created.Unix(), m.LastUsedAt)
+	assert.Equal(t, &pb.KeyMetadata{}, db.GetMetadata("key2"))
+}
@@ -1,8 +1,11 @@
 package signer
 
 import (
+	"time"
+
 	pb "github.com/docker/notary/proto"
 	"github.com/endophage/gotuf/data"
+	"github.com/endophage/gotuf/signed"
 )
 
 // SigningService is the interface to implement a key management and signing service
@@ -11,6 +14,9 @@ type SigningService interface {
 
 	// Signer returns a Signer for a given keyID
 	Signer(keyID *pb.KeyID) (Signer, error)
+
+	// ListKeys returns the IDs of all the keys of the service, sorted
+	ListKeys() []string
 }
 
 // SigningServiceIndex represents a mapping between a service algorithm string
@@ -43,4 +49,40 @@ type KeyDatabase interface {
 
 	// AddKey allows the direct addition and removal of keys from the database
 	AddKey(key data.Key) error
+
+	// ListKeys returns the IDs of all the keys in the database, sorted
+	ListKeys() []string
+}
+
+// KeyMetadataStore is the interface to implement the storage of the metadata
+// of the keys of all the signing services
+type KeyMetadataStore interface {
+	// GetMetadata returns the metadata of a key, which is empty if none was
+	// recorded
+	GetMetadata(keyID string) *pb.KeyMetadata
+
+	// SetMetadata records the metadata of a key, replacing any previous
+	// metadata
+	SetMetadata(keyID string, metadata *pb.KeyMetadata) error
+
+	// KeyUsed records that a key was used to sign at a given time
+	KeyUsed(keyID string, t time.Time) error
+
+	// DeleteMetadata removes the metadata of a key
+	DeleteMetadata(keyID string) error
+}
+
+// GUNKeyCreator is implemented by crypto services that can record the GUN a
+// key is created for, such as NotarySigner
+type GUNKeyCreator interface {
+	CreateForGUN(gun, role string, algorithm data.KeyAlgorithm) (*data.PublicKey, error)
+}
+
+// CreateKeyForGUN creates a key for a role of a GUN with the crypto service,
+// which records the GUN if it is a GUNKeyCreator
+func CreateKeyForGUN(cs signed.CryptoService, gun, role string, algorithm data.KeyAlgorithm) (*data.PublicKey, error) {
+	if creator, ok := cs.(GUNKeyCreator); ok {
+		return creator.CreateForGUN(gun, role, algorithm)
+	}
+	return cs.Create(role, algorithm)
 }
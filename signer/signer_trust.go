@@ -1,6 +1,10 @@
 package signer
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
 
 	"github.com/Sirupsen/logrus"
@@ -17,15 +21,18 @@ type NotarySigner struct {
 	sClient  pb.SignerClient
 }
 
-// NewNotarySigner is a convinience method that returns NotarySigner
-func NewNotarySigner(hostname string, port string, tlscafile string) *NotarySigner {
+// NewNotarySigner is a convinience method that returns NotarySigner. The
+// signer's certificate is verified with the CAs in tlscafile. If tlscertfile
+// and tlskeyfile are given, the client authenticates to the signer with that
+// certificate, which the signer needs if it requires client certificates.
+func NewNotarySigner(hostname string, port string, tlscafile string, tlscertfile string, tlskeyfile string) *NotarySigner {
 	var opts []grpc.DialOption
 	netAddr := net.JoinHostPort(hostname, port)
-	creds, err := credentials.NewClientTLSFromFile(tlscafile, hostname)
+	tlsConfig, err := clientTLSConfig(hostname, tlscafile, tlscertfile, tlskeyfile)
 	if err != nil {
 		logrus.Fatal("fail to read: ", err)
 	}
-	opts = append(opts, grpc.WithTransportCredentials(creds))
+	opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	conn, err := grpc.Dial(netAddr, opts...)
 
 	if err != nil {
@@ -62,9 +69,16 @@ func (trust *NotarySigner) Sign(keyIDs []string, toSign []byte) ([]data.Signatur
 }
 
 // Create creates a remote key and returns the PublicKey associated with the remote private key
-// TODO(diogo): Ignoring algorithm for now until notary-signer supports it
 func (trust *NotarySigner) Create(role string, algorithm data.KeyAlgorithm) (*data.PublicKey, error) {
-	publicKey, err := trust.kmClient.CreateKey(context.Background(), &pb.Algorithm{Algorithm: algorithm.String()})
+	return trust.CreateForGUN("", role, algorithm)
+}
+
+// CreateForGUN creates a remote key for a role of a GUN, which the signer
+// records in the metadata of the key, and returns the PublicKey associated
+// with the remote private key
+func (trust *NotarySigner) CreateForGUN(gun, role string, algorithm data.KeyAlgorithm) (*data.PublicKey, error) {
+	req := &pb.CreateKeyRequest{Algorithm: algorithm.String(), Gun: gun, Role: role}
+	publicKey, err := trust.kmClient.CreateKey(context.Background(), req)
 	if err != nil {
 		return nil, err
 	}
@@ -87,3 +101,29 @@ func (trust *NotarySigner) PublicKeys(keyIDs ...string) (map[string]*data.Public
 	}
 	return publicKeys, nil
 }
+
+// clientTLSConfig returns the TLS configuration of the connections to the
+// signer, with a client certificate if tlscertfile and tlskeyfile are given
+func clientTLSConfig(hostname, tlscafile, tlscertfile, tlskeyfile string) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(tlscafile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", tlscafile)
+	}
+	tlsConfig := &tls.Config{
+		ServerName: hostname,
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tlscertfile != "" || tlskeyfile != "" {
+		cert, err := tls.LoadX509KeyPair(tlscertfile, tlskeyfile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -56,18 +56,21 @@ func (root *rootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		var err error
 		access := buildAccessRecords(vars["imageName"], root.actions...)
 		if ctx, err = root.auth.Authorized(ctx, access...); err != nil {
-			if err, ok := err.(auth.Challenge); ok {
-				err.ServeHTTP(w, r)
-				w.WriteHeader(http.StatusUnauthorized)
-				return
+			if challenge, ok := err.(auth.Challenge); ok {
+				// sets the challenge headers, the body is left to us
+				challenge.ServeHTTP(w, r)
 			}
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			errors.ServeJSON(w, &errors.HTTPError{
+				HTTPStatus: http.StatusUnauthorized,
+				Code:       errors.ErrorCodeUnauthorized,
+				Err:        err,
+			})
 			return
 		}
 	}
 	if err := root.handler(ctx, w, r); err != nil {
 		logrus.Error("[Notary Server] ", err.Error())
-		http.Error(w, err.Error(), err.HTTPStatus)
+		errors.ServeJSON(w, err)
 		return
 	}
 	return
@@ -22,7 +22,7 @@ func MockContextHandler(ctx context.Context, w http.ResponseWriter, r *http.Requ
 func MockBetterErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *errors.HTTPError {
 	return &errors.HTTPError{
 		HTTPStatus: http.StatusInternalServerError,
-		Code:       9999,
+		Code:       errors.ErrorCodeUnknown,
 		Err:        fmt.Errorf("TestError"),
 	}
 }
@@ -77,8 +77,11 @@ func TestRootHandlerError(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if res.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatalf("Expected a JSON error, received %s", res.Header.Get("Content-Type"))
+	}
 	contentStr := strings.Trim(string(content), "\r\n\t ")
-	if contentStr != "9999: TestError" {
+	if contentStr != `{"errors":[{"code":"UNKNOWN","message":"TestError"}]}` {
 		t.Fatalf("Error Body Incorrect: `%s`", content)
 	}
 }